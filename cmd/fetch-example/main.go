@@ -3,11 +3,15 @@ package main
 import (
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 )
 
 func main() {
-	resp, _ := http.Get("http://localhost:8080/v1/tasks/")
+	resp, err := http.Get("http://localhost:8080/v1/tasks/")
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer resp.Body.Close()
 	b, _ := io.ReadAll(resp.Body)
 	fmt.Println(string(b))
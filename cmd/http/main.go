@@ -3,54 +3,361 @@ package main
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/anon-org/developing-api-services-with-golang/config"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/migrations"
 	"github.com/anon-org/developing-api-services-with-golang/task"
+	"github.com/anon-org/developing-api-services-with-golang/util/idutil"
 	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/metricutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	dbFileName         = "production.db.out"
-	appPort            = ":8080"
-	querySqliteMigrate = `CREATE TABLE IF NOT EXISTS tasks(
-	id TEXT PRIMARY KEY,
-	name TEXT UNIQUE NOT NULL,
-	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	last_modified_at TIMESTAMP NOT NULL DEFAULT 0,
-	is_active BOOL NOT NULL DEFAULT TRUE)`
+	randomIDLength = 24
+	serviceName    = "task-api"
+
+	// tracerShutdownTimeout is how long the last spans may take to be exported after the server stopped.
+	tracerShutdownTimeout = 5 * time.Second
 )
 
 var (
-	logger *log.Logger = logutil.NewStdLogger()
+	logger *slog.Logger = logutil.NewStdLogger()
 )
 
-func migrate(ctx context.Context, db *sql.DB) {
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+// newLogger returns the logger configured by c, which has been validated. The returned function syncs and closes
+// the file the logger writes to, if any.
+func newLogger(c config.Log) (*slog.Logger, func() error, error) {
+	level, _ := logutil.ParseLevel(c.Level)
+	format, _ := logutil.ParseFormat(c.Format)
+	lc := logutil.Config{Level: level, Format: format}
+
+	switch c.Output {
+	case "stdout":
+		lc.Output = os.Stdout
+	case "stderr":
+		lc.Output = os.Stderr
+	default:
+		f, err := os.OpenFile(c.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: failed to open log output", err)
+		}
+
+		lc.Output = f
+		return logutil.NewLogger(lc), func() error { return errors.Join(f.Sync(), f.Close()) }, nil
+	}
+
+	return logutil.NewLogger(lc), func() error { return nil }, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB, dialect migrations.Dialect, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	_, err := db.ExecContext(ctx, querySqliteMigrate)
+	m, err := migrations.New(db, dialect)
 	if err != nil {
-		logger.Fatal(err)
+		return fmt.Errorf("%w: failed to load %s migrations", err, dialect.Name)
+	}
+
+	// the server does not run on a schema with a migration skipped for a missing feature
+	if err := m.Up(logutil.PutCtxLogger(ctx, logger)); err != nil {
+		if errors.Is(err, migrations.ErrMissingFeature) {
+			return fmt.Errorf("%w: failed to migrate %s (the sqlite3 driver has fts5 when built with -tags sqlite_fts5)", err, dialect.Name)
+		}
+		return fmt.Errorf("%w: failed to migrate %s", err, dialect.Name)
 	}
+
+	return nil
+}
+
+// openDB opens and migrates the database of driver, and sizes its connection pool by c.
+func openDB(driver, dsn string, dialect migrations.Dialect, c config.Repository) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open %s database", err, driver)
+	}
+
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetime))
+	db.SetConnMaxIdleTime(time.Duration(c.ConnMaxIdleTime))
+
+	if err := migrate(context.Background(), db, dialect, time.Duration(c.MigrateTimeout)); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	return db, nil
+}
+
+// repository opens the task repository configured by c, recording the statistics of its connection pool in metrics
+// and its queries with tracer. The returned function closes the repository.
+func repository(c config.Repository, metrics *metricutil.Registry, tracer *traceutil.Tracer) (domain.TaskRepository, func() error, error) {
+	opts := []task.Option{task.WithTracer(tracer), task.WithQueryTimeout(time.Duration(c.QueryTimeout))}
+
+	switch c.Driver {
+	case "sqlite":
+		db, err := openDB("sqlite3", c.Sqlite.DSN(), migrations.Sqlite, c)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		metricutil.RegisterDBStats(metrics, db)
+		return task.ProvideV1RepositorySqlite(db, opts...), db.Close, nil
+	case "postgres":
+		db, err := openDB("postgres", string(c.Postgres.DSN), migrations.Postgres, c)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		metricutil.RegisterDBStats(metrics, db)
+		return task.ProvideV1RepositoryPostgres(db, opts...), db.Close, nil
+	default:
+		return task.ProvideV1RepositoryMemory(), func() error { return nil }, nil
+	}
+}
+
+// idGenerator returns the generator configured by c, which has been validated.
+func idGenerator(c config.IDs) (domain.IDGenerator, error) {
+	switch c.Generator {
+	case "uuidv7":
+		return idutil.NewUUIDv7(), nil
+	case "ksuid":
+		return idutil.NewKSUID(), nil
+	case "snowflake":
+		gen, err := idutil.NewSnowflake(c.NodeID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to create the snowflake generator", err)
+		}
+		return gen, nil
+	case "random":
+		return idutil.NewRandom(randomIDLength), nil
+	default:
+		return idutil.NewULID(), nil
+	}
+}
+
+// newTracer returns the tracer of the exporter configured by c, or nil when nothing is traced.
+func newTracer(c config.Trace) *traceutil.Tracer {
+	var exporter traceutil.Exporter
+
+	switch c.Exporter {
+	case "stdout":
+		exporter = traceutil.NewStdoutExporter(os.Stdout)
+	case "otlp":
+		exporter = traceutil.NewOTLPExporter(traceutil.OTLPConfig{
+			Endpoint:    c.Endpoint,
+			ServiceName: serviceName,
+			Header:      c.HTTPHeader(),
+		})
+	default:
+		return nil
+	}
+
+	return traceutil.NewTracer(traceutil.Config{
+		Exporter: exporter,
+		Logger:   logger,
+	})
 }
 
 func main() {
-	db, err := sql.Open("sqlite3", dbFileName)
+	os.Exit(run())
+}
+
+// run serves the task API until a SIGTERM or SIGINT, then shuts down in order: the server drains the in-flight
+// requests, the background workers stop, the database is closed and the last spans are exported.
+// The deferred steps run in the reverse order of their registration, so the tracer is registered first.
+// It returns the exit code of the process, which is not 0 when anything failed to shut down.
+func run() (code int) {
+	printConfig := flag.Bool("print-config", false, "print the effective config, with its secrets masked, and exit")
+
+	// every setting is checked before anything is opened
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.Getenv)
 	if err != nil {
-		logger.Fatal(err)
+		logger.Error("failed to load config", "error", err)
+		return 2
 	}
-	defer db.Close()
 
-	migrate(context.Background(), db)
-	api := task.Wire(db)
+	if *printConfig {
+		if _, err := cfg.WriteTo(os.Stdout); err != nil {
+			logger.Error("failed to print config", "error", err)
+			return 1
+		}
+		return 0
+	}
 
-	http.HandleFunc(task.V1HTTPEndpoint, api.Route())
+	l, closeLog, err := newLogger(cfg.Log)
+	if err != nil {
+		logger.Error("failed to create logger", "error", err)
+		return 1
+	}
+	logger = l
+	logutil.SetStdLogger(logger)
+
+	// the log output is closed last, once nothing is left to log
+	defer func() {
+		if err := closeLog(); err != nil {
+			logutil.NewLogger(logutil.Config{Output: os.Stderr}).Error("failed to close log output", "error", err)
+			code = 1
+		}
+	}()
+
+	ids, err := idGenerator(cfg.IDs)
+	if err != nil {
+		logger.Error("failed to create id generator", "error", err)
+		return 1
+	}
 
-	logger.Println("listening on", appPort)
-	if err := http.ListenAndServe(appPort, nil); err != nil && err != http.ErrServerClosed {
-		log.Fatal(err)
+	server := &http.Server{
+		Addr:              cfg.Server.Addr,
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// the tracer outlives everything that records spans, so that the spans of the last queries are exported
+	tracer := newTracer(cfg.Trace)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+		defer cancel()
+
+		if err := tracer.Shutdown(ctx); err != nil {
+			logger.Error("failed to shut down tracer", "error", err)
+			code = 1
+		}
+	}()
+
+	metrics := metricutil.NewRegistry()
+	repo, closeRepo, err := repository(cfg.Repository, metrics, tracer)
+	if err != nil {
+		logger.Error("failed to open repository", "driver", cfg.Repository.Driver, "error", err)
+		return 1
+	}
+	defer func() {
+		if err := closeRepo(); err != nil {
+			logger.Error("failed to close repository", "error", err)
+			code = 1
+			return
+		}
+		logger.Info("closed repository")
+	}()
+
+	c := task.New(
+		task.WithRepository(repo),
+		task.WithMetrics(metrics),
+		task.WithTracer(tracer),
+		task.WithLogger(logger),
+		task.WithIDGenerator(ids),
+		task.WithRequestTimeout(time.Duration(cfg.Server.RequestTimeout)),
+		task.WithMaxBodyBytes(cfg.Server.MaxBodyBytes),
+		task.WithDebug(cfg.Debug),
+	)
+
+	// the workers are stopped after the server, so that they never race the requests still being drained
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Purger(time.Duration(cfg.Trash.Retention), time.Duration(cfg.Trash.PurgeInterval)).Run(workers)
+	}()
+	defer func() {
+		stopWorkers()
+		wg.Wait()
+		logger.Info("stopped background workers")
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(task.V1HTTPEndpoint, c.Transport.Route())
+	server.Handler = mux
+
+	// the metrics describe the internals of the service, so they are kept off the address of the API clients
+	servers := []*http.Server{server}
+	if cfg.Admin.Addr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle(task.V1MetricsEndpoint, c.Metrics.Handler())
+		servers = append(servers, &http.Server{
+			Addr:              cfg.Admin.Addr,
+			Handler:           adminMux,
+			ReadHeaderTimeout: server.ReadHeaderTimeout,
+			ReadTimeout:       server.ReadTimeout,
+			WriteTimeout:      server.WriteTimeout,
+			IdleTimeout:       server.IdleTimeout,
+			ErrorLog:          server.ErrorLog,
+		})
+	}
+
+	listeners := make([]net.Listener, 0, len(servers))
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+	for _, s := range servers {
+		ln, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			logger.Error("failed to listen", "addr", s.Addr, "error", err)
+			return 1
+		}
+		listeners = append(listeners, ln)
+	}
+
+	serveErr := make(chan error, len(servers))
+	for i, s := range servers {
+		go func(s *http.Server, ln net.Listener) {
+			serveErr <- s.Serve(ln)
+		}(s, listeners[i])
+		logger.Info("listening", "addr", listeners[i].Addr().String(), "admin", s != server)
+	}
+
+	select {
+	case err := <-serveErr:
+		logger.Error("failed to serve", "error", err)
+		for _, s := range servers {
+			s.Close()
+		}
+		return 1
+	case <-ctx.Done():
+		// a second signal kills the process right away
+		stop()
+	}
+
+	logger.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout)
+
+	drain, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+
+	// the API is drained first, the metrics stay scrapeable meanwhile
+	for _, s := range servers {
+		if err := s.Shutdown(drain); err != nil {
+			logger.Error("failed to drain in-flight requests", "addr", s.Addr, "error", err)
+			if err := s.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("failed to close server", "addr", s.Addr, "error", err)
+			}
+			code = 1
+		}
+	}
+	if code == 0 {
+		logger.Info("drained in-flight requests")
+	}
+
+	return code
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anon-org/developing-api-services-with-golang/migrations"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	defaultDBFileName = "production.db.out"
+	usage             = `usage: migrate [-db file] <command>
+
+commands:
+  up          apply every pending migration
+  down        roll back the most recently applied migration
+  to VERSION  apply or roll back migrations until VERSION (0 rolls back everything)
+  status      show the state of every migration
+`
+)
+
+var (
+	logger *slog.Logger = logutil.NewStdLogger()
+)
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+func main() {
+	dbFileName := flag.String("db", defaultDBFileName, "sqlite database file")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of the command")
+	skipMissing := flag.Bool("skip-missing", false, "skip migrations requiring a feature the database lacks instead of failing")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("sqlite3", *dbFileName)
+	if err != nil {
+		fatal("failed to open database", "error", err)
+	}
+	defer db.Close()
+
+	m, err := migrations.New(db, migrations.Sqlite)
+	if err != nil {
+		fatal("failed to load migrations", "error", err)
+	}
+	m.WithSkipMissing(*skipMissing)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ctx = logutil.PutCtxLogger(ctx, logger)
+
+	switch cmd := flag.Arg(0); cmd {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx)
+	case "to":
+		var version uint64
+		version, err = strconv.ParseUint(flag.Arg(1), 10, 32)
+		if err != nil {
+			fatal("invalid version", "version", flag.Arg(1))
+		}
+		err = m.To(ctx, uint(version))
+	case "status":
+		err = status(ctx, m)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal("failed to migrate", "command", flag.Arg(0), "error", err)
+	}
+}
+
+func status(ctx context.Context, m *migrations.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range statuses {
+		state, appliedAt := "pending", ""
+		switch {
+		case s.Applied && s.Modified:
+			state, appliedAt = "modified", s.AppliedAt.Format(time.RFC3339)
+		case s.Applied:
+			state, appliedAt = "applied", s.AppliedAt.Format(time.RFC3339)
+		case len(s.Missing) > 0:
+			state = fmt.Sprintf("unsupported (requires %v)", s.Missing)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+
+	return w.Flush()
+}
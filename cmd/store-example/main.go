@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io"
+	"log"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -14,7 +15,10 @@ func main() {
 	suffix := rand.Intn(1000)
 	payload := fmt.Sprintf(`{"name": "testing demo %04d"}`, suffix)
 	body := strings.NewReader(payload)
-	resp, _ := http.Post("http://localhost:8080/v1/tasks/", "application/json", body)
+	resp, err := http.Post("http://localhost:8080/v1/tasks/", "application/json", body)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer resp.Body.Close()
 	b, _ := io.ReadAll(resp.Body)
 	fmt.Println(string(b))
@@ -0,0 +1,395 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/anon-org/developing-api-services-with-golang/util/idutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const (
+	// masked replaces the value of a Secret when it is printed.
+	masked string = "******"
+)
+
+var (
+	// ErrInvalidConfig is wrapped by the errors of Validate and Load.
+	ErrInvalidConfig error = errors.New("invalid config")
+
+	// sqlitePragmas are the pragmas the sqlite3 driver sets on every connection from the parameters of its DSN.
+	sqlitePragmas map[string]bool = map[string]bool{
+		"busy_timeout": true,
+		"cache_size":   true,
+		"foreign_keys": true,
+		"journal_mode": true,
+		"synchronous":  true,
+	}
+)
+
+type (
+	// Duration is a time.Duration written as a string such as 1m30s in every config format.
+	Duration time.Duration
+
+	// Secret is a string that is masked whenever it is printed or encoded.
+	Secret string
+
+	// Config is the configuration of the task API, see Default for the value of every setting that is not configured.
+	Config struct {
+		Server     Server     `json:"server" yaml:"server" toml:"server"`
+		Admin      Admin      `json:"admin" yaml:"admin" toml:"admin"`
+		Repository Repository `json:"repository" yaml:"repository" toml:"repository"`
+		Log        Log        `json:"log" yaml:"log" toml:"log"`
+		Trace      Trace      `json:"trace" yaml:"trace" toml:"trace"`
+		IDs        IDs        `json:"ids" yaml:"ids" toml:"ids"`
+		Trash      Trash      `json:"trash" yaml:"trash" toml:"trash"`
+
+		// Debug exposes internal error details in problem responses.
+		Debug bool `json:"debug" yaml:"debug" toml:"debug"`
+	}
+
+	// Server configures the http.Server and the handling of its requests.
+	Server struct {
+		Addr              string   `json:"addr" yaml:"addr" toml:"addr"`
+		ReadHeaderTimeout Duration `json:"read_header_timeout" yaml:"read_header_timeout" toml:"read_header_timeout"`
+		ReadTimeout       Duration `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`
+
+		// WriteTimeout must exceed RequestTimeout, so that a timed out request still gets its response.
+		WriteTimeout Duration `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"`
+		IdleTimeout  Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"`
+
+		// RequestTimeout is the deadline of the handling of a request, zero means none.
+		RequestTimeout Duration `json:"request_timeout" yaml:"request_timeout" toml:"request_timeout"`
+
+		// ShutdownTimeout is how long in-flight requests are drained once a SIGTERM or SIGINT is received.
+		ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+		// MaxBodyBytes is the size limit of a request body, a larger body is rejected with 413.
+		MaxBodyBytes int64 `json:"max_body_bytes" yaml:"max_body_bytes" toml:"max_body_bytes"`
+	}
+
+	// Admin configures the server of the operational endpoints, such as the metrics, kept apart from the API clients.
+	Admin struct {
+		// Addr is the address the admin server listens on, empty means the admin endpoints are not served.
+		Addr string `json:"addr" yaml:"addr" toml:"addr"`
+	}
+
+	// Repository configures the task repository and the connection pool of its database.
+	Repository struct {
+		// Driver is sqlite, postgres or memory.
+		Driver       string   `json:"driver" yaml:"driver" toml:"driver"`
+		QueryTimeout Duration `json:"query_timeout" yaml:"query_timeout" toml:"query_timeout"`
+
+		// MigrateTimeout is how long the migrations run on startup may take, rebuilding a large table can be slow.
+		MigrateTimeout Duration `json:"migrate_timeout" yaml:"migrate_timeout" toml:"migrate_timeout"`
+
+		// MaxOpenConns and MaxIdleConns bound the connection pool, zero open connections means no limit.
+		MaxOpenConns    int      `json:"max_open_conns" yaml:"max_open_conns" toml:"max_open_conns"`
+		MaxIdleConns    int      `json:"max_idle_conns" yaml:"max_idle_conns" toml:"max_idle_conns"`
+		ConnMaxLifetime Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+		ConnMaxIdleTime Duration `json:"conn_max_idle_time" yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+
+		Sqlite   Sqlite   `json:"sqlite" yaml:"sqlite" toml:"sqlite"`
+		Postgres Postgres `json:"postgres" yaml:"postgres" toml:"postgres"`
+	}
+
+	// Sqlite configures the sqlite repository.
+	Sqlite struct {
+		Path string `json:"path" yaml:"path" toml:"path"`
+
+		// Pragmas are set on every connection, they are busy_timeout, cache_size, foreign_keys, journal_mode and synchronous.
+		Pragmas map[string]string `json:"pragmas" yaml:"pragmas" toml:"pragmas"`
+	}
+
+	// Postgres configures the postgres repository.
+	Postgres struct {
+		DSN Secret `json:"dsn" yaml:"dsn" toml:"dsn"`
+	}
+
+	// Log configures the logger, see logutil.Config.
+	Log struct {
+		// Level is debug, info, warn or error.
+		Level string `json:"level" yaml:"level" toml:"level"`
+
+		// Format is text or json.
+		Format string `json:"format" yaml:"format" toml:"format"`
+
+		// Output is stdout, stderr or the path of a file appended to.
+		Output string `json:"output" yaml:"output" toml:"output"`
+	}
+
+	// Trace configures where spans are exported.
+	Trace struct {
+		// Exporter is none, stdout or otlp.
+		Exporter string `json:"exporter" yaml:"exporter" toml:"exporter"`
+
+		// Endpoint is the URL the otlp exporter posts spans to.
+		Endpoint string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+
+		// Headers are sent to the collector with every export, for instance to authenticate.
+		Headers map[string]Secret `json:"headers" yaml:"headers" toml:"headers"`
+	}
+
+	// IDs configures the generator of the ids of new tasks.
+	IDs struct {
+		// Generator is ulid, uuidv7, ksuid, snowflake or random.
+		Generator string `json:"generator" yaml:"generator" toml:"generator"`
+
+		// NodeID is the node id of the snowflake generator, unique per running instance. It is unset when negative.
+		NodeID int64 `json:"node_id" yaml:"node_id" toml:"node_id"`
+	}
+
+	// Trash configures the purge of the tasks deleted long ago.
+	Trash struct {
+		Retention     Duration `json:"retention" yaml:"retention" toml:"retention"`
+		PurgeInterval Duration `json:"purge_interval" yaml:"purge_interval" toml:"purge_interval"`
+	}
+)
+
+// Default returns the configuration used for every setting that is not configured.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:              ":8080",
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(15 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(60 * time.Second),
+			RequestTimeout:    Duration(25 * time.Second),
+			ShutdownTimeout:   Duration(20 * time.Second),
+			MaxBodyBytes:      1 << 20,
+		},
+		Admin: Admin{
+			Addr: "127.0.0.1:9090",
+		},
+		Repository: Repository{
+			Driver:         "sqlite",
+			QueryTimeout:   Duration(10 * time.Second),
+			MigrateTimeout: Duration(5 * time.Minute),
+			MaxIdleConns:   2,
+			Sqlite: Sqlite{
+				Path:    "production.db.out",
+				Pragmas: map[string]string{},
+			},
+		},
+		Log: Log{
+			Level:  "info",
+			Format: string(logutil.FormatText),
+			Output: "stdout",
+		},
+		Trace: Trace{
+			Exporter: "none",
+			Endpoint: "http://localhost:4318/v1/traces",
+			Headers:  map[string]Secret{},
+		},
+		IDs: IDs{
+			Generator: "ulid",
+			NodeID:    -1,
+		},
+		Trash: Trash{
+			Retention:     Duration(30 * 24 * time.Hour),
+			PurgeInterval: Duration(time.Hour),
+		},
+	}
+}
+
+// Validate returns the errors of every invalid setting of c joined, or nil.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+	}
+
+	if c.Server.Addr == "" {
+		invalid("server.addr is required")
+	}
+
+	for key, d := range map[string]Duration{
+		"server.read_header_timeout":    c.Server.ReadHeaderTimeout,
+		"server.read_timeout":           c.Server.ReadTimeout,
+		"server.write_timeout":          c.Server.WriteTimeout,
+		"server.idle_timeout":           c.Server.IdleTimeout,
+		"server.request_timeout":        c.Server.RequestTimeout,
+		"server.shutdown_timeout":       c.Server.ShutdownTimeout,
+		"repository.conn_max_lifetime":  c.Repository.ConnMaxLifetime,
+		"repository.conn_max_idle_time": c.Repository.ConnMaxIdleTime,
+	} {
+		if d < 0 {
+			invalid("%s must not be negative, got %s", key, d)
+		}
+	}
+
+	if c.Admin.Addr != "" && c.Admin.Addr == c.Server.Addr {
+		invalid("admin.addr must differ from server.addr, got %s", c.Admin.Addr)
+	}
+
+	if c.Server.MaxBodyBytes <= 0 {
+		invalid("server.max_body_bytes must be positive, got %d", c.Server.MaxBodyBytes)
+	}
+
+	if c.Server.WriteTimeout > 0 && c.Server.RequestTimeout > 0 && c.Server.WriteTimeout <= c.Server.RequestTimeout {
+		invalid("server.write_timeout %s must exceed server.request_timeout %s", c.Server.WriteTimeout, c.Server.RequestTimeout)
+	}
+
+	switch c.Repository.Driver {
+	case "memory":
+	case "sqlite":
+		if c.Repository.Sqlite.Path == "" {
+			invalid("repository.sqlite.path is required by the sqlite repository")
+		}
+
+		for name, value := range c.Repository.Sqlite.Pragmas {
+			if !sqlitePragmas[name] {
+				invalid("unknown sqlite pragma: %q", name)
+			} else if value == "" {
+				invalid("sqlite pragma %s has no value", name)
+			}
+		}
+	case "postgres":
+		if c.Repository.Postgres.DSN == "" {
+			invalid("repository.postgres.dsn is required by the postgres repository")
+		}
+	default:
+		invalid("unknown repository.driver: %q", c.Repository.Driver)
+	}
+
+	if c.Repository.QueryTimeout <= 0 {
+		invalid("repository.query_timeout must be positive, got %s", c.Repository.QueryTimeout)
+	}
+
+	if c.Repository.MigrateTimeout <= 0 {
+		invalid("repository.migrate_timeout must be positive, got %s", c.Repository.MigrateTimeout)
+	}
+
+	if c.Repository.MaxOpenConns < 0 || c.Repository.MaxIdleConns < 0 {
+		invalid("repository.max_open_conns and repository.max_idle_conns must not be negative")
+	} else if c.Repository.MaxOpenConns > 0 && c.Repository.MaxIdleConns > c.Repository.MaxOpenConns {
+		invalid("repository.max_idle_conns %d exceeds repository.max_open_conns %d", c.Repository.MaxIdleConns, c.Repository.MaxOpenConns)
+	}
+
+	if _, err := logutil.ParseLevel(c.Log.Level); err != nil {
+		invalid("log.level: %v", err)
+	}
+
+	if _, err := logutil.ParseFormat(c.Log.Format); err != nil {
+		invalid("log.format: %v", err)
+	}
+
+	if c.Log.Output == "" {
+		invalid("log.output is required")
+	}
+
+	switch c.Trace.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if u, err := url.Parse(c.Trace.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("trace.endpoint is not an http or https URL: %q", c.Trace.Endpoint)
+		}
+	default:
+		invalid("unknown trace.exporter: %q", c.Trace.Exporter)
+	}
+
+	switch c.IDs.Generator {
+	case "ulid", "uuidv7", "ksuid", "random":
+	case "snowflake":
+		if c.IDs.NodeID < 0 || c.IDs.NodeID > idutil.SnowflakeMaxNode {
+			invalid("ids.node_id is required by the snowflake generator, between 0 and %d", idutil.SnowflakeMaxNode)
+		}
+	default:
+		invalid("unknown ids.generator: %q", c.IDs.Generator)
+	}
+
+	if c.Trash.Retention <= 0 || c.Trash.PurgeInterval <= 0 {
+		invalid("trash.retention and trash.purge_interval must be positive")
+	}
+
+	// the order of a map is random, the errors are not
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+
+	return errors.Join(errs...)
+}
+
+// WriteTo writes c to w as YAML with its secrets masked.
+func (c Config) WriteTo(w io.Writer) (int64, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to encode config", err)
+	}
+
+	n, err := w.Write(out)
+	if err != nil {
+		return int64(n), fmt.Errorf("%w: failed to write config", err)
+	}
+
+	return int64(n), nil
+}
+
+// DSN returns the data source name of the sqlite3 driver, which sets the pragmas of s on every connection.
+func (s Sqlite) DSN() string {
+	if len(s.Pragmas) == 0 {
+		return s.Path
+	}
+
+	params := url.Values{}
+	for name, value := range s.Pragmas {
+		params.Set("_"+name, value)
+	}
+
+	return s.Path + "?" + params.Encode()
+}
+
+// String returns d formatted by time.Duration.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText encodes d as a string such as 1m30s.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText parses text with time.ParseDuration.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration: %q", text)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// String returns the masked secret, or an empty string when it is unset.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return masked
+}
+
+// MarshalText encodes the masked secret, a Secret never leaves the process through an encoder.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText sets the secret to text.
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = Secret(text)
+	return nil
+}
+
+// HTTPHeader returns the headers of t unmasked.
+func (t Trace) HTTPHeader() http.Header {
+	h := make(http.Header, len(t.Headers))
+	for name, value := range t.Headers {
+		h.Add(name, string(value))
+	}
+
+	return h
+}
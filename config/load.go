@@ -0,0 +1,289 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FileEnv is the environment variable naming the config file, the -config flag takes precedence over it.
+	FileEnv string = "APP_CONFIG"
+
+	fileFlag string = "config"
+)
+
+type (
+	// setting is a configurable field, keyed by its path in a config file, which is also the name of its flag.
+	setting struct {
+		key   string
+		env   string
+		usage string
+		value flag.Value
+	}
+
+	// override records the raw values a flag is set to, so that they are applied once the file and the environment are.
+	override struct {
+		value flag.Value
+		raw   []string
+	}
+
+	stringValue string
+	intValue    int
+	int64Value  int64
+	boolValue   bool
+
+	// mapValue is a comma-separated list of key=value pairs, added to the map it points to.
+	mapValue[V ~string] map[string]V
+)
+
+// Load returns the configuration built from, by increasing precedence, Default, the file named by the -config flag or
+// APP_CONFIG, the APP_* environment variables looked up with getenv and the flags of args.
+// The flags of every setting are registered on fs, which may hold flags of its own. The configuration is validated and
+// a file with an unknown key is rejected, every error wraps ErrInvalidConfig.
+func Load(fs *flag.FlagSet, args []string, getenv func(string) string) (Config, error) {
+	// the flags are parsed first for the file they name, and are applied last
+	path := fs.String(fileFlag, "", "path of the YAML, TOML or JSON config file, overrides "+FileEnv)
+
+	defaults := Default()
+	overrides := map[string]*override{}
+	for _, s := range defaults.settings() {
+		o := &override{value: s.value}
+		overrides[s.key] = o
+		fs.Var(o, s.key, s.usage+" ("+s.env+")")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	if *path == "" {
+		*path = getenv(FileEnv)
+	}
+
+	c := Default()
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	settings := c.settings()
+	for _, s := range settings {
+		if raw := getenv(s.env); raw != "" {
+			if err := s.value.Set(raw); err != nil {
+				return Config{}, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		for _, raw := range overrides[s.key].raw {
+			if err := s.value.Set(raw); err != nil {
+				return Config{}, fmt.Errorf("%w: -%s: %v", ErrInvalidConfig, s.key, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
+
+// settings returns the settings of c, their values set the fields of c.
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.addr", "APP_ADDR", "address the server listens on", (*stringValue)(&c.Server.Addr)},
+		{"server.read_header_timeout", "APP_READ_HEADER_TIMEOUT", "how long a client may take to send the request headers", &c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", "APP_READ_TIMEOUT", "how long a client may take to send a whole request", &c.Server.ReadTimeout},
+		{"server.write_timeout", "APP_WRITE_TIMEOUT", "how long a response may take to be written, it must exceed the request timeout", &c.Server.WriteTimeout},
+		{"server.idle_timeout", "APP_IDLE_TIMEOUT", "how long an idle keep-alive connection is kept open", &c.Server.IdleTimeout},
+		{"server.request_timeout", "APP_REQUEST_TIMEOUT", "deadline of the handling of a request, 0 means none", &c.Server.RequestTimeout},
+		{"server.shutdown_timeout", "APP_SHUTDOWN_TIMEOUT", "how long in-flight requests are drained on SIGTERM or SIGINT", &c.Server.ShutdownTimeout},
+		{"server.max_body_bytes", "APP_MAX_BODY_BYTES", "size limit of a request body in bytes", (*int64Value)(&c.Server.MaxBodyBytes)},
+		{"admin.addr", "APP_ADMIN_ADDR", "address the metrics are served on, empty means not served", (*stringValue)(&c.Admin.Addr)},
+		{"repository.driver", "APP_REPOSITORY", "task repository: sqlite, postgres or memory", (*stringValue)(&c.Repository.Driver)},
+		{"repository.query_timeout", "APP_QUERY_TIMEOUT", "deadline of every database query, once per operation of a batch", &c.Repository.QueryTimeout},
+		{"repository.migrate_timeout", "APP_MIGRATE_TIMEOUT", "how long the migrations run on startup may take", &c.Repository.MigrateTimeout},
+		{"repository.max_open_conns", "APP_DB_MAX_OPEN_CONNS", "maximum open database connections, 0 means no limit", (*intValue)(&c.Repository.MaxOpenConns)},
+		{"repository.max_idle_conns", "APP_DB_MAX_IDLE_CONNS", "maximum idle database connections", (*intValue)(&c.Repository.MaxIdleConns)},
+		{"repository.conn_max_lifetime", "APP_DB_CONN_MAX_LIFETIME", "longest a database connection is reused, 0 means forever", &c.Repository.ConnMaxLifetime},
+		{"repository.conn_max_idle_time", "APP_DB_CONN_MAX_IDLE_TIME", "longest a database connection stays idle, 0 means forever", &c.Repository.ConnMaxIdleTime},
+		{"repository.sqlite.path", "APP_SQLITE_PATH", "path of the sqlite database", (*stringValue)(&c.Repository.Sqlite.Path)},
+		{"repository.sqlite.pragmas", "APP_SQLITE_PRAGMAS", "sqlite pragmas as name=value,...", (*mapValue[string])(&c.Repository.Sqlite.Pragmas)},
+		{"repository.postgres.dsn", "APP_POSTGRES_DSN", "connection string of the postgres database", (*stringValue)(&c.Repository.Postgres.DSN)},
+		{"log.level", "APP_LOG_LEVEL", "minimum level of the logged records: debug, info, warn or error", (*stringValue)(&c.Log.Level)},
+		{"log.format", "APP_LOG_FORMAT", "encoding of the logged records: text or json", (*stringValue)(&c.Log.Format)},
+		{"log.output", "APP_LOG_OUTPUT", "stdout, stderr or the path of a file the records are appended to", (*stringValue)(&c.Log.Output)},
+		{"trace.exporter", "APP_TRACE_EXPORTER", "where spans are exported: none, stdout or otlp", (*stringValue)(&c.Trace.Exporter)},
+		{"trace.endpoint", "APP_OTLP_ENDPOINT", "URL the otlp exporter posts spans to", (*stringValue)(&c.Trace.Endpoint)},
+		{"trace.headers", "APP_OTLP_HEADERS", "headers sent to the collector as name=value,...", (*mapValue[Secret])(&c.Trace.Headers)},
+		{"ids.generator", "APP_ID_GENERATOR", "task id generator: ulid, uuidv7, ksuid, snowflake or random", (*stringValue)(&c.IDs.Generator)},
+		{"ids.node_id", "APP_NODE_ID", "node id of the snowflake generator, unique per running instance", (*int64Value)(&c.IDs.NodeID)},
+		{"trash.retention", "APP_TRASH_RETENTION", "how long deleted tasks are kept before being purged", &c.Trash.Retention},
+		{"trash.purge_interval", "APP_TRASH_PURGE_INTERVAL", "how often deleted tasks are purged", &c.Trash.PurgeInterval},
+		{"debug", "APP_DEBUG", "expose internal error details in problem responses", (*boolValue)(&c.Debug)},
+	}
+}
+
+// loadFile decodes the file at path over c, by the format of its extension.
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read config file: %v", ErrInvalidConfig, err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+		}
+
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, k := range undecoded {
+				keys = append(keys, k.String())
+			}
+			return fmt.Errorf("%w: %s: unknown keys: %s", ErrInvalidConfig, path, strings.Join(keys, ", "))
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+		}
+	default:
+		return fmt.Errorf("%w: %s: unsupported config file extension %q, expected .yaml, .yml, .toml or .json", ErrInvalidConfig, path, ext)
+	}
+
+	return nil
+}
+
+func (o *override) Set(s string) error {
+	if err := o.value.Set(s); err != nil {
+		return err
+	}
+
+	o.raw = append(o.raw, s)
+	return nil
+}
+
+func (o *override) String() string {
+	// the flag package calls String on a zero override to tell whether a default is worth printing
+	if o == nil || o.value == nil {
+		return ""
+	}
+
+	return o.value.String()
+}
+
+func (o *override) IsBoolFlag() bool {
+	b, ok := o.value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Set parses s with time.ParseDuration.
+func (d *Duration) Set(s string) error {
+	return d.UnmarshalText([]byte(s))
+}
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string {
+	return string(*v)
+}
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid integer: %q", s)
+	}
+
+	*v = intValue(n)
+	return nil
+}
+
+func (v *intValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *int64Value) Set(s string) error {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer: %q", s)
+	}
+
+	*v = int64Value(n)
+	return nil
+}
+
+func (v *int64Value) String() string {
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean: %q", s)
+	}
+
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string {
+	return strconv.FormatBool(bool(*v))
+}
+
+func (v *boolValue) IsBoolFlag() bool {
+	return true
+}
+
+func (v *mapValue[V]) Set(s string) error {
+	if *v == nil {
+		*v = mapValue[V]{}
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return fmt.Errorf("invalid key=value pair: %q", pair)
+		}
+
+		(*v)[key] = V(strings.TrimSpace(value))
+	}
+
+	return nil
+}
+
+func (v *mapValue[V]) String() string {
+	pairs := make([]string, 0, len(*v))
+	for key, value := range *v {
+		pairs = append(pairs, key+"="+fmt.Sprint(value))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,26 @@
+package domain
+
+import "context"
+
+// AnonymousActor is the actor of changes made without an identified caller.
+const AnonymousActor string = "anonymous"
+
+type ctxActor struct{}
+
+var (
+	ctxActorKey *ctxActor = &ctxActor{}
+)
+
+// PutCtxActor stores the actor making the changes of the current request in ctx.
+func PutCtxActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, ctxActorKey, actor)
+}
+
+// GetCtxActor returns the actor stored in ctx, or AnonymousActor.
+func GetCtxActor(ctx context.Context) string {
+	actor, ok := ctx.Value(ctxActorKey).(string)
+	if !ok || actor == "" {
+		return AnonymousActor
+	}
+	return actor
+}
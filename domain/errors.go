@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when the requested resource does not exist.
+	ErrNotFound error = errors.New("not found")
+
+	// ErrGone is returned when the requested resource is in the trash, it must be restored before being written.
+	ErrGone error = errors.New("gone")
+
+	// ErrConflict is returned when the request conflicts with the current state of a resource.
+	ErrConflict error = errors.New("conflict")
+
+	// ErrValidation is returned when the request is semantically invalid.
+	ErrValidation error = errors.New("validation failed")
+
+	// ErrPreconditionFailed is returned when a conditional request does not match the current version of a resource.
+	ErrPreconditionFailed error = errors.New("precondition failed")
+
+	// ErrUnavailable is returned when a backing dependency is temporarily unavailable.
+	ErrUnavailable error = errors.New("unavailable")
+)
+
+// Error is a domain error that classifies an underlying error with one of the sentinel kinds.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+// NewError classifies err with kind.
+func NewError(kind error, err error) *Error {
+	return &Error{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of the error.
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
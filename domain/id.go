@@ -0,0 +1,6 @@
+package domain
+
+// IDGenerator generates the ids of new entities, an error means no id could be generated.
+type IDGenerator interface {
+	Generate() (string, error)
+}
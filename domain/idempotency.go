@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// IdempotencyKeyMaxLength is the maximum length of an idempotency key.
+	IdempotencyKeyMaxLength = 255
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when an idempotency key is sent again with a different request.
+	ErrIdempotencyKeyReused error = errors.New("idempotency key reused with a different request")
+)
+
+type (
+	// IdempotencySpec is the specification that represents the idempotency key of a request.
+	// Fingerprint identifies the request, a key may only be reused with the same fingerprint.
+	IdempotencySpec struct {
+		Key         string
+		Fingerprint string
+	}
+
+	// IdempotencyRecord is the response stored under an idempotency key.
+	// A record without a Status is reserved by a request still in flight.
+	IdempotencyRecord struct {
+		Key         string
+		Fingerprint string
+		Status      int
+		Header      map[string][]string
+		Body        []byte
+		CreatedAt   time.Time
+		ExpiresAt   time.Time
+	}
+
+	// IdempotencyRepository is the storage interface for IdempotencyRecord.
+	IdempotencyRepository interface {
+		ReserveIdempotencyKey(context.Context, IdempotencyRecord) (*IdempotencyRecord, bool, error)
+		CompleteIdempotencyKey(context.Context, IdempotencyRecord) error
+		ReleaseIdempotencyKey(context.Context, string) error
+		PurgeIdempotencyKeys(context.Context, time.Time) (int64, error)
+	}
+)
+
+// Validate validates the IdempotencySpec.
+func (s IdempotencySpec) Validate() error {
+	if s.Key == "" || len(s.Key) > IdempotencyKeyMaxLength {
+		return fmt.Errorf("%w: idempotency key must be between 1 and %d characters", ErrValidation, IdempotencyKeyMaxLength)
+	}
+
+	for _, r := range s.Key {
+		if r < 0x21 || r > 0x7e {
+			return fmt.Errorf("%w: idempotency key must only contain visible ASCII characters", ErrValidation)
+		}
+	}
+
+	return nil
+}
+
+// IsPending reports whether the record is reserved by a request still in flight.
+func (r *IdempotencyRecord) IsPending() bool {
+	return r.Status == 0
+}
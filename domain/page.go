@@ -0,0 +1,170 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// DefaultPageLimit is the page size used when a PageSpec has no limit.
+	DefaultPageLimit int = 20
+
+	// MaxPageLimit is the largest page size a PageSpec may request.
+	MaxPageLimit int = 100
+)
+
+type (
+	// PageSpec is the specification that represents a page request.
+	PageSpec struct {
+		Limit  int
+		Cursor string
+	}
+
+	// Cursor is the keyset position a page starts after (or before, when Backward is set).
+	// Value is the canonical text of the sort column of the row at that position.
+	Cursor struct {
+		Sort     string `json:"s"`
+		Value    string `json:"v"`
+		ID       string `json:"i"`
+		Backward bool   `json:"b,omitempty"`
+	}
+
+	// TaskEntityPage is the repository page of TaskEntity.
+	TaskEntityPage struct {
+		Entities   []*TaskEntity
+		NextCursor string
+		PrevCursor string
+	}
+
+	// TaskPage is the page of Task.
+	TaskPage struct {
+		Tasks      []*Task
+		NextCursor string
+		PrevCursor string
+	}
+
+	// TaskPageResponse is the specification that represents a task page HTTP response.
+	TaskPageResponse struct {
+		Data       []*TaskResponse `json:"data"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+		PrevCursor string          `json:"prev_cursor,omitempty"`
+	}
+)
+
+// Normalize applies the default limit and validates the page specification.
+func (p PageSpec) Normalize() (PageSpec, error) {
+	if p.Limit == 0 {
+		p.Limit = DefaultPageLimit
+	}
+
+	if p.Limit < 0 || p.Limit > MaxPageLimit {
+		return p, NewFieldError("limit", fmt.Sprintf("must be between 1 and %d", MaxPageLimit))
+	}
+
+	if p.Cursor != "" {
+		if _, err := DecodeCursor(p.Cursor); err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
+
+// Encode returns the opaque string representation of the cursor.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses an opaque cursor produced by Cursor.Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, NewFieldError("cursor", "is invalid")
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return c, NewFieldError("cursor", "is invalid")
+	}
+
+	return c, nil
+}
+
+// NewTaskEntityPage builds a page from entities fetched with one extra row beyond limit,
+// which tells whether another page exists in the direction of travel.
+func NewTaskEntityPage(entities []*TaskEntity, query TaskQuery, cursor *Cursor) *TaskEntityPage {
+	sort, field := query.Sort.String(), query.Sort.Field
+	entities, next, prev := paginate(entities, query.Page.Limit, cursor, func(e *TaskEntity) Cursor {
+		return Cursor{Sort: sort, Value: field.Value(e), ID: e.ID}
+	})
+
+	return &TaskEntityPage{
+		Entities:   entities,
+		NextCursor: next,
+		PrevCursor: prev,
+	}
+}
+
+// paginate trims items fetched with one extra row beyond limit, restores the order of a backward page,
+// and returns the encoded cursors of the next and previous pages.
+func paginate[T any](items []T, limit int, cursor *Cursor, cursorOf func(T) Cursor) ([]T, string, string) {
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+
+	backward := cursor != nil && cursor.Backward
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	if len(items) == 0 {
+		return items, "", ""
+	}
+
+	var next, prev string
+	if hasMore || backward {
+		next = cursorOf(items[len(items)-1]).Encode()
+	}
+
+	if (hasMore && backward) || (cursor != nil && !backward) {
+		c := cursorOf(items[0])
+		c.Backward = true
+		prev = c.Encode()
+	}
+
+	return items, next, prev
+}
+
+// ToResponse converts a TaskPage to a TaskPageResponse.
+func (p *TaskPage) ToResponse() *TaskPageResponse {
+	data := make([]*TaskResponse, len(p.Tasks))
+	for i, t := range p.Tasks {
+		data[i] = t.ToResponse()
+	}
+
+	return &TaskPageResponse{
+		Data:       data,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+	}
+}
+
+// ToSpec converts a TaskEntityPage to a TaskPage.
+func (p *TaskEntityPage) ToSpec() *TaskPage {
+	tasks := make([]*Task, len(p.Entities))
+	for i, e := range p.Entities {
+		tasks[i] = e.ToSpec()
+	}
+
+	return &TaskPage{
+		Tasks:      tasks,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+	}
+}
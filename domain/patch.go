@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type (
+	// JSONPatchOperation is a single operation of an RFC 6902 JSON Patch document.
+	JSONPatchOperation struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+		From string `json:"from"`
+		// Value is empty when the operation has no value, a null value is kept as the literal null.
+		Value json.RawMessage `json:"value"`
+	}
+)
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to target and returns the result.
+// Both are decoded JSON values, target is not modified.
+func ApplyMergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	result := make(map[string]any, len(targetObject))
+	if ok {
+		for k, v := range targetObject {
+			result[k] = v
+		}
+	}
+
+	for k, v := range patchObject {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+
+		result[k] = ApplyMergePatch(result[k], v)
+	}
+
+	return result
+}
+
+// ApplyJSONPatch applies the operations of an RFC 6902 JSON Patch to the flat object doc in place.
+// A failed test operation is reported as ErrConflict, any other failure as ErrValidation.
+func ApplyJSONPatch(doc map[string]any, ops []JSONPatchOperation) error {
+	for i, op := range ops {
+		path, err := jsonPointerKey(op.Path)
+		if err != nil {
+			return fmt.Errorf("%w: operation %d: %v", ErrValidation, i, err)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return fmt.Errorf("%w: operation %d: %s requires a value", ErrValidation, i, op.Op)
+			}
+
+			var value any
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return fmt.Errorf("%w: operation %d: %v", ErrValidation, i, err)
+			}
+
+			current, exists := doc[path]
+			if op.Op != "add" && !exists {
+				return fmt.Errorf("%w: operation %d: path %s does not exist", ErrValidation, i, op.Path)
+			}
+
+			if op.Op == "test" {
+				if !reflect.DeepEqual(current, value) {
+					return fmt.Errorf("%w: operation %d: test of path %s failed", ErrConflict, i, op.Path)
+				}
+				continue
+			}
+
+			doc[path] = value
+		case "remove":
+			if _, exists := doc[path]; !exists {
+				return fmt.Errorf("%w: operation %d: path %s does not exist", ErrValidation, i, op.Path)
+			}
+
+			delete(doc, path)
+		case "move", "copy":
+			from, err := jsonPointerKey(op.From)
+			if err != nil {
+				return fmt.Errorf("%w: operation %d: %v", ErrValidation, i, err)
+			}
+
+			value, exists := doc[from]
+			if !exists {
+				return fmt.Errorf("%w: operation %d: path %s does not exist", ErrValidation, i, op.From)
+			}
+
+			if op.Op == "move" {
+				delete(doc, from)
+			}
+			doc[path] = value
+		default:
+			return fmt.Errorf("%w: operation %d: unknown op: %q", ErrValidation, i, op.Op)
+		}
+	}
+
+	return nil
+}
+
+// jsonPointerKey resolves an RFC 6901 JSON Pointer to a member of a flat object.
+func jsonPointerKey(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("invalid JSON pointer: %q", pointer)
+	}
+
+	key := pointer[1:]
+	if strings.Contains(key, "/") {
+		return "", fmt.Errorf("path %s does not exist", pointer)
+	}
+
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(key), nil
+}
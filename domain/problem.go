@@ -0,0 +1,13 @@
+package domain
+
+// ProblemResponse is the specification that represents an RFC 7807 problem details HTTP response.
+// Errors is an extension member listing the field errors of a validation problem.
+type ProblemResponse struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
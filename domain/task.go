@@ -17,6 +17,13 @@ type (
 		IsActive *bool   `json:"is_active"`
 	}
 
+	// TaskReplaceRequest is the specification that represents a task HTTP Replace request.
+	// Every field is required, a missing field is reported rather than defaulted.
+	TaskReplaceRequest struct {
+		Name     *string `json:"name"`
+		IsActive *bool   `json:"is_active"`
+	}
+
 	// TaskResponse is the specification that represents a task HTTP response.
 	TaskResponse struct {
 		ID             string `json:"id"`
@@ -24,6 +31,8 @@ type (
 		CreatedAt      int64  `json:"created_at"`
 		LastModifiedAt int64  `json:"last_modified_at,omitempty"`
 		IsActive       bool   `json:"is_active"`
+		DeletedAt      int64  `json:"deleted_at,omitempty"`
+		Version        int64  `json:"version"`
 	}
 
 	// Task is the specification that represents a task.
@@ -33,13 +42,33 @@ type (
 		CreatedAt      time.Time
 		LastModifiedAt time.Time
 		IsActive       bool
+		DeletedAt      time.Time
+		Version        int64
 	}
 
 	// TaskPatchSpec is the specification that represents a task patch specification.
+	// A non-nil ExpectedVersion makes the patch conditional on the current version of the task.
 	TaskPatchSpec struct {
-		ID       string
-		Name     *string
-		IsActive *bool
+		ID              string
+		Name            *string
+		IsActive        *bool
+		ExpectedVersion *VersionMatch
+	}
+
+	// TaskReplaceSpec is the specification that represents a task replace specification.
+	// A task is created at ID when none exists, unless ExpectedVersion is non-nil, even if it is "*".
+	TaskReplaceSpec struct {
+		ID              string
+		Name            string
+		IsActive        bool
+		ExpectedVersion *VersionMatch
+	}
+
+	// TaskDestroySpec is the specification that represents a task destroy specification.
+	// A non-nil ExpectedVersion makes the destroy conditional on the current version of the task.
+	TaskDestroySpec struct {
+		ID              string
+		ExpectedVersion *VersionMatch
 	}
 
 	// TaskEntity is the repository entity that represents a task.
@@ -49,24 +78,56 @@ type (
 		CreatedAt      time.Time
 		LastModifiedAt time.Time
 		IsActive       bool
+		DeletedAt      time.Time
+		Version        int64
 	}
 
 	// TaskRepository is the storage interface for TaskEntity.
 	TaskRepository interface {
-		Fetch(context.Context) ([]*TaskEntity, error)
+		Fetch(context.Context, TaskQuery) (*TaskEntityPage, error)
+		Search(context.Context, TaskSearchQuery) (*TaskSearchEntityPage, error)
 		FetchByID(context.Context, string) (*TaskEntity, error)
 		Store(context.Context, TaskEntity) (*TaskEntity, error)
 		Patch(context.Context, TaskPatchSpec) (*TaskEntity, error)
-		DestroyByID(context.Context, string) error
+		Replace(context.Context, TaskReplaceSpec) (*TaskEntity, bool, error)
+		DestroyByID(context.Context, TaskDestroySpec) error
+		Restore(context.Context, string) (*TaskEntity, error)
+		Purge(context.Context, string) error
+		PurgeTrashed(context.Context, time.Time) (int64, error)
+		FetchHistory(context.Context, TaskHistoryQuery) (*TaskHistoryEntityPage, error)
+		Batch(context.Context, TaskBatchSpec) ([]*TaskBatchEntityResult, error)
+		IdempotencyRepository
+		TaskUnitOfWork
+	}
+
+	// TaskUnitOfWork runs several TaskRepository calls atomically.
+	// RunInTx commits the changes fn makes through the repository it is given when fn returns nil, and rolls them back otherwise.
+	// Calls must use the context given to fn, which carries the transaction. Nested calls roll back independently of the
+	// enclosing unit of work, and fn may run more than once when the storage is busy.
+	TaskUnitOfWork interface {
+		RunInTx(context.Context, func(context.Context, TaskRepository) error) error
 	}
 
 	// TaskService is the use case interface for Task.
 	TaskService interface {
-		Fetch(context.Context) ([]*Task, error)
+		Fetch(context.Context, TaskQuery) (*TaskPage, error)
+		Search(context.Context, TaskSearchQuery) (*TaskSearchPage, error)
 		FetchByID(context.Context, string) (*Task, error)
 		Store(context.Context, string) (*Task, error)
 		Patch(context.Context, TaskPatchSpec) (*Task, error)
-		DestroyByID(context.Context, string) error
+		PatchDocument(context.Context, TaskDocumentPatchSpec) (*Task, error)
+		Replace(context.Context, TaskReplaceSpec) (*Task, bool, error)
+		DestroyByID(context.Context, TaskDestroySpec) error
+		FetchTrash(context.Context, TaskQuery) (*TaskPage, error)
+		Restore(context.Context, string) (*Task, error)
+		Purge(context.Context, string) error
+		PurgeTrashed(context.Context, time.Duration) (int64, error)
+		FetchHistory(context.Context, TaskHistoryQuery) (*TaskHistoryPage, error)
+		Batch(context.Context, TaskBatchSpec) ([]*TaskBatchResult, error)
+		ReserveIdempotencyKey(context.Context, IdempotencySpec) (*IdempotencyRecord, error)
+		CompleteIdempotencyKey(context.Context, IdempotencyRecord) error
+		ReleaseIdempotencyKey(context.Context, string) error
+		PurgeIdempotencyKeys(context.Context) (int64, error)
 	}
 )
 
@@ -78,17 +139,26 @@ func (t *Task) ToEntity() TaskEntity {
 		CreatedAt:      t.CreatedAt,
 		LastModifiedAt: t.LastModifiedAt,
 		IsActive:       t.IsActive,
+		DeletedAt:      t.DeletedAt,
+		Version:        t.Version,
 	}
 }
 
 func (t *Task) ToResponse() *TaskResponse {
-	return &TaskResponse{
+	r := &TaskResponse{
 		ID:             t.ID,
 		Name:           t.Name,
 		CreatedAt:      t.CreatedAt.UnixMilli(),
 		LastModifiedAt: t.LastModifiedAt.UnixMilli(),
 		IsActive:       t.IsActive,
+		Version:        t.Version,
 	}
+
+	if !t.DeletedAt.IsZero() {
+		r.DeletedAt = t.DeletedAt.UnixMilli()
+	}
+
+	return r
 }
 
 // ToSpec converts a TaskEntity to a Task.
@@ -99,5 +169,7 @@ func (e *TaskEntity) ToSpec() *Task {
 		CreatedAt:      e.CreatedAt,
 		LastModifiedAt: e.LastModifiedAt,
 		IsActive:       e.IsActive,
+		DeletedAt:      e.DeletedAt,
+		Version:        e.Version,
 	}
 }
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"errors"
+)
+
+// MaxBatchOperations is the maximum number of operations of a task batch.
+const MaxBatchOperations int = 1000
+
+// TaskBatchMode is how the operations of a task batch are committed.
+type TaskBatchMode string
+
+const (
+	// TaskBatchAllOrNothing commits every operation in one transaction, or none of them.
+	TaskBatchAllOrNothing TaskBatchMode = "all_or_nothing"
+
+	// TaskBatchBestEffort commits every operation on its own, a failed operation does not affect the others.
+	TaskBatchBestEffort TaskBatchMode = "best_effort"
+)
+
+// TaskBatchOperationKind is the kind of change of a task batch operation.
+type TaskBatchOperationKind string
+
+const (
+	TaskBatchCreate TaskBatchOperationKind = "create"
+	TaskBatchPatch  TaskBatchOperationKind = "patch"
+	TaskBatchDelete TaskBatchOperationKind = "delete"
+)
+
+var (
+	// ErrBatchAborted is the error of the operations of an all-or-nothing batch that were rolled back or never run
+	// because another operation of the batch failed.
+	ErrBatchAborted error = errors.New("batch aborted")
+)
+
+type (
+	// TaskBatchRequest is the specification that represents a task HTTP Batch request.
+	TaskBatchRequest struct {
+		Mode       TaskBatchMode               `json:"mode"`
+		Operations []TaskBatchOperationRequest `json:"operations"`
+	}
+
+	// TaskBatchOperationRequest is the specification that represents an operation of a task HTTP Batch request.
+	TaskBatchOperationRequest struct {
+		Op       TaskBatchOperationKind `json:"op"`
+		ID       string                 `json:"id"`
+		Name     *string                `json:"name"`
+		IsActive *bool                  `json:"is_active"`
+		Version  *int64                 `json:"version"`
+	}
+
+	// TaskBatchResponse is the specification that represents a task HTTP Batch response.
+	TaskBatchResponse struct {
+		Mode      TaskBatchMode              `json:"mode"`
+		Succeeded int                        `json:"succeeded"`
+		Failed    int                        `json:"failed"`
+		Results   []*TaskBatchResultResponse `json:"results"`
+	}
+
+	// TaskBatchResultResponse is the specification that represents the result of a task HTTP Batch operation.
+	TaskBatchResultResponse struct {
+		Index  int                    `json:"index"`
+		Op     TaskBatchOperationKind `json:"op"`
+		Status int                    `json:"status"`
+		Task   *TaskResponse          `json:"task,omitempty"`
+		Error  *ProblemResponse       `json:"error,omitempty"`
+	}
+
+	// TaskBatchSpec is the specification that represents a batch of task operations.
+	TaskBatchSpec struct {
+		Mode       TaskBatchMode
+		Operations []TaskBatchOperation
+	}
+
+	// TaskBatchOperation is the specification that represents a single operation of a task batch.
+	// A non-nil ExpectedVersion makes a patch or delete conditional on the current version of the task.
+	TaskBatchOperation struct {
+		Kind            TaskBatchOperationKind
+		ID              string
+		Name            *string
+		IsActive        *bool
+		ExpectedVersion *VersionMatch
+	}
+
+	// TaskBatchEntityResult is the repository result of a task batch operation.
+	// Entity is nil for a failed operation and for a delete.
+	TaskBatchEntityResult struct {
+		Entity *TaskEntity
+		Err    error
+	}
+
+	// TaskBatchResult is the result of a task batch operation.
+	TaskBatchResult struct {
+		Kind TaskBatchOperationKind
+		Task *Task
+		Err  error
+	}
+)
+
+// ToSpec converts a TaskBatchRequest to a TaskBatchSpec, the mode defaults to TaskBatchAllOrNothing.
+func (r TaskBatchRequest) ToSpec() TaskBatchSpec {
+	spec := TaskBatchSpec{
+		Mode:       r.Mode,
+		Operations: make([]TaskBatchOperation, len(r.Operations)),
+	}
+
+	if spec.Mode == "" {
+		spec.Mode = TaskBatchAllOrNothing
+	}
+
+	for i, o := range r.Operations {
+		spec.Operations[i] = TaskBatchOperation{
+			Kind:            o.Op,
+			ID:              o.ID,
+			Name:            o.Name,
+			IsActive:        o.IsActive,
+			ExpectedVersion: MatchVersion(o.Version),
+		}
+	}
+
+	return spec
+}
+
+// ToPatchSpec converts a patch operation to a TaskPatchSpec.
+func (o TaskBatchOperation) ToPatchSpec() TaskPatchSpec {
+	return TaskPatchSpec{
+		ID:              o.ID,
+		Name:            o.Name,
+		IsActive:        o.IsActive,
+		ExpectedVersion: o.ExpectedVersion,
+	}
+}
+
+// ToSpec converts a TaskBatchEntityResult to a TaskBatchResult.
+func (r *TaskBatchEntityResult) ToSpec(kind TaskBatchOperationKind) *TaskBatchResult {
+	result := &TaskBatchResult{
+		Kind: kind,
+		Err:  r.Err,
+	}
+
+	if r.Entity != nil {
+		result.Task = r.Entity.ToSpec()
+	}
+
+	return result
+}
@@ -0,0 +1,178 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TaskHistorySort is the cursor sort of history entries, which are ordered by when they were recorded.
+const TaskHistorySort string = "history"
+
+// TaskHistoryOperation is the kind of change a history entry records.
+type TaskHistoryOperation string
+
+const (
+	TaskHistoryStore   TaskHistoryOperation = "store"
+	TaskHistoryPatch   TaskHistoryOperation = "patch"
+	TaskHistoryReplace TaskHistoryOperation = "replace"
+	TaskHistoryDestroy TaskHistoryOperation = "destroy"
+	TaskHistoryRestore TaskHistoryOperation = "restore"
+)
+
+type (
+	// TaskHistoryQuery is the specification that represents a task history request.
+	TaskHistoryQuery struct {
+		TaskID string
+		Page   PageSpec
+	}
+
+	// TaskHistoryEntity is the repository entity that represents an immutable record of a task change.
+	// Before is nil for a store, After is never nil.
+	TaskHistoryEntity struct {
+		ID        int64
+		TaskID    string
+		Operation TaskHistoryOperation
+		Before    *TaskEntity
+		After     *TaskEntity
+		Actor     string
+		RequestID string
+		CreatedAt time.Time
+	}
+
+	// TaskHistoryEntityPage is the repository page of TaskHistoryEntity.
+	TaskHistoryEntityPage struct {
+		Entities   []*TaskHistoryEntity
+		NextCursor string
+		PrevCursor string
+	}
+
+	// TaskHistoryEntry is a record of a task change.
+	TaskHistoryEntry struct {
+		ID        int64
+		TaskID    string
+		Operation TaskHistoryOperation
+		Before    *Task
+		After     *Task
+		Actor     string
+		RequestID string
+		CreatedAt time.Time
+	}
+
+	// TaskHistoryPage is the page of TaskHistoryEntry.
+	TaskHistoryPage struct {
+		Entries    []*TaskHistoryEntry
+		NextCursor string
+		PrevCursor string
+	}
+
+	// TaskHistoryEntryResponse is the specification that represents a task history entry HTTP response.
+	TaskHistoryEntryResponse struct {
+		ID        int64                `json:"id"`
+		TaskID    string               `json:"task_id"`
+		Operation TaskHistoryOperation `json:"operation"`
+		Before    *TaskResponse        `json:"before"`
+		After     *TaskResponse        `json:"after"`
+		Actor     string               `json:"actor"`
+		RequestID string               `json:"request_id"`
+		CreatedAt int64                `json:"created_at"`
+	}
+
+	// TaskHistoryPageResponse is the specification that represents a task history page HTTP response.
+	TaskHistoryPageResponse struct {
+		Data       []*TaskHistoryEntryResponse `json:"data"`
+		NextCursor string                      `json:"next_cursor,omitempty"`
+		PrevCursor string                      `json:"prev_cursor,omitempty"`
+	}
+)
+
+// Normalize applies the defaults and validates the task history query.
+func (q TaskHistoryQuery) Normalize() (TaskHistoryQuery, error) {
+	page, err := q.Page.Normalize()
+	if err != nil {
+		return q, err
+	}
+	q.Page = page
+
+	if q.Page.Cursor != "" {
+		c, err := DecodeCursor(q.Page.Cursor)
+		if err != nil {
+			return q, err
+		}
+
+		if c.Sort != TaskHistorySort {
+			return q, NewFieldError("cursor", fmt.Sprintf("was issued for sort %s", c.Sort))
+		}
+
+		if _, err := strconv.ParseInt(c.ID, 10, 64); err != nil {
+			return q, NewFieldError("cursor", "is invalid")
+		}
+	}
+
+	return q, nil
+}
+
+// NewTaskHistoryEntityPage builds a page from history entries fetched with one extra row beyond limit.
+func NewTaskHistoryEntityPage(entities []*TaskHistoryEntity, query TaskHistoryQuery, cursor *Cursor) *TaskHistoryEntityPage {
+	entities, next, prev := paginate(entities, query.Page.Limit, cursor, func(e *TaskHistoryEntity) Cursor {
+		return Cursor{Sort: TaskHistorySort, ID: strconv.FormatInt(e.ID, 10)}
+	})
+
+	return &TaskHistoryEntityPage{
+		Entities:   entities,
+		NextCursor: next,
+		PrevCursor: prev,
+	}
+}
+
+// ToSpec converts a TaskHistoryEntityPage to a TaskHistoryPage.
+func (p *TaskHistoryEntityPage) ToSpec() *TaskHistoryPage {
+	entries := make([]*TaskHistoryEntry, len(p.Entities))
+	for i, e := range p.Entities {
+		entries[i] = &TaskHistoryEntry{
+			ID:        e.ID,
+			TaskID:    e.TaskID,
+			Operation: e.Operation,
+			After:     e.After.ToSpec(),
+			Actor:     e.Actor,
+			RequestID: e.RequestID,
+			CreatedAt: e.CreatedAt,
+		}
+
+		if e.Before != nil {
+			entries[i].Before = e.Before.ToSpec()
+		}
+	}
+
+	return &TaskHistoryPage{
+		Entries:    entries,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+	}
+}
+
+// ToResponse converts a TaskHistoryPage to a TaskHistoryPageResponse.
+func (p *TaskHistoryPage) ToResponse() *TaskHistoryPageResponse {
+	data := make([]*TaskHistoryEntryResponse, len(p.Entries))
+	for i, e := range p.Entries {
+		data[i] = &TaskHistoryEntryResponse{
+			ID:        e.ID,
+			TaskID:    e.TaskID,
+			Operation: e.Operation,
+			After:     e.After.ToResponse(),
+			Actor:     e.Actor,
+			RequestID: e.RequestID,
+			CreatedAt: e.CreatedAt.UnixMilli(),
+		}
+
+		if e.Before != nil {
+			data[i].Before = e.Before.ToResponse()
+		}
+	}
+
+	return &TaskHistoryPageResponse{
+		Data:       data,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+	}
+}
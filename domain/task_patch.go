@@ -0,0 +1,125 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// TaskPatchFormat is the media type of a task patch document.
+type TaskPatchFormat string
+
+const (
+	TaskMergePatch TaskPatchFormat = "application/merge-patch+json"
+	TaskJSONPatch  TaskPatchFormat = "application/json-patch+json"
+)
+
+type (
+	// TaskDocumentPatchSpec is the specification that represents a patch document applied to the representation of a task.
+	// A non-nil ExpectedVersion makes the patch conditional on the current version of the task.
+	TaskDocumentPatchSpec struct {
+		ID              string
+		Format          TaskPatchFormat
+		Document        json.RawMessage
+		ExpectedVersion *VersionMatch
+	}
+)
+
+// ApplyPatchDocument applies a patch document to the TaskResponse representation of t
+// and returns the changes as a TaskPatchSpec, which has no fields set when nothing changed.
+// Only name and is_active can be changed, removing is_active resets it to false.
+func (t *Task) ApplyPatchDocument(format TaskPatchFormat, document json.RawMessage) (TaskPatchSpec, error) {
+	spec := TaskPatchSpec{ID: t.ID}
+
+	original, err := t.document()
+	if err != nil {
+		return spec, err
+	}
+
+	patched, err := t.document()
+	if err != nil {
+		return spec, err
+	}
+
+	switch format {
+	case TaskMergePatch:
+		var patch any
+		if err := json.Unmarshal(document, &patch); err != nil {
+			return spec, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+
+		result, ok := ApplyMergePatch(patched, patch).(map[string]any)
+		if !ok {
+			return spec, fmt.Errorf("%w: merge patch must be an object", ErrValidation)
+		}
+		patched = result
+	case TaskJSONPatch:
+		var ops []JSONPatchOperation
+		if err := json.Unmarshal(document, &ops); err != nil {
+			return spec, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+
+		if err := ApplyJSONPatch(patched, ops); err != nil {
+			return spec, err
+		}
+	default:
+		return spec, fmt.Errorf("%w: unsupported patch format: %s", ErrValidation, format)
+	}
+
+	for k, v := range patched {
+		switch k {
+		case "name", "is_active":
+		default:
+			if _, known := original[k]; !known {
+				return spec, fmt.Errorf("%w: unknown field: %s", ErrValidation, k)
+			}
+
+			if !reflect.DeepEqual(original[k], v) {
+				return spec, fmt.Errorf("%w: field is read-only: %s", ErrValidation, k)
+			}
+		}
+	}
+
+	for k := range original {
+		if _, ok := patched[k]; !ok && k != "is_active" {
+			return spec, fmt.Errorf("%w: field cannot be removed: %s", ErrValidation, k)
+		}
+	}
+
+	name, ok := patched["name"].(string)
+	if !ok {
+		return spec, fmt.Errorf("%w: name must be a string", ErrValidation)
+	}
+
+	isActive := false
+	if v, ok := patched["is_active"]; ok {
+		if isActive, ok = v.(bool); !ok {
+			return spec, fmt.Errorf("%w: is_active must be a boolean", ErrValidation)
+		}
+	}
+
+	if name != t.Name {
+		spec.Name = &name
+	}
+
+	if isActive != t.IsActive {
+		spec.IsActive = &isActive
+	}
+
+	return spec, nil
+}
+
+// document returns the TaskResponse representation of t as a decoded JSON object.
+func (t *Task) document() (map[string]any, error) {
+	b, err := json.Marshal(t.ToResponse())
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,127 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TaskSortField is a task column that listings can be sorted by.
+type TaskSortField string
+
+const (
+	TaskSortByID             TaskSortField = "id"
+	TaskSortByName           TaskSortField = "name"
+	TaskSortByCreatedAt      TaskSortField = "created_at"
+	TaskSortByLastModifiedAt TaskSortField = "last_modified_at"
+	TaskSortByIsActive       TaskSortField = "is_active"
+	TaskSortByDeletedAt      TaskSortField = "deleted_at"
+)
+
+type (
+	// TaskFilter is the specification that represents the conditions a listed task must match.
+	// Zero values leave the corresponding condition unset, except Trashed which lists the trash instead of live tasks.
+	TaskFilter struct {
+		Trashed            bool
+		IsActive           *bool
+		NamePrefix         string
+		NameContains       string
+		CreatedAfter       time.Time
+		CreatedBefore      time.Time
+		LastModifiedAfter  time.Time
+		LastModifiedBefore time.Time
+	}
+
+	// TaskSort is the specification that represents the order of a task listing.
+	TaskSort struct {
+		Field TaskSortField
+		Desc  bool
+	}
+
+	// TaskQuery is the specification that represents a task listing request.
+	TaskQuery struct {
+		Filter TaskFilter
+		Sort   TaskSort
+		Page   PageSpec
+	}
+)
+
+// Valid reports whether f is a known sort field.
+func (f TaskSortField) Valid() bool {
+	switch f {
+	case TaskSortByID, TaskSortByName, TaskSortByCreatedAt, TaskSortByLastModifiedAt, TaskSortByIsActive, TaskSortByDeletedAt:
+		return true
+	default:
+		return false
+	}
+}
+
+// Value returns the canonical text of the sort column of e, used as a keyset cursor value.
+func (f TaskSortField) Value(e *TaskEntity) string {
+	switch f {
+	case TaskSortByID:
+		return e.ID
+	case TaskSortByName:
+		return e.Name
+	case TaskSortByLastModifiedAt:
+		return e.LastModifiedAt.UTC().Format(time.RFC3339Nano)
+	case TaskSortByIsActive:
+		return strconv.FormatBool(e.IsActive)
+	case TaskSortByDeletedAt:
+		return e.DeletedAt.UTC().Format(time.RFC3339Nano)
+	default:
+		return e.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// String returns the text representation of the sort, e.g. "name:desc".
+func (s TaskSort) String() string {
+	if s.Desc {
+		return fmt.Sprintf("%s:desc", s.Field)
+	}
+
+	return fmt.Sprintf("%s:asc", s.Field)
+}
+
+// Normalize applies the defaults and validates the task query.
+func (q TaskQuery) Normalize() (TaskQuery, error) {
+	if q.Sort.Field == "" {
+		q.Sort.Field = TaskSortByCreatedAt
+	}
+
+	if !q.Sort.Field.Valid() {
+		return q, NewFieldError("sort", fmt.Sprintf("is not a sortable field: %s", q.Sort.Field))
+	}
+
+	if q.Sort.Field == TaskSortByDeletedAt && !q.Filter.Trashed {
+		return q, NewFieldError("sort", fmt.Sprintf("can only be %s in the trash", q.Sort.Field))
+	}
+
+	f := q.Filter
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() && f.CreatedAfter.After(f.CreatedBefore) {
+		return q, NewFieldError("created_after", "must not be after created_before")
+	}
+
+	if !f.LastModifiedAfter.IsZero() && !f.LastModifiedBefore.IsZero() && f.LastModifiedAfter.After(f.LastModifiedBefore) {
+		return q, NewFieldError("last_modified_after", "must not be after last_modified_before")
+	}
+
+	page, err := q.Page.Normalize()
+	if err != nil {
+		return q, err
+	}
+	q.Page = page
+
+	if q.Page.Cursor != "" {
+		c, err := DecodeCursor(q.Page.Cursor)
+		if err != nil {
+			return q, err
+		}
+
+		if c.Sort != q.Sort.String() {
+			return q, NewFieldError("cursor", fmt.Sprintf("was issued for sort %s", c.Sort))
+		}
+	}
+
+	return q, nil
+}
@@ -0,0 +1,138 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TaskSearchSort is the cursor sort of search results, which are ordered by relevance.
+const TaskSearchSort string = "rank"
+
+type (
+	// TaskSearchQuery is the specification that represents a task full-text search request.
+	TaskSearchQuery struct {
+		Text string
+		Page PageSpec
+	}
+
+	// TaskSearchEntity is the repository entity that represents a task search hit.
+	// Rank is the raw storage relevance where lower is better, Score is its client-facing inverse.
+	TaskSearchEntity struct {
+		TaskEntity
+		Rank    float64
+		Score   float64
+		Snippet string
+	}
+
+	// TaskSearchEntityPage is the repository page of TaskSearchEntity.
+	TaskSearchEntityPage struct {
+		Entities   []*TaskSearchEntity
+		NextCursor string
+		PrevCursor string
+	}
+
+	// TaskSearchHit is a task matched by a search.
+	TaskSearchHit struct {
+		Task    *Task
+		Score   float64
+		Snippet string
+	}
+
+	// TaskSearchPage is the page of TaskSearchHit.
+	TaskSearchPage struct {
+		Hits       []*TaskSearchHit
+		NextCursor string
+		PrevCursor string
+	}
+
+	// TaskSearchHitResponse is the specification that represents a task search hit HTTP response.
+	TaskSearchHitResponse struct {
+		*TaskResponse
+		Score   float64 `json:"score"`
+		Snippet string  `json:"snippet"`
+	}
+
+	// TaskSearchPageResponse is the specification that represents a task search page HTTP response.
+	TaskSearchPageResponse struct {
+		Data       []*TaskSearchHitResponse `json:"data"`
+		NextCursor string                   `json:"next_cursor,omitempty"`
+		PrevCursor string                   `json:"prev_cursor,omitempty"`
+	}
+)
+
+// Normalize applies the defaults and validates the task search query.
+func (q TaskSearchQuery) Normalize() (TaskSearchQuery, error) {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Text == "" {
+		return q, NewFieldError("q", "is required")
+	}
+
+	page, err := q.Page.Normalize()
+	if err != nil {
+		return q, err
+	}
+	q.Page = page
+
+	if q.Page.Cursor != "" {
+		c, err := DecodeCursor(q.Page.Cursor)
+		if err != nil {
+			return q, err
+		}
+
+		if c.Sort != TaskSearchSort {
+			return q, NewFieldError("cursor", fmt.Sprintf("was issued for sort %s", c.Sort))
+		}
+	}
+
+	return q, nil
+}
+
+// NewTaskSearchEntityPage builds a page from search hits fetched with one extra row beyond limit.
+func NewTaskSearchEntityPage(entities []*TaskSearchEntity, query TaskSearchQuery, cursor *Cursor) *TaskSearchEntityPage {
+	entities, next, prev := paginate(entities, query.Page.Limit, cursor, func(e *TaskSearchEntity) Cursor {
+		return Cursor{Sort: TaskSearchSort, Value: strconv.FormatFloat(e.Rank, 'g', -1, 64), ID: e.ID}
+	})
+
+	return &TaskSearchEntityPage{
+		Entities:   entities,
+		NextCursor: next,
+		PrevCursor: prev,
+	}
+}
+
+// ToSpec converts a TaskSearchEntityPage to a TaskSearchPage.
+func (p *TaskSearchEntityPage) ToSpec() *TaskSearchPage {
+	hits := make([]*TaskSearchHit, len(p.Entities))
+	for i, e := range p.Entities {
+		hits[i] = &TaskSearchHit{
+			Task:    e.ToSpec(),
+			Score:   e.Score,
+			Snippet: e.Snippet,
+		}
+	}
+
+	return &TaskSearchPage{
+		Hits:       hits,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+	}
+}
+
+// ToResponse converts a TaskSearchPage to a TaskSearchPageResponse.
+func (p *TaskSearchPage) ToResponse() *TaskSearchPageResponse {
+	data := make([]*TaskSearchHitResponse, len(p.Hits))
+	for i, h := range p.Hits {
+		data[i] = &TaskSearchHitResponse{
+			TaskResponse: h.Task.ToResponse(),
+			Score:        h.Score,
+			Snippet:      h.Snippet,
+		}
+	}
+
+	return &TaskSearchPageResponse{
+		Data:       data,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+	}
+}
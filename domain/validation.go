@@ -0,0 +1,237 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// TaskNameMaxLength is the maximum number of characters of a task name.
+	TaskNameMaxLength int = 255
+
+	// TaskIDMaxLength is the maximum number of characters of a client-chosen task id.
+	TaskIDMaxLength int = 64
+)
+
+var (
+	// TaskNameRules are the rules every task name must satisfy, whichever operation sets it.
+	TaskNameRules []StringRule = []StringRule{
+		Required,
+		Trimmed,
+		MaxLength(TaskNameMaxLength),
+		PrintableCharacters,
+	}
+
+	// TaskIDRules are the rules a client-chosen task id must satisfy.
+	TaskIDRules []StringRule = []StringRule{
+		Required,
+		MaxLength(TaskIDMaxLength),
+		IDCharacters,
+	}
+)
+
+type (
+	// FieldError is a validation failure of a single request field.
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+
+	// ValidationError is the list of field errors of a request, it is classified as ErrValidation.
+	ValidationError struct {
+		Errors []FieldError
+	}
+
+	// StringRule checks a string value and returns the message of a violation, or an empty string.
+	StringRule func(value string) string
+
+	// Validation collects the field errors of a request.
+	Validation struct {
+		errs []FieldError
+	}
+)
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fmt.Sprintf("%s %s", fe.Field, fe.Message)
+	}
+
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(msgs, ", "))
+}
+
+// Is reports whether target is ErrValidation.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// NewFieldError returns a ValidationError of a single field.
+func NewFieldError(field, message string) *ValidationError {
+	return &ValidationError{Errors: []FieldError{{Field: field, Message: message}}}
+}
+
+// Field checks value against rules, recording the first violation as an error of field.
+func (v *Validation) Field(field, value string, rules ...StringRule) *Validation {
+	for _, rule := range rules {
+		if msg := rule(value); msg != "" {
+			v.errs = append(v.errs, FieldError{Field: field, Message: msg})
+			break
+		}
+	}
+
+	return v
+}
+
+// Err returns a ValidationError of the recorded field errors, or nil when there are none.
+func (v *Validation) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Errors: v.errs}
+}
+
+// Required rejects the empty string.
+func Required(value string) string {
+	if value == "" {
+		return "is required"
+	}
+
+	return ""
+}
+
+// Trimmed rejects leading and trailing whitespace.
+func Trimmed(value string) string {
+	if strings.TrimSpace(value) != value {
+		return "must not have leading or trailing whitespace"
+	}
+
+	return ""
+}
+
+// MaxLength rejects values longer than n characters.
+func MaxLength(n int) StringRule {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) > n {
+			return fmt.Sprintf("must be at most %d characters", n)
+		}
+
+		return ""
+	}
+}
+
+// PrintableCharacters rejects invalid UTF-8 and control characters.
+func PrintableCharacters(value string) string {
+	if !utf8.ValidString(value) {
+		return "must be valid UTF-8"
+	}
+
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return "must not contain control characters"
+		}
+	}
+
+	return ""
+}
+
+// IDCharacters rejects anything but ASCII letters, digits, '-' and '_'.
+func IDCharacters(value string) string {
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "must only contain letters, digits, '-' and '_'"
+		}
+	}
+
+	return ""
+}
+
+// Validate checks the fields of a task store request.
+func (r TaskStoreRequest) Validate() error {
+	return new(Validation).
+		Field("name", r.Name, TaskNameRules...).
+		Err()
+}
+
+// Validate checks the fields set by a task patch.
+func (s TaskPatchSpec) Validate() error {
+	v := new(Validation)
+	if s.Name != nil {
+		v.Field("name", *s.Name, TaskNameRules...)
+	}
+
+	return v.Err()
+}
+
+// Validate checks that a task replace request has every field, a replacement leaves none out.
+func (r TaskReplaceRequest) Validate() error {
+	v := new(Validation)
+	if r.Name == nil {
+		v.errs = append(v.errs, FieldError{Field: "name", Message: "is required"})
+	}
+
+	if r.IsActive == nil {
+		v.errs = append(v.errs, FieldError{Field: "is_active", Message: "is required"})
+	}
+
+	return v.Err()
+}
+
+// Validate checks the fields of a task replacement.
+func (s TaskReplaceSpec) Validate() error {
+	return new(Validation).
+		Field("id", s.ID, TaskIDRules...).
+		Field("name", s.Name, TaskNameRules...).
+		Err()
+}
+
+// Validate checks the shape of a task batch, the operations are validated one by one with TaskBatchOperation.Validate.
+func (s TaskBatchSpec) Validate() error {
+	v := new(Validation)
+	if s.Mode != TaskBatchAllOrNothing && s.Mode != TaskBatchBestEffort {
+		v.errs = append(v.errs, FieldError{Field: "mode", Message: fmt.Sprintf("must be %s or %s", TaskBatchAllOrNothing, TaskBatchBestEffort)})
+	}
+
+	if len(s.Operations) == 0 || len(s.Operations) > MaxBatchOperations {
+		v.errs = append(v.errs, FieldError{Field: "operations", Message: fmt.Sprintf("must have between 1 and %d operations", MaxBatchOperations)})
+	}
+
+	return v.Err()
+}
+
+// Validate checks the fields of a task batch operation against the rules of the operation it performs.
+func (o TaskBatchOperation) Validate() error {
+	v := new(Validation)
+
+	switch o.Kind {
+	case TaskBatchCreate:
+		if o.ID != "" {
+			v.errs = append(v.errs, FieldError{Field: "id", Message: "is not allowed"})
+		}
+
+		if o.Name == nil {
+			v.errs = append(v.errs, FieldError{Field: "name", Message: "is required"})
+		} else {
+			v.Field("name", *o.Name, TaskNameRules...)
+		}
+	case TaskBatchPatch:
+		v.Field("id", o.ID, Required)
+		if o.Name == nil && o.IsActive == nil {
+			v.errs = append(v.errs, FieldError{Field: "name", Message: "or is_active is required"})
+		} else if o.Name != nil {
+			v.Field("name", *o.Name, TaskNameRules...)
+		}
+	case TaskBatchDelete:
+		v.Field("id", o.ID, Required)
+		if o.Name != nil || o.IsActive != nil {
+			v.errs = append(v.errs, FieldError{Field: "op", Message: "delete does not take name or is_active"})
+		}
+	default:
+		v.errs = append(v.errs, FieldError{Field: "op", Message: fmt.Sprintf("must be %s, %s or %s", TaskBatchCreate, TaskBatchPatch, TaskBatchDelete)})
+	}
+
+	return v.Err()
+}
@@ -0,0 +1,43 @@
+package domain
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// VersionMatch is the condition of a write on the current version of a task, as sent with If-Match.
+// Any is the "*" condition, met by every existing task, otherwise the task must be at one of Versions.
+// The methods of a nil *VersionMatch treat it as no condition at all.
+type VersionMatch struct {
+	Any      bool
+	Versions []int64
+}
+
+// MatchVersion returns the VersionMatch of a single version, or nil when version is nil.
+func MatchVersion(version *int64) *VersionMatch {
+	if version == nil {
+		return nil
+	}
+
+	return &VersionMatch{Versions: []int64{*version}}
+}
+
+// Matches reports whether a task at version meets m.
+func (m *VersionMatch) Matches(version int64) bool {
+	return m == nil || m.Any || slices.Contains(m.Versions, version)
+}
+
+// String returns m as the entity tags of an If-Match header.
+func (m *VersionMatch) String() string {
+	if m == nil || m.Any {
+		return "*"
+	}
+
+	tags := make([]string, len(m.Versions))
+	for i, version := range m.Versions {
+		tags[i] = strconv.Quote(strconv.FormatInt(version, 10))
+	}
+
+	return strings.Join(tags, ", ")
+}
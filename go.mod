@@ -1,5 +1,10 @@
 module github.com/anon-org/developing-api-services-with-golang
 
-go 1.18
+go 1.21
 
-require github.com/mattn/go-sqlite3 v1.14.14
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.14
+	gopkg.in/yaml.v3 v3.0.1
+)
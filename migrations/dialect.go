@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+var (
+	//go:embed sqlite/*.sql
+	sqliteFS embed.FS
+
+	//go:embed postgres/*.sql
+	postgresFS embed.FS
+)
+
+// Dialect is the database specific part of the migrator: the migration files and the bookkeeping queries.
+type Dialect struct {
+	Name string
+	FS   fs.FS
+
+	queryCreateTable     string
+	queryFetchApplied    string
+	queryStoreApplied    string
+	queryDestroyApplied  string
+	queryCreateLockTable string
+	queryReleaseStale    string
+	queryAcquireLock     string
+	queryReleaseLock     string
+
+	// features maps a requirement of a migration to a query reporting whether the database supports it.
+	features map[string]string
+}
+
+var (
+	// Sqlite is the dialect of the sqlite3 driver.
+	Sqlite Dialect = Dialect{
+		Name: "sqlite",
+		FS:   mustSub(sqliteFS, "sqlite"),
+
+		queryCreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations(
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		queryFetchApplied: `SELECT version, checksum, applied_at
+FROM schema_migrations
+ORDER BY version ASC`,
+		queryStoreApplied: `INSERT INTO schema_migrations (version, name, checksum)
+VALUES ($1, $2, $3)`,
+		queryDestroyApplied: `DELETE FROM schema_migrations WHERE version = $1`,
+		queryCreateLockTable: `CREATE TABLE IF NOT EXISTS schema_migrations_lock(
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	locked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		queryReleaseStale: `DELETE FROM schema_migrations_lock WHERE locked_at < datetime('now', '-10 minutes')`,
+		queryAcquireLock:  `INSERT INTO schema_migrations_lock (id) VALUES (1)`,
+		queryReleaseLock:  `DELETE FROM schema_migrations_lock WHERE id = 1`,
+
+		features: map[string]string{
+			"fts5": `SELECT sqlite_compileoption_used('ENABLE_FTS5')`,
+		},
+	}
+
+	// Postgres is the dialect of the lib/pq driver.
+	Postgres Dialect = Dialect{
+		Name: "postgres",
+		FS:   mustSub(postgresFS, "postgres"),
+
+		queryCreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations(
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		queryFetchApplied: `SELECT version, checksum, applied_at
+FROM schema_migrations
+ORDER BY version ASC`,
+		queryStoreApplied: `INSERT INTO schema_migrations (version, name, checksum)
+VALUES ($1, $2, $3)`,
+		queryDestroyApplied: `DELETE FROM schema_migrations WHERE version = $1`,
+		queryCreateLockTable: `CREATE TABLE IF NOT EXISTS schema_migrations_lock(
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	locked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		queryReleaseStale: `DELETE FROM schema_migrations_lock WHERE locked_at < CURRENT_TIMESTAMP - INTERVAL '10 minutes'`,
+		queryAcquireLock:  `INSERT INTO schema_migrations_lock (id) VALUES (1)`,
+		queryReleaseLock:  `DELETE FROM schema_migrations_lock WHERE id = 1`,
+	}
+)
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+
+	return sub
+}
@@ -0,0 +1,434 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	requiresDirective  string        = "-- requires:"
+	lockReleaseTimeout time.Duration = 10 * time.Second
+)
+
+var (
+	ErrLocked           error = errors.New("migrations are locked by another run")
+	ErrChecksumMismatch error = errors.New("applied migration was modified")
+	ErrUnknownVersion   error = errors.New("unknown migration version")
+	ErrInvalidFile      error = errors.New("invalid migration file")
+	ErrMissingFeature   error = errors.New("database lacks a feature required by a migration")
+
+	fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+type (
+	// Migration is a versioned schema change read from a pair of up and down SQL files.
+	Migration struct {
+		Version  uint
+		Name     string
+		Up       string
+		Down     string
+		Checksum string
+		Requires []string
+	}
+
+	// Status is the state of a migration in the database.
+	Status struct {
+		Version   uint
+		Name      string
+		Applied   bool
+		AppliedAt time.Time
+		Modified  bool
+		Missing   []string
+	}
+
+	// Migrator applies and rolls back the migrations of a dialect.
+	Migrator struct {
+		db         *sql.DB
+		dialect    Dialect
+		migrations []Migration
+
+		// skipMissing skips migrations requiring a feature the database lacks instead of failing, see WithSkipMissing.
+		skipMissing bool
+	}
+
+	applied struct {
+		checksum  string
+		appliedAt time.Time
+	}
+)
+
+// New returns a Migrator for the migrations embedded in dialect.
+func New(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	migrations, err := parse(dialect.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+	}, nil
+}
+
+// WithSkipMissing sets whether a migration requiring a feature the database lacks is skipped with a warning.
+// By default it fails with ErrMissingFeature, since the schema would not be the one the migrations describe.
+func (m *Migrator) WithSkipMissing(skip bool) *Migrator {
+	m.skipMissing = skip
+	return m
+}
+
+// Latest returns the highest known migration version.
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.run(ctx, func(conn *sql.Conn, done map[uint]applied) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := done[m.migrations[i].Version]; ok {
+				return m.rollback(ctx, conn, m.migrations[i])
+			}
+		}
+
+		return nil
+	})
+}
+
+// To applies or rolls back migrations until version is the highest applied one. Version 0 rolls back everything.
+// A migration requiring a feature the database lacks fails with ErrMissingFeature, unless WithSkipMissing is set.
+func (m *Migrator) To(ctx context.Context, version uint) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.run(ctx, func(conn *sql.Conn, done map[uint]applied) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mg := m.migrations[i]
+			if _, ok := done[mg.Version]; ok && mg.Version > version {
+				if err := m.rollback(ctx, conn, mg); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := done[mg.Version]; ok || mg.Version > version {
+				continue
+			}
+
+			missing, err := m.missing(ctx, conn, mg)
+			if err != nil {
+				return err
+			}
+
+			if len(missing) > 0 {
+				if !m.skipMissing {
+					return fmt.Errorf("%w: migration %d_%s requires: %s", ErrMissingFeature, mg.Version, mg.Name, strings.Join(missing, ", "))
+				}
+
+				logutil.GetCtxLogger(ctx).Warn("skipping migration", "version", mg.Version, "name", mg.Name, "missing", strings.Join(missing, ", "))
+				continue
+			}
+
+			if err := m.apply(ctx, conn, mg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Status reports the state of every known migration.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.queryCreateTable); err != nil {
+		return nil, fmt.Errorf("%w: failed to create migrations table", err)
+	}
+
+	done, err := m.fetchApplied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, mg := range m.migrations {
+		s := Status{
+			Version: mg.Version,
+			Name:    mg.Name,
+		}
+
+		if a, ok := done[mg.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.appliedAt
+			s.Modified = a.checksum != mg.Checksum
+		} else if s.Missing, err = m.missing(ctx, conn, mg); err != nil {
+			return nil, err
+		}
+
+		statuses[i] = s
+	}
+
+	return statuses, nil
+}
+
+// run holds the migration lock on a single connection while fn changes the schema.
+// Applied migrations whose files were edited since are reported before anything runs.
+func (m *Migrator) run(ctx context.Context, fn func(*sql.Conn, map[uint]applied) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to connect", err)
+	}
+	defer conn.Close()
+
+	for _, query := range []string{m.dialect.queryCreateTable, m.dialect.queryCreateLockTable, m.dialect.queryReleaseStale} {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("%w: failed to prepare migrations tables", err)
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.queryAcquireLock); err != nil {
+		return fmt.Errorf("%w: %v", ErrLocked, err)
+	}
+	defer func() {
+		// release even when ctx is done, otherwise the lock is held until it goes stale
+		l := logutil.GetCtxLogger(ctx)
+		ctx, cancel := context.WithTimeout(context.Background(), lockReleaseTimeout)
+		defer cancel()
+
+		if _, err := conn.ExecContext(ctx, m.dialect.queryReleaseLock); err != nil {
+			l.Error("failed to release migration lock", "error", err)
+		}
+	}()
+
+	done, err := m.fetchApplied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, mg := range m.migrations {
+		if a, ok := done[mg.Version]; ok && a.checksum != mg.Checksum {
+			return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, mg.Version, mg.Name)
+		}
+	}
+
+	return fn(conn, done)
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mg Migration) error {
+	logutil.GetCtxLogger(ctx).Info("applying migration", "version", mg.Version, "name", mg.Name)
+
+	return m.inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mg.Up); err != nil {
+			return fmt.Errorf("%w: failed to apply migration %d_%s", err, mg.Version, mg.Name)
+		}
+
+		if _, err := tx.ExecContext(ctx, m.dialect.queryStoreApplied, mg.Version, mg.Name, mg.Checksum); err != nil {
+			return fmt.Errorf("%w: failed to record migration %d_%s", err, mg.Version, mg.Name)
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, mg Migration) error {
+	logutil.GetCtxLogger(ctx).Info("rolling back migration", "version", mg.Version, "name", mg.Name)
+
+	return m.inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, mg.Down); err != nil {
+			return fmt.Errorf("%w: failed to roll back migration %d_%s", err, mg.Version, mg.Name)
+		}
+
+		if _, err := tx.ExecContext(ctx, m.dialect.queryDestroyApplied, mg.Version); err != nil {
+			return fmt.Errorf("%w: failed to unrecord migration %d_%s", err, mg.Version, mg.Name)
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, fn func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: failed to begin transaction", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			logutil.GetCtxLogger(ctx).Warn("failed to roll back transaction", "error", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: failed to commit transaction", err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) fetchApplied(ctx context.Context, conn *sql.Conn) (map[uint]applied, error) {
+	rows, err := conn.QueryContext(ctx, m.dialect.queryFetchApplied)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch applied migrations", err)
+	}
+	defer rows.Close()
+
+	done := make(map[uint]applied)
+	for rows.Next() {
+		var (
+			version uint
+			a       applied
+		)
+
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan applied migrations", err)
+		}
+
+		done[version] = a
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch applied migrations", err)
+	}
+
+	return done, nil
+}
+
+// missing returns the requirements of mg the database does not support.
+func (m *Migrator) missing(ctx context.Context, conn *sql.Conn, mg Migration) ([]string, error) {
+	missing := make([]string, 0)
+	for _, feature := range mg.Requires {
+		query, ok := m.dialect.features[feature]
+		if !ok {
+			missing = append(missing, feature)
+			continue
+		}
+
+		var supported bool
+		if err := conn.QueryRowContext(ctx, query).Scan(&supported); err != nil {
+			return nil, fmt.Errorf("%w: failed to check feature %s", err, feature)
+		}
+
+		if !supported {
+			missing = append(missing, feature)
+		}
+	}
+
+	return missing, nil
+}
+
+func (m *Migrator) find(version uint) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+
+	return nil
+}
+
+// parse reads the NNNN_name.up.sql and NNNN_name.down.sql pairs of fsys ordered by version.
+func parse(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read migrations", err)
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFile, entry.Name())
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFile, entry.Name())
+		}
+
+		b, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read %s", err, entry.Name())
+		}
+
+		mg, ok := byVersion[uint(version)]
+		if !ok {
+			mg = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = mg
+		}
+
+		if mg.Name != match[2] {
+			return nil, fmt.Errorf("%w: version %d has two names", ErrInvalidFile, version)
+		}
+
+		if match[3] == "up" {
+			mg.Up = string(b)
+			mg.Requires = requires(mg.Up)
+		} else {
+			mg.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("%w: version %d needs both an up and a down file", ErrInvalidFile, mg.Version)
+		}
+
+		sum := sha256.Sum256([]byte(mg.Up + "\x00" + mg.Down))
+		mg.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *mg)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// requires reads the "-- requires: a, b" directives at the top of an up file.
+func requires(up string) []string {
+	features := make([]string, 0)
+	for _, line := range strings.Split(up, "\n") {
+		if !strings.HasPrefix(line, requiresDirective) {
+			break
+		}
+
+		for _, f := range strings.Split(strings.TrimPrefix(line, requiresDirective), ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				features = append(features, f)
+			}
+		}
+	}
+
+	return features
+}
@@ -0,0 +1,191 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/anon-org/developing-api-services-with-golang/migrations"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+	"testing/fstest"
+)
+
+func newDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// every connection to :memory: is a distinct database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func newDialect() migrations.Dialect {
+	d := migrations.Sqlite
+	d.FS = fstest.MapFS{
+		"0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a(id INTEGER);")},
+		"0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b(id INTEGER);")},
+		"0002_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0003_create_c.up.sql":   {Data: []byte("-- requires: unknown\nCREATE TABLE c(id INTEGER);")},
+		"0003_create_c.down.sql": {Data: []byte("DROP TABLE c;")},
+	}
+	return d
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = $1)`, name).Scan(&exists); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return exists
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+
+	m, err := migrations.New(db, newDialect())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	t.Run("up", func(t *testing.T) {
+		if err := m.Up(ctx); !errors.Is(err, migrations.ErrMissingFeature) {
+			t.Fatalf("expected ErrMissingFeature, got %v", err)
+		}
+
+		if err := m.WithSkipMissing(true).Up(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !tableExists(t, db, "a") || !tableExists(t, db, "b") {
+			t.Errorf("expected tables a and b to exist")
+		}
+
+		if tableExists(t, db, "c") {
+			t.Errorf("expected migration with unsupported requirement to be skipped")
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(statuses) != 3 {
+			t.Fatalf("expected 3 statuses, got %d", len(statuses))
+		}
+
+		if !statuses[0].Applied || !statuses[1].Applied || statuses[2].Applied {
+			t.Errorf("expected 1 and 2 applied, got %v", statuses)
+		}
+
+		if len(statuses[2].Missing) != 1 || statuses[2].Missing[0] != "unknown" {
+			t.Errorf("expected missing unknown, got %v", statuses[2].Missing)
+		}
+	})
+
+	t.Run("down", func(t *testing.T) {
+		if err := m.Down(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !tableExists(t, db, "a") || tableExists(t, db, "b") {
+			t.Errorf("expected only table a to exist")
+		}
+	})
+
+	t.Run("to", func(t *testing.T) {
+		if err := m.To(ctx, 0); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if tableExists(t, db, "a") {
+			t.Errorf("expected table a to be dropped")
+		}
+
+		if err := m.To(ctx, 2); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !tableExists(t, db, "a") || !tableExists(t, db, "b") {
+			t.Errorf("expected tables a and b to exist")
+		}
+
+		if err := m.To(ctx, 42); !errors.Is(err, migrations.ErrUnknownVersion) {
+			t.Errorf("expected ErrUnknownVersion, got %v", err)
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		d := newDialect()
+		d.FS.(fstest.MapFS)["0001_create_a.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE a(id TEXT);")}
+
+		edited, err := migrations.New(db, d)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if err := edited.Up(ctx); !errors.Is(err, migrations.ErrChecksumMismatch) {
+			t.Errorf("expected ErrChecksumMismatch, got %v", err)
+		}
+
+		statuses, err := edited.Status(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !statuses[0].Modified {
+			t.Errorf("expected migration 1 to be reported as modified")
+		}
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		if _, err := db.Exec(`INSERT INTO schema_migrations_lock (id) VALUES (1)`); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer db.Exec(`DELETE FROM schema_migrations_lock`)
+
+		if err := m.Up(ctx); !errors.Is(err, migrations.ErrLocked) {
+			t.Errorf("expected ErrLocked, got %v", err)
+		}
+	})
+}
+
+func TestNew_InvalidFile(t *testing.T) {
+	d := migrations.Sqlite
+	d.FS = fstest.MapFS{
+		"0001_create_a.up.sql": {Data: []byte("CREATE TABLE a(id INTEGER);")},
+	}
+
+	if _, err := migrations.New(newDB(t), d); !errors.Is(err, migrations.ErrInvalidFile) {
+		t.Errorf("expected ErrInvalidFile, got %v", err)
+	}
+}
+
+func TestDialects(t *testing.T) {
+	sqlite, err := migrations.New(nil, migrations.Sqlite)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	postgres, err := migrations.New(nil, migrations.Postgres)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// a schema version means the same schema in every dialect
+	if sqlite.Latest() != postgres.Latest() {
+		t.Errorf("expected the dialects at the same version, got sqlite %d and postgres %d", sqlite.Latest(), postgres.Latest())
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS tasks(
+	id TEXT COLLATE "C" PRIMARY KEY,
+	name TEXT COLLATE "C" UNIQUE NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT date_trunc('second', CURRENT_TIMESTAMP),
+	last_modified_at TIMESTAMPTZ NOT NULL DEFAULT 'epoch',
+	is_active BOOLEAN NOT NULL DEFAULT TRUE);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tasks_search;
+ALTER TABLE tasks DROP COLUMN search;
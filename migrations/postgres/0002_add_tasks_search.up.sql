@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', name)) STORED;
+CREATE INDEX tasks_search ON tasks USING GIN (search);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS task_history;
+DROP FUNCTION IF EXISTS task_history_immutable();
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS task_history(
+	id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	task_id TEXT NOT NULL,
+	operation TEXT NOT NULL,
+	before JSONB,
+	after JSONB NOT NULL,
+	actor TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT date_trunc('second', CURRENT_TIMESTAMP));
+CREATE INDEX IF NOT EXISTS task_history_task_id ON task_history (task_id, id);
+CREATE OR REPLACE FUNCTION task_history_immutable() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION 'task history is immutable';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER task_history_immutable BEFORE UPDATE OR DELETE ON task_history
+	FOR EACH ROW EXECUTE FUNCTION task_history_immutable();
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+	key TEXT COLLATE "C" PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	header JSONB,
+	body BYTEA,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tasks_name;
+ALTER TABLE tasks ADD CONSTRAINT tasks_name_key UNIQUE (name);
@@ -0,0 +1,2 @@
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_name_key;
+CREATE UNIQUE INDEX tasks_name ON tasks (name) WHERE deleted_at IS NULL;
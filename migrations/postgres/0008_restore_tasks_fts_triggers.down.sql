@@ -0,0 +1 @@
+-- nothing to roll back, see the up file
@@ -0,0 +1 @@
+-- the search column of 0002_add_tasks_search is generated, it needs no triggers, the version keeps the dialects aligned
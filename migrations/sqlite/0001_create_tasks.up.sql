@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS tasks(
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_modified_at TIMESTAMP NOT NULL DEFAULT 0,
+	is_active BOOL NOT NULL DEFAULT TRUE);
@@ -0,0 +1,12 @@
+-- requires: fts5
+CREATE VIRTUAL TABLE IF NOT EXISTS tasks_fts USING fts5(id UNINDEXED, name);
+INSERT INTO tasks_fts (id, name) SELECT id, name FROM tasks WHERE id NOT IN (SELECT id FROM tasks_fts);
+CREATE TRIGGER IF NOT EXISTS tasks_fts_insert AFTER INSERT ON tasks BEGIN
+	INSERT INTO tasks_fts (id, name) VALUES (new.id, new.name);
+END;
+CREATE TRIGGER IF NOT EXISTS tasks_fts_update AFTER UPDATE OF id, name ON tasks BEGIN
+	UPDATE tasks_fts SET id = new.id, name = new.name WHERE id = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS tasks_fts_delete AFTER DELETE ON tasks BEGIN
+	DELETE FROM tasks_fts WHERE id = old.id;
+END;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS task_history_immutable_delete;
+DROP TRIGGER IF EXISTS task_history_immutable_update;
+DROP TABLE IF EXISTS task_history;
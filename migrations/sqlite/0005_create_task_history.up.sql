@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS task_history(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	task_id TEXT NOT NULL,
+	operation TEXT NOT NULL,
+	before TEXT,
+	after TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX IF NOT EXISTS task_history_task_id ON task_history (task_id, id);
+CREATE TRIGGER IF NOT EXISTS task_history_immutable_update BEFORE UPDATE ON task_history BEGIN
+	SELECT RAISE(ABORT, 'task history is immutable');
+END;
+CREATE TRIGGER IF NOT EXISTS task_history_immutable_delete BEFORE DELETE ON task_history BEGIN
+	SELECT RAISE(ABORT, 'task history is immutable');
+END;
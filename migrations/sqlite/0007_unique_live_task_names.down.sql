@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tasks_name;
+CREATE UNIQUE INDEX tasks_name ON tasks (name);
@@ -0,0 +1,15 @@
+-- the unique constraint of the name cannot be dropped, so the table is rebuilt without it
+CREATE TABLE tasks_unique_live_names(
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_modified_at TIMESTAMP NOT NULL DEFAULT 0,
+	is_active BOOL NOT NULL DEFAULT TRUE,
+	deleted_at TIMESTAMP,
+	version INTEGER NOT NULL DEFAULT 1);
+INSERT INTO tasks_unique_live_names (id, name, created_at, last_modified_at, is_active, deleted_at, version)
+	SELECT id, name, created_at, last_modified_at, is_active, deleted_at, version FROM tasks;
+DROP TABLE tasks;
+ALTER TABLE tasks_unique_live_names RENAME TO tasks;
+CREATE INDEX tasks_deleted_at ON tasks (deleted_at);
+CREATE UNIQUE INDEX tasks_name ON tasks (name) WHERE deleted_at IS NULL;
@@ -0,0 +1 @@
+-- the triggers belong to 0002_create_tasks_fts, which drops them
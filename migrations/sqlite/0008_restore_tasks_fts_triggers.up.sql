@@ -0,0 +1,11 @@
+-- requires: fts5
+-- the triggers of 0002_create_tasks_fts were dropped with the table rebuilt by 0007_unique_live_task_names
+CREATE TRIGGER IF NOT EXISTS tasks_fts_insert AFTER INSERT ON tasks BEGIN
+	INSERT INTO tasks_fts (id, name) VALUES (new.id, new.name);
+END;
+CREATE TRIGGER IF NOT EXISTS tasks_fts_update AFTER UPDATE OF id, name ON tasks BEGIN
+	UPDATE tasks_fts SET id = new.id, name = new.name WHERE id = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS tasks_fts_delete AFTER DELETE ON tasks BEGIN
+	DELETE FROM tasks_fts WHERE id = old.id;
+END;
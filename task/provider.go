@@ -3,56 +3,269 @@ package task
 import (
 	"database/sql"
 	"github.com/anon-org/developing-api-services-with-golang/domain"
-	"sync"
+	"github.com/anon-org/developing-api-services-with-golang/util/idutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/metricutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+	"log/slog"
+	"time"
 )
 
-var (
-	v1RepoSqlite     *v1RepositorySqlite
-	v1RepoSqliteOnce sync.Once
+type (
+	// Option configures the instances built by New and the Provide functions.
+	Option func(*options)
 
-	v1Svc     *v1Service
-	v1SvcOnce sync.Once
+	options struct {
+		logger         *slog.Logger
+		now            func() time.Time
+		ids            domain.IDGenerator
+		repo           domain.TaskRepository
+		queryTimeout   time.Duration
+		requestTimeout time.Duration
+		maxBodyBytes   int64
+		idempotencyTTL time.Duration
+		metrics        *metricutil.Registry
+		tracer         *traceutil.Tracer
+		debug          bool
+	}
 
-	v1TrpHTTP     *v1TransportHTTP
-	v1TrpHTTPOnce sync.Once
+	// Container is an independent wiring of the task API, nothing in it is shared with another Container.
+	Container struct {
+		Repository domain.TaskRepository
+		Service    domain.TaskService
+		Transport  *v1TransportHTTP
+		Metrics    *metricutil.Registry
+
+		options options
+	}
 )
 
-// ProvideV1RepositorySqlite provides a v1RepositorySqlite implementation.
-func ProvideV1RepositorySqlite(db *sql.DB) *v1RepositorySqlite {
-	v1RepoSqliteOnce.Do(func() {
-		v1RepoSqlite = &v1RepositorySqlite{
-			db: db,
-		}
-	})
+// WithLogger sets the logger the request and background loggers write through, it defaults to logutil.NewStdLogger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithClock sets the source of the current time, it defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithIDGenerator sets the generator of the ids of new tasks, it defaults to idutil.NewULID.
+func WithIDGenerator(ids domain.IDGenerator) Option {
+	return func(o *options) {
+		o.ids = ids
+	}
+}
+
+// WithRepository sets the repository of New, it defaults to a new in-memory repository.
+func WithRepository(repo domain.TaskRepository) Option {
+	return func(o *options) {
+		o.repo = repo
+	}
+}
+
+// WithQueryTimeout sets the deadline of every database query, it defaults to 10 seconds.
+// A batch gets the deadline once per operation, plus once for preparing its statements.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.queryTimeout = timeout
+	}
+}
+
+// WithRequestTimeout sets the deadline of every HTTP request, zero means none.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithMaxBodyBytes sets the size limit of every HTTP request body, a larger body is rejected with 413.
+// It defaults to 1 MiB, zero or less means no limit.
+func WithMaxBodyBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBodyBytes = n
+	}
+}
+
+// WithIdempotencyTTL sets how long the response to a request with an Idempotency-Key is replayed, it defaults to 24 hours.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.idempotencyTTL = ttl
+	}
+}
+
+// WithMetrics sets the registry the HTTP requests and repository operations are recorded in.
+// New defaults to a new registry, the Provide functions record nothing without one.
+func WithMetrics(r *metricutil.Registry) Option {
+	return func(o *options) {
+		o.metrics = r
+	}
+}
+
+// WithTracer sets the tracer of the spans of the HTTP requests, service calls and SQL queries, nil traces nothing.
+func WithTracer(tracer *traceutil.Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithDebug toggles whether internal error details are exposed in problem responses.
+func WithDebug(debug bool) Option {
+	return func(o *options) {
+		o.debug = debug
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		logger:         logutil.NewStdLogger(),
+		now:            time.Now,
+		ids:            idutil.NewULID(),
+		queryTimeout:   queryDefaultTimeout,
+		maxBodyBytes:   v1HTTPMaxBodyDefaultBytes,
+		idempotencyTTL: idempotencyDefaultTTL,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-	return v1RepoSqlite
+	return o
+}
+
+// New returns a Container wired with opts.
+func New(opts ...Option) *Container {
+	o := newOptions(opts)
+	if o.metrics == nil {
+		opts = append(opts, WithMetrics(metricutil.NewRegistry()))
+		o = newOptions(opts)
+	}
+
+	if o.repo == nil {
+		o.repo = ProvideV1RepositoryMemory(opts...)
+	}
+
+	repo := ProvideV1RepositoryMetrics(o.repo, opts...)
+	svc := ProvideV1ServiceTracing(ProvideV1Service(repo, opts...), opts...)
+
+	return &Container{
+		Repository: repo,
+		Service:    svc,
+		Transport:  ProvideV1TransportHTTP(svc, opts...),
+		Metrics:    o.metrics,
+		options:    o,
+	}
+}
+
+// Purger provides a v1Purger of the service of c.
+func (c *Container) Purger(retention, interval time.Duration) *v1Purger {
+	return ProvideV1Purger(c.Service, retention, interval, WithLogger(c.options.logger))
+}
+
+// ProvideV1RepositorySqlite provides a v1RepositorySQL implementation with the sqliteDialect.
+func ProvideV1RepositorySqlite(db *sql.DB, opts ...Option) *v1RepositorySQL {
+	o := newOptions(opts)
+
+	return &v1RepositorySQL{
+		db:           db,
+		queryTimeout: o.queryTimeout,
+		tracer:       sqlTracer{tracer: o.tracer, system: sqliteDialect.system},
+		dialect:      sqliteDialect,
+	}
+}
+
+// ProvideV1RepositoryPostgres provides a v1RepositorySQL implementation with the postgresDialect.
+func ProvideV1RepositoryPostgres(db *sql.DB, opts ...Option) *v1RepositorySQL {
+	o := newOptions(opts)
+
+	return &v1RepositorySQL{
+		db:           db,
+		queryTimeout: o.queryTimeout,
+		tracer:       sqlTracer{tracer: o.tracer, system: postgresDialect.system},
+		dialect:      postgresDialect,
+	}
+}
+
+// ProvideV1RepositoryMemory provides an empty v1RepositoryMemory implementation.
+func ProvideV1RepositoryMemory(opts ...Option) *v1RepositoryMemory {
+	return &v1RepositoryMemory{
+		store: &memoryStore{
+			state: newMemoryState(newOptions(opts).now),
+		},
+	}
+}
+
+// ProvideV1RepositoryMetrics provides a v1RepositoryMetrics implementation wrapping repo, or repo itself without WithMetrics.
+func ProvideV1RepositoryMetrics(repo domain.TaskRepository, opts ...Option) domain.TaskRepository {
+	o := newOptions(opts)
+	if o.metrics == nil {
+		return repo
+	}
+
+	return v1RepositoryMetrics{
+		repo:    repo,
+		metrics: newV1Metrics(o.metrics),
+		now:     o.now,
+	}
 }
 
 // ProvideV1Service provides a v1Service implementation.
-func ProvideV1Service(repo domain.TaskRepository) *v1Service {
-	v1SvcOnce.Do(func() {
-		v1Svc = &v1Service{
-			repo: repo,
-		}
-	})
+func ProvideV1Service(repo domain.TaskRepository, opts ...Option) *v1Service {
+	o := newOptions(opts)
 
-	return v1Svc
+	return &v1Service{
+		repo:           repo,
+		now:            o.now,
+		ids:            o.ids,
+		idempotencyTTL: o.idempotencyTTL,
+	}
+}
+
+// ProvideV1ServiceTracing provides a v1ServiceTracing implementation wrapping svc, or svc itself without WithTracer.
+func ProvideV1ServiceTracing(svc domain.TaskService, opts ...Option) domain.TaskService {
+	o := newOptions(opts)
+	if o.tracer == nil {
+		return svc
+	}
+
+	return v1ServiceTracing{
+		svc:    svc,
+		tracer: o.tracer,
+	}
 }
 
 // ProvideV1TransportHTTP provides a v1TransportHTTP implementation.
-func ProvideV1TransportHTTP(svc domain.TaskService) *v1TransportHTTP {
-	v1TrpHTTPOnce.Do(func() {
-		v1TrpHTTP = &v1TransportHTTP{
-			svc: svc,
-		}
-	})
+func ProvideV1TransportHTTP(svc domain.TaskService, opts ...Option) *v1TransportHTTP {
+	o := newOptions(opts)
+
+	return &v1TransportHTTP{
+		svc:            svc,
+		debug:          o.debug,
+		logger:         o.logger,
+		now:            o.now,
+		requestTimeout: o.requestTimeout,
+		maxBodyBytes:   o.maxBodyBytes,
+		ids:            o.ids,
+		metrics:        newV1Metrics(o.metrics),
+		tracer:         o.tracer,
+	}
+}
 
-	return v1TrpHTTP
+// ProvideV1Purger provides a v1Purger implementation.
+func ProvideV1Purger(svc domain.TaskService, retention, interval time.Duration, opts ...Option) *v1Purger {
+	return &v1Purger{
+		svc:       svc,
+		retention: retention,
+		interval:  interval,
+		logger:    newOptions(opts).logger,
+	}
 }
 
-// Wire provides a v1TransportHTTP implementation.
-func Wire(db *sql.DB) *v1TransportHTTP {
-	repo := ProvideV1RepositorySqlite(db)
-	svc := ProvideV1Service(repo)
-	return ProvideV1TransportHTTP(svc)
+// Wire provides a v1TransportHTTP implementation backed by the sqlite database db.
+func Wire(db *sql.DB, opts ...Option) *v1TransportHTTP {
+	return New(append([]Option{WithRepository(ProvideV1RepositorySqlite(db, opts...))}, opts...)...).Transport
 }
@@ -0,0 +1,294 @@
+package task_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/migrations"
+	"github.com/anon-org/developing-api-services-with-golang/task"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fixedID string
+
+func (f fixedID) Generate() (string, error) {
+	if f == "" {
+		return "", errors.New("no id left")
+	}
+
+	return string(f), nil
+}
+
+func newSqliteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// every connection to :memory: is a distinct database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.New(db, migrations.Sqlite)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// the search migrations are skipped unless sqlite was built with FTS5, see searchAvailable
+	if err := migrator.WithSkipMissing(true).Up(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return db
+}
+
+func storeThrough(t *testing.T, route http.HandlerFunc, name string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(domain.TaskStoreRequest{Name: name}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	res := httptest.NewRecorder()
+	route.ServeHTTP(res, httptest.NewRequest(http.MethodPost, task.V1HTTPEndpoint, &b))
+
+	return res
+}
+
+func TestNew_Isolated(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+
+			// every container has its own repository, so they all can store the same name
+			api := task.New().Transport.Route()
+			if res := storeThrough(t, api, "isolated"); res.Code != http.StatusCreated {
+				t.Errorf("expected 201, got %d", res.Code)
+			}
+
+			if res := storeThrough(t, api, "isolated"); res.Code != http.StatusConflict {
+				t.Errorf("expected 409, got %d", res.Code)
+			}
+		})
+	}
+}
+
+func TestWire_Independent(t *testing.T) {
+	first := task.Wire(newSqliteDB(t)).Route()
+	second := task.Wire(newSqliteDB(t)).Route()
+
+	if res := storeThrough(t, first, "wired"); res.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", res.Code)
+	}
+
+	if res := storeThrough(t, second, "wired"); res.Code != http.StatusCreated {
+		t.Errorf("expected the second wiring to use its own database, got %d", res.Code)
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	c := task.New(
+		task.WithClock(func() time.Time { return now }),
+		task.WithIDGenerator(fixedID("fixed-id")),
+	)
+
+	stored, err := c.Service.Store(context.Background(), "with options")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if stored.ID != "fixed-id" || !stored.CreatedAt.Equal(now) {
+		t.Errorf("expected fixed-id created at %v, got %s created at %v", now, stored.ID, stored.CreatedAt)
+	}
+
+	if err := c.Service.DestroyByID(context.Background(), domain.TaskDestroySpec{ID: stored.ID}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// the trash is as old as the clock says, whatever the wall clock
+	now = now.Add(time.Hour)
+	if n, err := c.Service.PurgeTrashed(context.Background(), time.Minute); err != nil || n != 1 {
+		t.Errorf("expected 1 purged task, got %d %v", n, err)
+	}
+}
+
+func TestNew_IDGeneratorFailure(t *testing.T) {
+	c := task.New(task.WithIDGenerator(fixedID("")))
+
+	// a failing generator fails the request instead of the whole process
+	if _, err := c.Service.Store(context.Background(), "without id"); err == nil {
+		t.Errorf("expected an error, got none")
+	}
+
+	if res := storeThrough(t, c.Transport.Route(), "without id"); res.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", res.Code)
+	}
+}
+
+func TestNew_StructuredLogs(t *testing.T) {
+	var b bytes.Buffer
+	api := task.New(task.WithLogger(logutil.NewLogger(logutil.Config{Output: &b, Format: logutil.FormatJSON}))).Transport.Route()
+
+	res := storeThrough(t, api, "logged")
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", res.Code)
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %v: %s", err, b.String())
+	}
+
+	if record["msg"] != "request served" || record["method"] != http.MethodPost || record["status"] != float64(http.StatusCreated) || record["duration"] == nil {
+		t.Errorf("expected an access record, got %v", record)
+	}
+
+	// the log records are correlated with the response
+	if id := res.Header().Get("X-Request-ID"); id == "" || record[logutil.RequestIDKey] != id {
+		t.Errorf("expected request id %s to be logged, got %v", id, record[logutil.RequestIDKey])
+	}
+}
+
+func TestNew_Metrics(t *testing.T) {
+	c := task.New()
+	api := c.Transport.Route()
+
+	storeThrough(t, api, "measured")
+	storeThrough(t, api, "measured")
+
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, httptest.NewRequest(http.MethodGet, task.V1HTTPEndpoint+"01ARZ3NDEKTSV4RRFFQ69G5FAV", nil))
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	c.Metrics.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, task.V1MetricsEndpoint, nil))
+
+	// the task id is not a label, its route template is
+	for _, sample := range []string{
+		`http_requests_total{route="/v1/tasks/",method="POST",status="201"} 1`,
+		`http_requests_total{route="/v1/tasks/",method="POST",status="409"} 1`,
+		`http_requests_total{route="/v1/tasks/{id}",method="GET",status="404"} 1`,
+		`http_request_duration_seconds_count{route="/v1/tasks/{id}",method="GET",status="404"} 1`,
+		`task_repository_operation_duration_seconds_count{operation="store"} 2`,
+		`task_repository_operation_errors_total{operation="store",kind="conflict"} 1`,
+		`task_repository_operation_errors_total{operation="fetch_by_id",kind="not_found"} 1`,
+	} {
+		if !strings.Contains(res.Body.String(), sample+"\n") {
+			t.Errorf("expected %s, got\n%s", sample, res.Body.String())
+		}
+	}
+}
+
+// spanRecorder is a traceutil.Exporter keeping the exported spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []traceutil.SpanData
+}
+
+func (r *spanRecorder) Export(_ context.Context, spans []traceutil.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestWire_Tracing(t *testing.T) {
+	rec := &spanRecorder{}
+	tracer := traceutil.NewTracer(traceutil.Config{Exporter: rec})
+	api := task.Wire(newSqliteDB(t), task.WithTracer(tracer)).Route()
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(domain.TaskStoreRequest{Name: "traced 'secret'"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, task.V1HTTPEndpoint, &b)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res := httptest.NewRecorder()
+	api.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", res.Code)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	spans := make(map[string]traceutil.SpanData)
+	for _, s := range rec.spans {
+		if s.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected every span to continue the trace of the client, got %s", s.SpanContext.TraceID)
+		}
+		spans[s.Name] = s
+	}
+
+	server, svc := spans["POST /v1/tasks/"], spans["v1Service.Store"]
+	if server.Parent.String() != "00f067aa0ba902b7" || server.Kind != traceutil.SpanKindServer {
+		t.Errorf("expected a server span child of the client, got %+v", server)
+	}
+
+	if svc.Parent != server.SpanContext.SpanID {
+		t.Errorf("expected the service call in the request, got %+v", svc)
+	}
+
+	var inserted bool
+	for _, s := range rec.spans {
+		for _, a := range s.Attributes {
+			if a.Key != "db.statement" || !strings.HasPrefix(a.Value.(string), "INSERT INTO tasks ") {
+				continue
+			}
+			inserted = true
+
+			if s.Parent != svc.SpanContext.SpanID || strings.Contains(a.Value.(string), "secret") {
+				t.Errorf("expected a sanitized query in the service call, got %+v", s)
+			}
+		}
+	}
+
+	if !inserted {
+		t.Errorf("expected a span of the inserting query, got %+v", rec.spans)
+	}
+}
+
+func TestPurger_Stop(t *testing.T) {
+	var b bytes.Buffer
+	c := task.New(task.WithLogger(logutil.NewLogger(logutil.Config{Output: &b})))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Purger(time.Hour, time.Hour).Run(ctx)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the purger to stop")
+	}
+
+	// stopping is not a failure
+	if b.Len() != 0 {
+		t.Errorf("expected no records, got %s", b.String())
+	}
+}
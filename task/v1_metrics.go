@@ -0,0 +1,116 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/util/metricutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// V1MetricsEndpoint is the endpoint serving the metrics of a Container.
+	V1MetricsEndpoint string = "/metrics"
+)
+
+// v1Metrics are the metrics of the task API, a nil *v1Metrics records nothing.
+type v1Metrics struct {
+	requests        *metricutil.CounterVec
+	requestDuration *metricutil.HistogramVec
+	queryDuration   *metricutil.HistogramVec
+	queryErrors     *metricutil.CounterVec
+}
+
+// newV1Metrics registers the metrics of the task API with r, or returns nil when r is nil.
+func newV1Metrics(r *metricutil.Registry) *v1Metrics {
+	if r == nil {
+		return nil
+	}
+
+	return &v1Metrics{
+		requests: r.NewCounterVec("http_requests_total",
+			"Total number of HTTP requests served.", "route", "method", "status"),
+		requestDuration: r.NewHistogramVec("http_request_duration_seconds",
+			"Latency of the HTTP requests served.", nil, "route", "method", "status"),
+		queryDuration: r.NewHistogramVec("task_repository_operation_duration_seconds",
+			"Latency of the task repository operations.", nil, "operation"),
+		queryErrors: r.NewCounterVec("task_repository_operation_errors_total",
+			"Total number of failed task repository operations.", "operation", "kind"),
+	}
+}
+
+// observeRequest records a request served for route, the path template of the request.
+func (m *v1Metrics) observeRequest(route, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	// unknown methods would let a client create any number of series
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+
+	code := strconv.Itoa(status)
+	m.requests.With(route, method, code).Inc()
+	m.requestDuration.With(route, method, code).Observe(duration.Seconds())
+}
+
+// observeOperation records a repository operation that returned err.
+func (m *v1Metrics) observeOperation(operation string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.queryDuration.With(operation).Observe(duration.Seconds())
+	if err != nil {
+		m.queryErrors.With(operation, errorKind(err)).Inc()
+	}
+}
+
+// errorKind names the domain kind of err, for the label of the error counters.
+func errorKind(err error) string {
+	for _, kind := range []struct {
+		err  error
+		name string
+	}{
+		{domain.ErrNotFound, "not_found"},
+		{domain.ErrGone, "gone"},
+		{domain.ErrConflict, "conflict"},
+		{domain.ErrValidation, "validation"},
+		{domain.ErrPreconditionFailed, "precondition_failed"},
+		{domain.ErrIdempotencyKeyReused, "idempotency_key_reused"},
+		{domain.ErrBatchAborted, "batch_aborted"},
+		{domain.ErrUnavailable, "unavailable"},
+		{context.DeadlineExceeded, "timeout"},
+		{context.Canceled, "canceled"},
+	} {
+		if errors.Is(err, kind.err) {
+			return kind.name
+		}
+	}
+
+	return "internal"
+}
+
+// v1Route returns the template of path, which keeps the task ids out of the metric labels.
+func v1Route(path string) string {
+	switch {
+	case path == V1HTTPEndpoint, path == V1HTTPSearchEndpoint, path == V1HTTPBatchEndpoint, path == V1HTTPTrashEndpoint:
+		return path
+	case strings.HasPrefix(path, V1HTTPTrashEndpoint) && strings.HasSuffix(path, v1HTTPRestoreSuffix):
+		return V1HTTPTrashEndpoint + "{id}" + v1HTTPRestoreSuffix
+	case strings.HasPrefix(path, V1HTTPTrashEndpoint):
+		return V1HTTPTrashEndpoint + "{id}"
+	case strings.HasPrefix(path, V1HTTPEndpoint) && strings.HasSuffix(path, v1HTTPHistorySuffix):
+		return V1HTTPEndpoint + "{id}" + v1HTTPHistorySuffix
+	case strings.HasPrefix(path, V1HTTPEndpoint):
+		return V1HTTPEndpoint + "{id}"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,57 @@
+package task
+
+import (
+	"context"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"log/slog"
+	"time"
+)
+
+// v1Purger permanently deletes tasks that stayed in the trash for longer than retention, and expired idempotency keys.
+type v1Purger struct {
+	svc       domain.TaskService
+	retention time.Duration
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// Run purges the trash and the idempotency keys right away and then every interval, until ctx is done.
+// It returns once the purge in progress, if any, is abandoned.
+func (v v1Purger) Run(ctx context.Context) {
+	l := v.logger
+	ctx = logutil.PutCtxLogger(ctx, l)
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := v.svc.PurgeTrashed(ctx, v.retention)
+		if ctx.Err() != nil {
+			// stopped during the purge, which was rolled back
+			return
+		}
+
+		if err != nil {
+			l.Error("failed to purge trashed tasks", "error", err)
+		} else if n > 0 {
+			l.Info("purged trashed tasks", "count", n, "retention", v.retention)
+		}
+
+		n, err = v.svc.PurgeIdempotencyKeys(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			l.Error("failed to purge expired idempotency keys", "error", err)
+		} else if n > 0 {
+			l.Info("purged expired idempotency keys", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
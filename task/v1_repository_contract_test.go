@@ -0,0 +1,645 @@
+package task_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/migrations"
+	"github.com/anon-org/developing-api-services-with-golang/task"
+	_ "github.com/lib/pq"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPostgresDSNEnv names a disposable postgres database for TestV1RepositoryPostgres_Contract, its schema is dropped.
+const testPostgresDSNEnv = "TEST_POSTGRES_DSN"
+
+func TestV1RepositorySqlite_Contract(t *testing.T) {
+	testV1RepositoryContract(t, task.ProvideV1RepositorySqlite(db), "Sqlite", searchAvailable)
+}
+
+func TestV1RepositoryPostgres_Contract(t *testing.T) {
+	dsn := os.Getenv(testPostgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testPostgresDSNEnv)
+	}
+
+	pg, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer pg.Close()
+
+	migrator, err := migrations.New(pg, migrations.Postgres)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// the suite expects no tasks of an earlier run, so the schema is rebuilt from scratch
+	ctx := context.Background()
+	if err := migrator.To(ctx, 0); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	testV1RepositoryContract(t, task.ProvideV1RepositoryPostgres(pg), "Postgres", true)
+}
+
+func TestV1RepositoryMemory_Contract(t *testing.T) {
+	testV1RepositoryContract(t, task.ProvideV1RepositoryMemory(), "Memory", true)
+}
+
+func TestV1RepositoryMemory_RunInTx_OtherStore(t *testing.T) {
+	ctx := context.Background()
+	repo, other := task.ProvideV1RepositoryMemory(), task.ProvideV1RepositoryMemory()
+	errRollback := errors.New("rollback")
+
+	err := repo.RunInTx(ctx, func(ctx context.Context, _ domain.TaskRepository) error {
+		// the unit of work belongs to repo, so other writes to its own store
+		if _, err := other.Store(ctx, domain.TaskEntity{ID: "OtherStore", Name: "OtherStore"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("expected %v, got %v", errRollback, err)
+	}
+
+	if _, err := other.FetchByID(ctx, "OtherStore"); err != nil {
+		t.Errorf("expected the task of the other store to be kept, got %v", err)
+	}
+
+	if _, err := repo.FetchByID(ctx, "OtherStore"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected %v, got %v", domain.ErrNotFound, err)
+	}
+}
+
+// testV1RepositoryContract checks the behavior every domain.TaskRepository implementation must have.
+// The repository may be shared with other tests, so the tasks of the suite are named after prefix.
+func testV1RepositoryContract(t *testing.T, repo domain.TaskRepository, prefix string, search bool) {
+	ctx := context.Background()
+	prefix = "Contract" + prefix
+	errRollback := errors.New("rollback")
+
+	store := func(t *testing.T, name string) *domain.TaskEntity {
+		t.Helper()
+
+		e, err := repo.Store(ctx, domain.TaskEntity{ID: prefix + "_" + name, Name: prefix + "_" + name})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		return e
+	}
+
+	exists := func(t *testing.T, id string) bool {
+		t.Helper()
+
+		_, err := repo.FetchByID(ctx, id)
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		return err == nil
+	}
+
+	version := func(v int64) *domain.VersionMatch {
+		return domain.MatchVersion(&v)
+	}
+
+	t.Run("store", func(t *testing.T) {
+		e := store(t, "Store")
+
+		if e.Version != 1 || !e.IsActive || e.CreatedAt.IsZero() || e.LastModifiedAt.Unix() != 0 || !e.DeletedAt.IsZero() {
+			t.Errorf("expected a new active task at version 1, got %+v", e)
+		}
+
+		fetched, err := repo.FetchByID(ctx, e.ID)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if *fetched != *e {
+			t.Errorf("expected %+v, got %+v", e, fetched)
+		}
+
+		if _, err := repo.Store(ctx, domain.TaskEntity{ID: e.ID + "_Other", Name: e.Name}); !errors.Is(err, domain.ErrConflict) {
+			t.Errorf("expected %v storing a taken name, got %v", domain.ErrConflict, err)
+		}
+
+		if _, err := repo.Store(ctx, domain.TaskEntity{ID: e.ID, Name: e.Name + "_Other"}); !errors.Is(err, domain.ErrConflict) {
+			t.Errorf("expected %v storing a taken id, got %v", domain.ErrConflict, err)
+		}
+
+		if _, err := repo.FetchByID(ctx, prefix+"_Missing"); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v, got %v", domain.ErrNotFound, err)
+		}
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		e := store(t, "Patch")
+		other := store(t, "Patch_Other")
+
+		name, active := e.Name+"_Amended", false
+		patched, err := repo.Patch(ctx, domain.TaskPatchSpec{ID: e.ID, Name: &name, IsActive: &active, ExpectedVersion: version(1)})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if patched.Name != name || patched.IsActive || patched.Version != 2 || patched.LastModifiedAt.Unix() == 0 || !patched.CreatedAt.Equal(e.CreatedAt) {
+			t.Errorf("expected renamed inactive task at version 2, got %+v", patched)
+		}
+
+		if _, err := repo.Patch(ctx, domain.TaskPatchSpec{ID: e.ID, Name: &other.Name}); !errors.Is(err, domain.ErrConflict) {
+			t.Errorf("expected %v, got %v", domain.ErrConflict, err)
+		}
+
+		if _, err := repo.Patch(ctx, domain.TaskPatchSpec{ID: e.ID, IsActive: &active, ExpectedVersion: version(1)}); !errors.Is(err, domain.ErrPreconditionFailed) {
+			t.Errorf("expected %v, got %v", domain.ErrPreconditionFailed, err)
+		}
+
+		if _, err := repo.Patch(ctx, domain.TaskPatchSpec{ID: prefix + "_Missing", IsActive: &active}); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v, got %v", domain.ErrNotFound, err)
+		}
+
+		if _, err := repo.Patch(ctx, domain.TaskPatchSpec{ID: e.ID}); !errors.Is(err, domain.ErrValidation) {
+			t.Errorf("expected %v, got %v", domain.ErrValidation, err)
+		}
+	})
+
+	t.Run("concurrent patch", func(t *testing.T) {
+		const writers = 4
+
+		// patchAll runs a patch of e from every writer at the same time
+		patchAll := func(e *domain.TaskEntity, expectedVersion *domain.VersionMatch) []error {
+			errs := make([]error, writers)
+			start := make(chan struct{})
+
+			var wg sync.WaitGroup
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					<-start
+
+					active := i%2 == 0
+					_, errs[i] = repo.Patch(ctx, domain.TaskPatchSpec{ID: e.ID, IsActive: &active, ExpectedVersion: expectedVersion})
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			return errs
+		}
+
+		conditional := store(t, "Concurrent_Conditional")
+
+		patched := 0
+		for _, err := range patchAll(conditional, version(1)) {
+			switch {
+			case err == nil:
+				patched++
+			case !errors.Is(err, domain.ErrPreconditionFailed):
+				t.Errorf("expected %v, got %v", domain.ErrPreconditionFailed, err)
+			}
+		}
+
+		if patched != 1 {
+			t.Errorf("expected a single patch to meet the precondition, got %d", patched)
+		}
+
+		if e, err := repo.FetchByID(ctx, conditional.ID); err != nil || e.Version != 2 {
+			t.Errorf("expected task at version 2, got %+v %v", e, err)
+		}
+
+		unconditional := store(t, "Concurrent_Unconditional")
+
+		for _, err := range patchAll(unconditional, nil) {
+			if err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}
+
+		history, err := repo.FetchHistory(ctx, domain.TaskHistoryQuery{TaskID: unconditional.ID, Page: domain.PageSpec{Limit: writers + 1}})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		// every patch applies to the version the one before it left, none is lost
+		for i, entry := range history.Entities[1:] {
+			if entry.Before.Version != int64(i+1) || entry.After.Version != int64(i+2) {
+				t.Errorf("expected patch from version %d to %d, got %+v", i+1, i+2, entry)
+			}
+		}
+
+		if len(history.Entities) != writers+1 {
+			t.Errorf("expected %d history entries, got %d", writers+1, len(history.Entities))
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		id := prefix + "_Replace"
+
+		created, isNew, err := repo.Replace(ctx, domain.TaskReplaceSpec{ID: id, Name: id, IsActive: false})
+		if err != nil || !isNew || created.Version != 1 || created.IsActive {
+			t.Fatalf("expected a new inactive task, got %+v %v %v", created, isNew, err)
+		}
+
+		replaced, isNew, err := repo.Replace(ctx, domain.TaskReplaceSpec{ID: id, Name: id + "_Replaced", IsActive: true, ExpectedVersion: version(1)})
+		if err != nil || isNew || replaced.Version != 2 || !replaced.IsActive || replaced.Name != id+"_Replaced" {
+			t.Fatalf("expected a replaced active task at version 2, got %+v %v %v", replaced, isNew, err)
+		}
+
+		if _, _, err := repo.Replace(ctx, domain.TaskReplaceSpec{ID: id + "_Missing", Name: id + "_Missing", ExpectedVersion: &domain.VersionMatch{Any: true}}); !errors.Is(err, domain.ErrPreconditionFailed) {
+			t.Errorf("expected %v, got %v", domain.ErrPreconditionFailed, err)
+		}
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		e := store(t, "Trash")
+
+		if err := repo.DestroyByID(ctx, domain.TaskDestroySpec{ID: e.ID, ExpectedVersion: version(2)}); !errors.Is(err, domain.ErrPreconditionFailed) {
+			t.Errorf("expected %v, got %v", domain.ErrPreconditionFailed, err)
+		}
+
+		if err := repo.Purge(ctx, e.ID); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v purging a live task, got %v", domain.ErrNotFound, err)
+		}
+
+		if err := repo.DestroyByID(ctx, domain.TaskDestroySpec{ID: e.ID}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if exists(t, e.ID) {
+			t.Errorf("expected %s to be trashed", e.ID)
+		}
+
+		if err := repo.DestroyByID(ctx, domain.TaskDestroySpec{ID: e.ID}); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v destroying twice, got %v", domain.ErrNotFound, err)
+		}
+
+		if _, _, err := repo.Replace(ctx, domain.TaskReplaceSpec{ID: e.ID, Name: e.Name}); !errors.Is(err, domain.ErrGone) {
+			t.Errorf("expected %v replacing a trashed task, got %v", domain.ErrGone, err)
+		}
+
+		page, err := repo.Fetch(ctx, domain.TaskQuery{
+			Filter: domain.TaskFilter{Trashed: true, NamePrefix: e.Name},
+			Sort:   domain.TaskSort{Field: domain.TaskSortByDeletedAt, Desc: true},
+			Page:   domain.PageSpec{Limit: 10},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(page.Entities) != 1 || page.Entities[0].DeletedAt.IsZero() || page.Entities[0].Version != 2 {
+			t.Fatalf("expected %s in trash at version 2, got %+v", e.ID, page.Entities)
+		}
+
+		// the name of a trashed task is free, until the task is restored
+		other, err := repo.Store(ctx, domain.TaskEntity{ID: e.ID + "_Other", Name: e.Name})
+		if err != nil {
+			t.Fatalf("expected no error storing the name of a trashed task, got %v", err)
+		}
+
+		if _, err := repo.Restore(ctx, e.ID); !errors.Is(err, domain.ErrConflict) {
+			t.Errorf("expected %v restoring a task whose name is taken, got %v", domain.ErrConflict, err)
+		}
+
+		if err := repo.DestroyByID(ctx, domain.TaskDestroySpec{ID: other.ID}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if err := repo.Purge(ctx, other.ID); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		restored, err := repo.Restore(ctx, e.ID)
+		if err != nil || !restored.DeletedAt.IsZero() || restored.Version != 3 {
+			t.Fatalf("expected restored task at version 3, got %+v %v", restored, err)
+		}
+
+		if _, err := repo.Restore(ctx, e.ID); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v restoring a live task, got %v", domain.ErrNotFound, err)
+		}
+
+		if err := repo.DestroyByID(ctx, domain.TaskDestroySpec{ID: e.ID}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if err := repo.Purge(ctx, e.ID); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if _, err := repo.Restore(ctx, e.ID); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v restoring a purged task, got %v", domain.ErrNotFound, err)
+		}
+
+		store(t, "Trash")
+	})
+
+	t.Run("purge trashed", func(t *testing.T) {
+		e := store(t, "PurgeTrashed")
+		if err := repo.DestroyByID(ctx, domain.TaskDestroySpec{ID: e.ID}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if _, err := repo.PurgeTrashed(ctx, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if _, err := repo.Restore(ctx, e.ID); err != nil {
+			t.Fatalf("expected %s to outlive an earlier purge, got %v", e.ID, err)
+		}
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		names := []string{"Fetch_C", "Fetch_A", "Fetch_B", "Fetch_E", "Fetch_D"}
+		for _, name := range names {
+			store(t, name)
+		}
+
+		active := false
+		if _, err := repo.Patch(ctx, domain.TaskPatchSpec{ID: prefix + "_Fetch_B", IsActive: &active}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		query := domain.TaskQuery{
+			Filter: domain.TaskFilter{NamePrefix: prefix + "_fetch_"},
+			Sort:   domain.TaskSort{Field: domain.TaskSortByName, Desc: true},
+			Page:   domain.PageSpec{Limit: 2},
+		}
+
+		var got []string
+		for {
+			page, err := repo.Fetch(ctx, query)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			for _, e := range page.Entities {
+				got = append(got, e.Name)
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			query.Page.Cursor = page.NextCursor
+		}
+
+		if want := fmt.Sprint([]string{prefix + "_Fetch_E", prefix + "_Fetch_D", prefix + "_Fetch_C", prefix + "_Fetch_B", prefix + "_Fetch_A"}); fmt.Sprint(got) != want {
+			t.Errorf("expected %s, got %v", want, got)
+		}
+
+		query.Filter.IsActive = &active
+		query.Page.Cursor = ""
+		page, err := repo.Fetch(ctx, query)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(page.Entities) != 1 || page.Entities[0].Name != prefix+"_Fetch_B" {
+			t.Errorf("expected %s_Fetch_B, got %+v", prefix, page.Entities)
+		}
+
+		query = domain.TaskQuery{
+			Filter: domain.TaskFilter{NameContains: "_fetch_", LastModifiedAfter: time.Now().Add(-time.Hour)},
+			Sort:   domain.TaskSort{Field: domain.TaskSortByLastModifiedAt},
+			Page:   domain.PageSpec{Limit: 10},
+		}
+		page, err = repo.Fetch(ctx, query)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(page.Entities) != 1 || page.Entities[0].Name != prefix+"_Fetch_B" {
+			t.Errorf("expected only the modified %s_Fetch_B, got %+v", prefix, page.Entities)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		if !search {
+			t.Skip("full-text search is not available")
+		}
+
+		for _, name := range []string{"Search quarterly ledger", "Search quarterly levy", "Search annual ledger"} {
+			store(t, name)
+		}
+
+		page, err := repo.Search(ctx, domain.TaskSearchQuery{Text: "quarterly led", Page: domain.PageSpec{Limit: 10}})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(page.Entities) != 1 || page.Entities[0].Name != prefix+"_Search quarterly ledger" || page.Entities[0].Snippet == "" {
+			t.Errorf("expected %s_Search quarterly ledger, got %+v", prefix, page.Entities)
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		e := store(t, "History")
+
+		active := false
+		if _, err := repo.Patch(ctx, domain.TaskPatchSpec{ID: e.ID, IsActive: &active}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if err := repo.DestroyByID(ctx, domain.TaskDestroySpec{ID: e.ID}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		query := domain.TaskHistoryQuery{TaskID: e.ID, Page: domain.PageSpec{Limit: 2}}
+		first, err := repo.FetchHistory(ctx, query)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		query.Page.Cursor = first.NextCursor
+		second, err := repo.FetchHistory(ctx, query)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		entries := append(first.Entities, second.Entities...)
+		if len(entries) != 3 || entries[0].Operation != domain.TaskHistoryStore || entries[1].Operation != domain.TaskHistoryPatch || entries[2].Operation != domain.TaskHistoryDestroy {
+			t.Fatalf("expected store, patch and destroy, got %+v", entries)
+		}
+
+		if entries[0].Before != nil || entries[1].Before.Version != 1 || entries[1].After.IsActive || entries[2].After.DeletedAt.IsZero() {
+			t.Errorf("expected the before and after states of each change, got %+v", entries)
+		}
+
+		if _, err := repo.FetchHistory(ctx, domain.TaskHistoryQuery{TaskID: prefix + "_Missing", Page: domain.PageSpec{Limit: 2}}); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v, got %v", domain.ErrNotFound, err)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		e := store(t, "Batch")
+		name := prefix + "_Batch_Created"
+
+		results, err := repo.Batch(ctx, domain.TaskBatchSpec{
+			Mode: domain.TaskBatchAllOrNothing,
+			Operations: []domain.TaskBatchOperation{
+				{Kind: domain.TaskBatchCreate, ID: name, Name: &name},
+				{Kind: domain.TaskBatchDelete, ID: prefix + "_Missing"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !errors.Is(results[0].Err, domain.ErrBatchAborted) || !errors.Is(results[1].Err, domain.ErrNotFound) || exists(t, name) {
+			t.Errorf("expected the batch to be rolled back, got %+v %+v", results[0], results[1])
+		}
+
+		results, err = repo.Batch(ctx, domain.TaskBatchSpec{
+			Mode: domain.TaskBatchBestEffort,
+			Operations: []domain.TaskBatchOperation{
+				{Kind: domain.TaskBatchCreate, ID: name, Name: &name},
+				{Kind: domain.TaskBatchDelete, ID: prefix + "_Missing"},
+				{Kind: domain.TaskBatchDelete, ID: e.ID},
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if results[0].Err != nil || results[0].Entity.Name != name || !errors.Is(results[1].Err, domain.ErrNotFound) || results[2].Err != nil {
+			t.Errorf("expected every operation to run on its own, got %+v %+v %+v", results[0], results[1], results[2])
+		}
+
+		if !exists(t, name) || exists(t, e.ID) {
+			t.Errorf("expected %s to be created and %s destroyed", name, e.ID)
+		}
+	})
+
+	t.Run("idempotency", func(t *testing.T) {
+		// the keys expire long ago, so that purging them leaves the keys of other tests alone
+		now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		record := domain.IdempotencyRecord{Key: prefix + "_Key", Fingerprint: "first", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+		stored, reserved, err := repo.ReserveIdempotencyKey(ctx, record)
+		if err != nil || !reserved || !stored.IsPending() {
+			t.Fatalf("expected a pending reservation, got %+v %v %v", stored, reserved, err)
+		}
+
+		retry := record
+		retry.Fingerprint = "second"
+		stored, reserved, err = repo.ReserveIdempotencyKey(ctx, retry)
+		if err != nil || reserved || stored.Fingerprint != "first" {
+			t.Fatalf("expected the first reservation, got %+v %v %v", stored, reserved, err)
+		}
+
+		record.Status, record.Header, record.Body = 201, map[string][]string{"Etag": {`"1"`}}, []byte(`{"id":"1"}`)
+		if err := repo.CompleteIdempotencyKey(ctx, record); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if err := repo.CompleteIdempotencyKey(ctx, record); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("expected %v completing a completed key, got %v", domain.ErrNotFound, err)
+		}
+
+		// only a pending key is released
+		if err := repo.ReleaseIdempotencyKey(ctx, record.Key); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		stored, _, err = repo.ReserveIdempotencyKey(ctx, retry)
+		if err != nil || stored.Status != 201 || len(stored.Header["Etag"]) != 1 || string(stored.Body) != `{"id":"1"}` {
+			t.Fatalf("expected the completed record, got %+v %v", stored, err)
+		}
+
+		retry.CreatedAt, retry.ExpiresAt = now.Add(time.Hour), now.Add(2*time.Hour)
+		if _, reserved, err := repo.ReserveIdempotencyKey(ctx, retry); err != nil || !reserved {
+			t.Fatalf("expected an expired key to be reserved again, got %v %v", reserved, err)
+		}
+
+		if err := repo.ReleaseIdempotencyKey(ctx, retry.Key); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if _, reserved, err := repo.ReserveIdempotencyKey(ctx, retry); err != nil || !reserved {
+			t.Fatalf("expected a released key to be reserved again, got %v %v", reserved, err)
+		}
+
+		if n, err := repo.PurgeIdempotencyKeys(ctx, now.Add(2*time.Hour)); err != nil || n != 1 {
+			t.Errorf("expected 1 purged key, got %d %v", n, err)
+		}
+	})
+
+	t.Run("unit of work", func(t *testing.T) {
+		storeIn := func(ctx context.Context, repo domain.TaskRepository, name string) error {
+			_, err := repo.Store(ctx, domain.TaskEntity{ID: prefix + "_" + name, Name: prefix + "_" + name})
+			return err
+		}
+
+		err := repo.RunInTx(ctx, func(ctx context.Context, repo domain.TaskRepository) error {
+			if err := storeIn(ctx, repo, "Tx_Commit_1"); err != nil {
+				return err
+			}
+			return storeIn(ctx, repo, "Tx_Commit_2")
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !exists(t, prefix+"_Tx_Commit_1") || !exists(t, prefix+"_Tx_Commit_2") {
+			t.Errorf("expected both tasks to be committed")
+		}
+
+		err = repo.RunInTx(ctx, func(ctx context.Context, repo domain.TaskRepository) error {
+			if err := storeIn(ctx, repo, "Tx_Rollback"); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("expected %v, got %v", errRollback, err)
+		}
+
+		if exists(t, prefix+"_Tx_Rollback") {
+			t.Errorf("expected task to be rolled back")
+		}
+
+		err = repo.RunInTx(ctx, func(ctx context.Context, repo domain.TaskRepository) error {
+			if err := storeIn(ctx, repo, "Tx_Outer"); err != nil {
+				return err
+			}
+
+			err := repo.RunInTx(ctx, func(ctx context.Context, repo domain.TaskRepository) error {
+				if err := storeIn(ctx, repo, "Tx_Inner"); err != nil {
+					return err
+				}
+				return errRollback
+			})
+			if !errors.Is(err, errRollback) {
+				return fmt.Errorf("expected %v, got %v", errRollback, err)
+			}
+
+			// a failed call only rolls back its own changes
+			if err := storeIn(ctx, repo, "Tx_Outer"); !errors.Is(err, domain.ErrConflict) {
+				return fmt.Errorf("expected %v, got %v", domain.ErrConflict, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !exists(t, prefix+"_Tx_Outer") {
+			t.Errorf("expected outer task to be committed")
+		}
+
+		if exists(t, prefix+"_Tx_Inner") {
+			t.Errorf("expected inner task to be rolled back")
+		}
+	})
+}
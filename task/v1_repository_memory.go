@@ -0,0 +1,979 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// v1RepositoryMemory is a goroutine-safe TaskRepository that keeps its tasks in memory,
+// for tests and for running the server without disk state. It follows the semantics of v1RepositorySQL.
+type v1RepositoryMemory struct {
+	store *memoryStore
+}
+
+type memoryStore struct {
+	mu    sync.RWMutex
+	state *memoryState
+}
+
+// memoryState is the content of a v1RepositoryMemory. A unit of work changes a clone of it, which replaces
+// the original only when the unit of work succeeds.
+type memoryState struct {
+	tasks map[string]domain.TaskEntity
+
+	// names maps the name of every live task to its id, the names of trashed tasks may be taken again.
+	names map[string]string
+
+	history    []domain.TaskHistoryEntity
+	historySeq int64
+
+	idempotency map[string]domain.IdempotencyRecord
+
+	now func() time.Time
+}
+
+type ctxMemoryTx struct{}
+
+var (
+	ctxMemoryTxKey *ctxMemoryTx = &ctxMemoryTx{}
+)
+
+// memoryTx is the unit of work of a v1RepositoryMemory, shared through the context with the repository calls made in it.
+type memoryTx struct {
+	state *memoryState
+
+	// store is the content the unit of work was cloned from, a repository of another store does not join it.
+	store *memoryStore
+}
+
+func newMemoryState(now func() time.Time) *memoryState {
+	return &memoryState{
+		tasks:       make(map[string]domain.TaskEntity),
+		names:       make(map[string]string),
+		idempotency: make(map[string]domain.IdempotencyRecord),
+		now:         now,
+	}
+}
+
+func (v v1RepositoryMemory) Fetch(ctx context.Context, query domain.TaskQuery) (*domain.TaskEntityPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var cursor *domain.Cursor
+	if query.Page.Cursor != "" {
+		c, err := domain.DecodeCursor(query.Page.Cursor)
+		if err != nil {
+			l.Debug("invalid cursor", "error", err)
+			return nil, err
+		}
+		cursor = &c
+	}
+
+	// a backward page walks the keyset in reverse and is flipped back by domain.NewTaskEntityPage
+	field, desc := query.Sort.Field, query.Sort.Desc
+	if cursor != nil && cursor.Backward {
+		desc = !desc
+	}
+
+	var entities []*domain.TaskEntity
+	err := v.read(ctx, func(s *memoryState) error {
+		for _, e := range s.tasks {
+			e := e
+			if !v.matches(&e, query.Filter) {
+				continue
+			}
+
+			if cursor != nil {
+				c, err := v.compare(&e, field, cursor.Value, cursor.ID)
+				if err != nil {
+					return err
+				}
+
+				if (desc && c >= 0) || (!desc && c <= 0) {
+					continue
+				}
+			}
+
+			entities = append(entities, &e)
+		}
+
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to fetch tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch tasks", err)
+	}
+
+	v.sortEntities(entities, field, desc)
+	if len(entities) > query.Page.Limit+1 {
+		entities = entities[:query.Page.Limit+1]
+	}
+
+	return domain.NewTaskEntityPage(entities, query, cursor), nil
+}
+
+func (v v1RepositoryMemory) Search(ctx context.Context, query domain.TaskSearchQuery) (*domain.TaskSearchEntityPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var cursor *domain.Cursor
+	if query.Page.Cursor != "" {
+		c, err := domain.DecodeCursor(query.Page.Cursor)
+		if err != nil {
+			l.Debug("invalid cursor", "error", err)
+			return nil, err
+		}
+		cursor = &c
+	}
+
+	var cursorRank float64
+	if cursor != nil {
+		rank, err := strconv.ParseFloat(cursor.Value, 64)
+		if err != nil {
+			err := domain.NewFieldError("cursor", "is invalid")
+			l.Debug("invalid cursor", "error", err)
+			return nil, err
+		}
+		cursorRank = rank
+	}
+
+	terms := v.tokenize(query.Text)
+
+	var entities []*domain.TaskSearchEntity
+	err := v.read(ctx, func(s *memoryState) error {
+		for _, e := range s.tasks {
+			if !e.DeletedAt.IsZero() {
+				continue
+			}
+
+			rank, snippet, ok := v.match(e.Name, terms)
+			if !ok {
+				continue
+			}
+
+			if cursor != nil {
+				after := rank > cursorRank || (rank == cursorRank && e.ID > cursor.ID)
+				if after == cursor.Backward || (rank == cursorRank && e.ID == cursor.ID) {
+					continue
+				}
+			}
+
+			entities = append(entities, &domain.TaskSearchEntity{TaskEntity: e, Rank: rank, Score: -rank, Snippet: snippet})
+		}
+
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to search tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to search tasks", err)
+	}
+
+	backward := cursor != nil && cursor.Backward
+	sort.Slice(entities, func(i, j int) bool {
+		a, b := entities[i], entities[j]
+		less := a.Rank < b.Rank || (a.Rank == b.Rank && a.ID < b.ID)
+		return less != backward
+	})
+
+	if len(entities) > query.Page.Limit+1 {
+		entities = entities[:query.Page.Limit+1]
+	}
+
+	return domain.NewTaskSearchEntityPage(entities, query, cursor), nil
+}
+
+func (v v1RepositoryMemory) FetchByID(ctx context.Context, id string) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var entity *domain.TaskEntity
+	err := v.read(ctx, func(s *memoryState) (err error) {
+		entity, err = s.fetchLive(id)
+		return err
+	})
+	if err != nil {
+		l.Debug("failed to fetch task by id", "task_id", id, "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch task by id: %s", err, id)
+	}
+
+	return entity, nil
+}
+
+func (v v1RepositoryMemory) Store(ctx context.Context, entity domain.TaskEntity) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var stored *domain.TaskEntity
+	err := v.write(ctx, func(s *memoryState) (err error) {
+		if stored, err = s.create(entity.ID, entity.Name, true); err != nil {
+			return err
+		}
+
+		s.storeHistory(ctx, domain.TaskHistoryStore, nil, stored)
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to store task", "name", entity.Name, "error", err)
+		return nil, fmt.Errorf("%w: failed to store task: %s", err, entity.Name)
+	}
+
+	return stored, nil
+}
+
+func (v v1RepositoryMemory) Patch(ctx context.Context, entity domain.TaskPatchSpec) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	if entity.Name == nil && entity.IsActive == nil {
+		return nil, fmt.Errorf("%w: no fields to patch", domain.ErrValidation)
+	}
+
+	var patched *domain.TaskEntity
+	err := v.write(ctx, func(s *memoryState) error {
+		before, err := s.fetchLive(entity.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := v.checkVersion(before, entity.ExpectedVersion); err != nil {
+			return err
+		}
+
+		name, isActive := before.Name, before.IsActive
+		if entity.Name != nil {
+			name = *entity.Name
+		}
+		if entity.IsActive != nil {
+			isActive = *entity.IsActive
+		}
+
+		if patched, err = s.update(before, name, isActive); err != nil {
+			return err
+		}
+
+		s.storeHistory(ctx, domain.TaskHistoryPatch, before, patched)
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to patch task", "task_id", entity.ID, "error", err)
+		return nil, fmt.Errorf("%w: failed to patch task: %s", err, entity.ID)
+	}
+
+	return patched, nil
+}
+
+func (v v1RepositoryMemory) Replace(ctx context.Context, spec domain.TaskReplaceSpec) (*domain.TaskEntity, bool, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var (
+		replaced *domain.TaskEntity
+		created  bool
+	)
+	err := v.write(ctx, func(s *memoryState) error {
+		before, err := s.fetchLive(spec.ID)
+		if errors.Is(err, domain.ErrNotFound) {
+			// a trashed task keeps its id until it is purged, it is restored rather than replaced
+			if _, ok := s.tasks[spec.ID]; ok {
+				return fmt.Errorf("%w: task with id: %s is trashed", domain.ErrGone, spec.ID)
+			}
+
+			// a conditional replace only applies to an existing task
+			if spec.ExpectedVersion != nil {
+				return fmt.Errorf("%w: task with id: %s does not exist", domain.ErrPreconditionFailed, spec.ID)
+			}
+
+			if replaced, err = s.create(spec.ID, spec.Name, spec.IsActive); err != nil {
+				return err
+			}
+			created = true
+
+			s.storeHistory(ctx, domain.TaskHistoryStore, nil, replaced)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := v.checkVersion(before, spec.ExpectedVersion); err != nil {
+			return err
+		}
+
+		if replaced, err = s.update(before, spec.Name, spec.IsActive); err != nil {
+			return err
+		}
+
+		s.storeHistory(ctx, domain.TaskHistoryReplace, before, replaced)
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to replace task", "task_id", spec.ID, "error", err)
+		return nil, false, fmt.Errorf("%w: failed to replace task: %s", err, spec.ID)
+	}
+
+	return replaced, created, nil
+}
+
+func (v v1RepositoryMemory) DestroyByID(ctx context.Context, spec domain.TaskDestroySpec) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	err := v.write(ctx, func(s *memoryState) error {
+		before, err := s.fetchLive(spec.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := v.checkVersion(before, spec.ExpectedVersion); err != nil {
+			return err
+		}
+
+		destroyed := *before
+		destroyed.DeletedAt = s.timestamp()
+		destroyed.Version++
+		s.tasks[destroyed.ID] = destroyed
+		// the name of a trashed task is free to be taken
+		delete(s.names, destroyed.Name)
+
+		s.storeHistory(ctx, domain.TaskHistoryDestroy, before, &destroyed)
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to destroy task by id", "task_id", spec.ID, "error", err)
+		return fmt.Errorf("%w: failed to destroy task by id: %s", err, spec.ID)
+	}
+
+	return nil
+}
+
+func (v v1RepositoryMemory) Restore(ctx context.Context, id string) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var restored domain.TaskEntity
+	err := v.write(ctx, func(s *memoryState) error {
+		before, ok := s.tasks[id]
+		if !ok || before.DeletedAt.IsZero() {
+			return domain.ErrNotFound
+		}
+
+		if _, ok := s.names[before.Name]; ok {
+			return fmt.Errorf("%w: task with name: %s already exists", domain.ErrConflict, before.Name)
+		}
+
+		restored = before
+		restored.DeletedAt = time.Time{}
+		restored.Version++
+		s.tasks[id] = restored
+		s.names[restored.Name] = id
+
+		s.storeHistory(ctx, domain.TaskHistoryRestore, &before, &restored)
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to restore task by id", "task_id", id, "error", err)
+		return nil, fmt.Errorf("%w: failed to restore task by id: %s", err, id)
+	}
+
+	return &restored, nil
+}
+
+func (v v1RepositoryMemory) Purge(ctx context.Context, id string) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	err := v.write(ctx, func(s *memoryState) error {
+		e, ok := s.tasks[id]
+		if !ok || e.DeletedAt.IsZero() {
+			return fmt.Errorf("%w: trashed task with id: %s", domain.ErrNotFound, id)
+		}
+
+		s.delete(e)
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to purge task by id", "task_id", id, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (v v1RepositoryMemory) PurgeTrashed(ctx context.Context, before time.Time) (int64, error) {
+	// trash timestamps have second precision, like in v1RepositorySQL
+	before = before.UTC().Truncate(time.Second)
+
+	var n int64
+	_ = v.write(ctx, func(s *memoryState) error {
+		for _, e := range s.tasks {
+			if !e.DeletedAt.IsZero() && e.DeletedAt.Before(before) {
+				s.delete(e)
+				n++
+			}
+		}
+
+		return nil
+	})
+
+	return n, nil
+}
+
+func (v v1RepositoryMemory) FetchHistory(ctx context.Context, query domain.TaskHistoryQuery) (*domain.TaskHistoryEntityPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var (
+		cursor   *domain.Cursor
+		cursorID int64
+	)
+	if query.Page.Cursor != "" {
+		c, err := domain.DecodeCursor(query.Page.Cursor)
+		if err != nil {
+			l.Debug("invalid cursor", "error", err)
+			return nil, err
+		}
+		cursor = &c
+
+		if cursorID, err = strconv.ParseInt(c.ID, 10, 64); err != nil {
+			err := domain.NewFieldError("cursor", "is invalid")
+			l.Debug("invalid cursor", "error", err)
+			return nil, err
+		}
+	}
+
+	backward := cursor != nil && cursor.Backward
+
+	var entities []*domain.TaskHistoryEntity
+	err := v.read(ctx, func(s *memoryState) error {
+		for i := range s.history {
+			h := s.history[i]
+			if h.TaskID != query.TaskID {
+				continue
+			}
+
+			if cursor != nil && (h.ID == cursorID || (h.ID > cursorID) == backward) {
+				continue
+			}
+
+			entities = append(entities, &h)
+		}
+
+		if _, exists := s.tasks[query.TaskID]; len(entities) == 0 && cursor == nil && !exists {
+			return fmt.Errorf("%w: task with id: %s", domain.ErrNotFound, query.TaskID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to fetch history of task", "task_id", query.TaskID, "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch history of task: %s", err, query.TaskID)
+	}
+
+	// history is appended in id order, a backward page walks it in reverse
+	if backward {
+		for i, j := 0, len(entities)-1; i < j; i, j = i+1, j-1 {
+			entities[i], entities[j] = entities[j], entities[i]
+		}
+	}
+
+	if len(entities) > query.Page.Limit+1 {
+		entities = entities[:query.Page.Limit+1]
+	}
+
+	return domain.NewTaskHistoryEntityPage(entities, query, cursor), nil
+}
+
+func (v v1RepositoryMemory) Batch(ctx context.Context, spec domain.TaskBatchSpec) ([]*domain.TaskBatchEntityResult, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	results := make([]*domain.TaskBatchEntityResult, len(spec.Operations))
+
+	if spec.Mode == domain.TaskBatchBestEffort {
+		for i, op := range spec.Operations {
+			e, err := v.execBatchOperation(ctx, op)
+			results[i] = &domain.TaskBatchEntityResult{Entity: e, Err: err}
+		}
+
+		return results, nil
+	}
+
+	failed := -1
+	err := v.RunInTx(ctx, func(ctx context.Context, _ domain.TaskRepository) error {
+		for i, op := range spec.Operations {
+			e, err := v.execBatchOperation(ctx, op)
+			if err != nil {
+				failed = i
+				return err
+			}
+
+			results[i] = &domain.TaskBatchEntityResult{Entity: e}
+		}
+
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to run task batch", "failed", failed, "error", err)
+
+		// nothing of an aborted batch was kept, so every other operation is reported as aborted
+		for i := range spec.Operations {
+			results[i] = &domain.TaskBatchEntityResult{Err: fmt.Errorf("%w: operation %d failed", domain.ErrBatchAborted, failed)}
+			if i == failed {
+				results[i].Err = err
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// ReserveIdempotencyKey stores the pending record unless an unexpired one is stored under its key.
+// It returns the record stored under the key and whether it is the given one.
+func (v v1RepositoryMemory) ReserveIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) (*domain.IdempotencyRecord, bool, error) {
+	// idempotency timestamps have second precision, like in v1RepositorySQL
+	record.CreatedAt = record.CreatedAt.UTC().Truncate(time.Second)
+	record.ExpiresAt = record.ExpiresAt.UTC().Truncate(time.Second)
+
+	var (
+		stored   domain.IdempotencyRecord
+		reserved bool
+	)
+	_ = v.write(ctx, func(s *memoryState) error {
+		// an expired key is free again, even when it was not purged yet
+		if existing, ok := s.idempotency[record.Key]; ok && existing.ExpiresAt.After(record.CreatedAt) {
+			stored = existing
+			return nil
+		}
+
+		s.idempotency[record.Key] = record
+		stored, reserved = record, true
+		return nil
+	})
+
+	return &stored, reserved, nil
+}
+
+// CompleteIdempotencyKey stores the response of the record reserved under its key.
+func (v v1RepositoryMemory) CompleteIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	err := v.write(ctx, func(s *memoryState) error {
+		stored, ok := s.idempotency[record.Key]
+		if !ok || !stored.IsPending() {
+			return fmt.Errorf("%w: pending idempotency key: %s", domain.ErrNotFound, record.Key)
+		}
+
+		stored.Status, stored.Header, stored.Body = record.Status, record.Header, record.Body
+		s.idempotency[record.Key] = stored
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to complete idempotency key", "idempotency_key", record.Key, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes the pending record stored under key, a completed record is kept.
+func (v v1RepositoryMemory) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return v.write(ctx, func(s *memoryState) error {
+		if stored, ok := s.idempotency[key]; ok && stored.IsPending() {
+			delete(s.idempotency, key)
+		}
+
+		return nil
+	})
+}
+
+func (v v1RepositoryMemory) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	now = now.UTC().Truncate(time.Second)
+
+	var n int64
+	_ = v.write(ctx, func(s *memoryState) error {
+		for key, r := range s.idempotency {
+			if !r.ExpiresAt.After(now) {
+				delete(s.idempotency, key)
+				n++
+			}
+		}
+
+		return nil
+	})
+
+	return n, nil
+}
+
+// RunInTx runs fn on a clone of the repository content that replaces it when fn succeeds.
+// Units of work are serialized, and a nested one clones the content of the enclosing one.
+func (v v1RepositoryMemory) RunInTx(ctx context.Context, fn func(context.Context, domain.TaskRepository) error) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	run := func(state *memoryState) (*memoryState, error) {
+		tx := &memoryTx{state: state.clone(), store: v.store}
+		if err := fn(context.WithValue(ctx, ctxMemoryTxKey, tx), v); err != nil {
+			return nil, err
+		}
+
+		return tx.state, nil
+	}
+
+	var err error
+	if tx := v.txFromCtx(ctx); tx != nil {
+		var state *memoryState
+		if state, err = run(tx.state); err == nil {
+			tx.state = state
+		}
+	} else {
+		v.store.mu.Lock()
+		var state *memoryState
+		if state, err = run(v.store.state); err == nil {
+			v.store.state = state
+		}
+		v.store.mu.Unlock()
+	}
+	if err != nil {
+		l.Debug("failed to run in transaction", "error", err)
+		return fmt.Errorf("%w: failed to run in transaction", err)
+	}
+
+	return nil
+}
+
+// execBatchOperation runs a single operation of a task batch. The entity is nil for a delete.
+func (v v1RepositoryMemory) execBatchOperation(ctx context.Context, op domain.TaskBatchOperation) (*domain.TaskEntity, error) {
+	switch op.Kind {
+	case domain.TaskBatchCreate:
+		isActive := true
+		if op.IsActive != nil {
+			isActive = *op.IsActive
+		}
+
+		var stored *domain.TaskEntity
+		err := v.write(ctx, func(s *memoryState) (err error) {
+			if stored, err = s.create(op.ID, *op.Name, isActive); err != nil {
+				return err
+			}
+
+			s.storeHistory(ctx, domain.TaskHistoryStore, nil, stored)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to create task: %s", err, *op.Name)
+		}
+
+		return stored, nil
+	case domain.TaskBatchPatch:
+		return v.Patch(ctx, op.ToPatchSpec())
+	case domain.TaskBatchDelete:
+		return nil, v.DestroyByID(ctx, domain.TaskDestroySpec{ID: op.ID, ExpectedVersion: op.ExpectedVersion})
+	default:
+		return nil, fmt.Errorf("%w: unknown batch operation: %s", domain.ErrValidation, op.Kind)
+	}
+}
+
+// read runs fn on the content of the unit of work of ctx, or on the repository content under a read lock.
+func (v v1RepositoryMemory) read(ctx context.Context, fn func(*memoryState) error) error {
+	if tx := v.txFromCtx(ctx); tx != nil {
+		return fn(tx.state)
+	}
+
+	v.store.mu.RLock()
+	defer v.store.mu.RUnlock()
+	return fn(v.store.state)
+}
+
+// write runs fn on the content of the unit of work of ctx, or on the repository content under a write lock.
+// fn must fail before it changes anything, so that a failed call leaves no trace.
+func (v v1RepositoryMemory) write(ctx context.Context, fn func(*memoryState) error) error {
+	if tx := v.txFromCtx(ctx); tx != nil {
+		return fn(tx.state)
+	}
+
+	v.store.mu.Lock()
+	defer v.store.mu.Unlock()
+	return fn(v.store.state)
+}
+
+// txFromCtx returns the unit of work of ctx on the store of v, or nil.
+func (v v1RepositoryMemory) txFromCtx(ctx context.Context) *memoryTx {
+	if tx, ok := ctx.Value(ctxMemoryTxKey).(*memoryTx); ok && tx.store == v.store {
+		return tx
+	}
+
+	return nil
+}
+
+// checkVersion fails with domain.ErrPreconditionFailed when e does not meet the expected version.
+func (v v1RepositoryMemory) checkVersion(e *domain.TaskEntity, expectedVersion *domain.VersionMatch) error {
+	if !expectedVersion.Matches(e.Version) {
+		return fmt.Errorf("%w: task with id: %s does not match: %s", domain.ErrPreconditionFailed, e.ID, expectedVersion)
+	}
+
+	return nil
+}
+
+// matches reports whether e satisfies the conditions of f.
+// Name conditions are case-insensitive for ASCII letters, like the sqlite LIKE operator.
+func (v v1RepositoryMemory) matches(e *domain.TaskEntity, f domain.TaskFilter) bool {
+	if e.DeletedAt.IsZero() == f.Trashed {
+		return false
+	}
+
+	if f.IsActive != nil && e.IsActive != *f.IsActive {
+		return false
+	}
+
+	name := v.asciiLower(e.Name)
+	if f.NamePrefix != "" && !strings.HasPrefix(name, v.asciiLower(f.NamePrefix)) {
+		return false
+	}
+
+	if f.NameContains != "" && !strings.Contains(name, v.asciiLower(f.NameContains)) {
+		return false
+	}
+
+	for _, r := range []struct {
+		t     time.Time
+		bound time.Time
+		after bool
+	}{
+		{e.CreatedAt, f.CreatedAfter, true},
+		{e.CreatedAt, f.CreatedBefore, false},
+		{e.LastModifiedAt, f.LastModifiedAfter, true},
+		{e.LastModifiedAt, f.LastModifiedBefore, false},
+	} {
+		if r.bound.IsZero() {
+			continue
+		}
+
+		bound := r.bound.UTC().Truncate(time.Second)
+		if (r.after && r.t.Before(bound)) || (!r.after && r.t.After(bound)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compare compares the sort column and id of e with the keyset position of a cursor.
+func (v v1RepositoryMemory) compare(e *domain.TaskEntity, field domain.TaskSortField, value, id string) (int, error) {
+	var c int
+
+	switch field {
+	case domain.TaskSortByCreatedAt, domain.TaskSortByLastModifiedAt, domain.TaskSortByDeletedAt:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return 0, domain.NewFieldError("cursor", "is invalid")
+		}
+
+		et := e.CreatedAt
+		if field == domain.TaskSortByLastModifiedAt {
+			et = e.LastModifiedAt
+		} else if field == domain.TaskSortByDeletedAt {
+			et = e.DeletedAt
+		}
+
+		if et.Before(t) {
+			c = -1
+		} else if et.After(t) {
+			c = 1
+		}
+	case domain.TaskSortByIsActive:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return 0, domain.NewFieldError("cursor", "is invalid")
+		}
+
+		if e.IsActive != b {
+			c = -1
+			if e.IsActive {
+				c = 1
+			}
+		}
+	default:
+		c = strings.Compare(field.Value(e), value)
+	}
+
+	if c == 0 {
+		c = strings.Compare(e.ID, id)
+	}
+
+	return c, nil
+}
+
+// sortEntities orders entities by the sort column, then by id.
+func (v v1RepositoryMemory) sortEntities(entities []*domain.TaskEntity, field domain.TaskSortField, desc bool) {
+	sort.Slice(entities, func(i, j int) bool {
+		b := entities[j]
+		c, _ := v.compare(entities[i], field, field.Value(b), b.ID)
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// tokenize splits text into lower case words of letters and digits.
+func (v v1RepositoryMemory) tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// match reports whether name has every term as a word, the last term as a word prefix, like the
+// match expression of v1RepositorySQL. It returns a rank where lower is better and a snippet with the matched words marked.
+func (v v1RepositoryMemory) match(name string, terms []string) (float64, string, bool) {
+	words := v.tokenize(name)
+	if len(terms) == 0 || len(words) == 0 {
+		return 0, "", false
+	}
+
+	matched := make(map[string]bool)
+	for i, term := range terms {
+		found := false
+		for _, w := range words {
+			if w == term || (i == len(terms)-1 && strings.HasPrefix(w, term)) {
+				matched[w], found = true, true
+			}
+		}
+
+		if !found {
+			return 0, "", false
+		}
+	}
+
+	snippet := strings.FieldsFunc(name, unicode.IsSpace)
+	for i, s := range snippet {
+		if w := v.tokenize(s); len(w) == 1 && matched[w[0]] {
+			snippet[i] = "<mark>" + s + "</mark>"
+		}
+	}
+
+	return -float64(len(matched)) / float64(len(words)), strings.Join(snippet, " "), true
+}
+
+// asciiLower lower cases the ASCII letters of s only.
+func (v v1RepositoryMemory) asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+func (s *memoryState) clone() *memoryState {
+	c := &memoryState{
+		tasks:       make(map[string]domain.TaskEntity, len(s.tasks)),
+		names:       make(map[string]string, len(s.names)),
+		history:     s.history[:len(s.history):len(s.history)],
+		historySeq:  s.historySeq,
+		idempotency: make(map[string]domain.IdempotencyRecord, len(s.idempotency)),
+		now:         s.now,
+	}
+
+	for k, e := range s.tasks {
+		c.tasks[k] = e
+	}
+
+	for k, id := range s.names {
+		c.names[k] = id
+	}
+
+	for k, r := range s.idempotency {
+		c.idempotency[k] = r
+	}
+
+	return c
+}
+
+// timestamp returns the current time with the second precision v1RepositorySQL stores timestamps with.
+func (s *memoryState) timestamp() time.Time {
+	return s.now().UTC().Truncate(time.Second)
+}
+
+// fetchLive returns the live task with id.
+func (s *memoryState) fetchLive(id string) (*domain.TaskEntity, error) {
+	e, ok := s.tasks[id]
+	if !ok || !e.DeletedAt.IsZero() {
+		return nil, domain.ErrNotFound
+	}
+
+	return &e, nil
+}
+
+// create adds a task, failing with domain.ErrConflict when its id or name is taken.
+func (s *memoryState) create(id, name string, isActive bool) (*domain.TaskEntity, error) {
+	if _, ok := s.tasks[id]; ok {
+		return nil, fmt.Errorf("%w: task with id: %s already exists", domain.ErrConflict, id)
+	}
+
+	if _, ok := s.names[name]; ok {
+		return nil, fmt.Errorf("%w: task with name: %s already exists", domain.ErrConflict, name)
+	}
+
+	e := domain.TaskEntity{
+		ID:             id,
+		Name:           name,
+		CreatedAt:      s.timestamp(),
+		LastModifiedAt: time.Unix(0, 0).UTC(),
+		IsActive:       isActive,
+		Version:        1,
+	}
+
+	s.tasks[id] = e
+	s.names[name] = id
+
+	return &e, nil
+}
+
+// update sets the fields of the live task before, failing with domain.ErrConflict when the name is taken.
+func (s *memoryState) update(before *domain.TaskEntity, name string, isActive bool) (*domain.TaskEntity, error) {
+	if id, ok := s.names[name]; ok && id != before.ID {
+		return nil, fmt.Errorf("%w: task with name: %s already exists", domain.ErrConflict, name)
+	}
+
+	e := *before
+	e.Name = name
+	e.IsActive = isActive
+	e.LastModifiedAt = s.timestamp()
+	e.Version++
+
+	delete(s.names, before.Name)
+	s.names[name] = e.ID
+	s.tasks[e.ID] = e
+
+	return &e, nil
+}
+
+func (s *memoryState) delete(e domain.TaskEntity) {
+	delete(s.tasks, e.ID)
+
+	// the name of a trashed task may have been taken by another one since
+	if s.names[e.Name] == e.ID {
+		delete(s.names, e.Name)
+	}
+}
+
+// storeHistory records a change of a task.
+func (s *memoryState) storeHistory(ctx context.Context, op domain.TaskHistoryOperation, before, after *domain.TaskEntity) {
+	s.historySeq++
+
+	h := domain.TaskHistoryEntity{
+		ID:        s.historySeq,
+		TaskID:    after.ID,
+		Operation: op,
+		Actor:     domain.GetCtxActor(ctx),
+		RequestID: logutil.GetCtxID(ctx),
+		CreatedAt: s.timestamp(),
+	}
+
+	if before != nil {
+		b := *before
+		h.Before = &b
+	}
+
+	a := *after
+	h.After = &a
+
+	s.history = append(s.history, h)
+}
@@ -0,0 +1,107 @@
+package task
+
+import (
+	"context"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"time"
+)
+
+// v1RepositoryMetrics records the duration and the errors of every operation of the repository it wraps.
+type v1RepositoryMetrics struct {
+	repo    domain.TaskRepository
+	metrics *v1Metrics
+	now     func() time.Time
+}
+
+// observe records the operation started at start, which returned err.
+func (v v1RepositoryMetrics) observe(operation string, start time.Time, err error) {
+	v.metrics.observeOperation(operation, v.now().Sub(start), err)
+}
+
+func (v v1RepositoryMetrics) Fetch(ctx context.Context, query domain.TaskQuery) (_ *domain.TaskEntityPage, err error) {
+	defer func(start time.Time) { v.observe("fetch", start, err) }(v.now())
+	return v.repo.Fetch(ctx, query)
+}
+
+func (v v1RepositoryMetrics) Search(ctx context.Context, query domain.TaskSearchQuery) (_ *domain.TaskSearchEntityPage, err error) {
+	defer func(start time.Time) { v.observe("search", start, err) }(v.now())
+	return v.repo.Search(ctx, query)
+}
+
+func (v v1RepositoryMetrics) FetchByID(ctx context.Context, id string) (_ *domain.TaskEntity, err error) {
+	defer func(start time.Time) { v.observe("fetch_by_id", start, err) }(v.now())
+	return v.repo.FetchByID(ctx, id)
+}
+
+func (v v1RepositoryMetrics) Store(ctx context.Context, entity domain.TaskEntity) (_ *domain.TaskEntity, err error) {
+	defer func(start time.Time) { v.observe("store", start, err) }(v.now())
+	return v.repo.Store(ctx, entity)
+}
+
+func (v v1RepositoryMetrics) Patch(ctx context.Context, spec domain.TaskPatchSpec) (_ *domain.TaskEntity, err error) {
+	defer func(start time.Time) { v.observe("patch", start, err) }(v.now())
+	return v.repo.Patch(ctx, spec)
+}
+
+func (v v1RepositoryMetrics) Replace(ctx context.Context, spec domain.TaskReplaceSpec) (_ *domain.TaskEntity, _ bool, err error) {
+	defer func(start time.Time) { v.observe("replace", start, err) }(v.now())
+	return v.repo.Replace(ctx, spec)
+}
+
+func (v v1RepositoryMetrics) DestroyByID(ctx context.Context, spec domain.TaskDestroySpec) (err error) {
+	defer func(start time.Time) { v.observe("destroy_by_id", start, err) }(v.now())
+	return v.repo.DestroyByID(ctx, spec)
+}
+
+func (v v1RepositoryMetrics) Restore(ctx context.Context, id string) (_ *domain.TaskEntity, err error) {
+	defer func(start time.Time) { v.observe("restore", start, err) }(v.now())
+	return v.repo.Restore(ctx, id)
+}
+
+func (v v1RepositoryMetrics) Purge(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { v.observe("purge", start, err) }(v.now())
+	return v.repo.Purge(ctx, id)
+}
+
+func (v v1RepositoryMetrics) PurgeTrashed(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer func(start time.Time) { v.observe("purge_trashed", start, err) }(v.now())
+	return v.repo.PurgeTrashed(ctx, before)
+}
+
+func (v v1RepositoryMetrics) FetchHistory(ctx context.Context, query domain.TaskHistoryQuery) (_ *domain.TaskHistoryEntityPage, err error) {
+	defer func(start time.Time) { v.observe("fetch_history", start, err) }(v.now())
+	return v.repo.FetchHistory(ctx, query)
+}
+
+func (v v1RepositoryMetrics) Batch(ctx context.Context, spec domain.TaskBatchSpec) (_ []*domain.TaskBatchEntityResult, err error) {
+	defer func(start time.Time) { v.observe("batch", start, err) }(v.now())
+	return v.repo.Batch(ctx, spec)
+}
+
+func (v v1RepositoryMetrics) ReserveIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) (_ *domain.IdempotencyRecord, _ bool, err error) {
+	defer func(start time.Time) { v.observe("reserve_idempotency_key", start, err) }(v.now())
+	return v.repo.ReserveIdempotencyKey(ctx, record)
+}
+
+func (v v1RepositoryMetrics) CompleteIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) (err error) {
+	defer func(start time.Time) { v.observe("complete_idempotency_key", start, err) }(v.now())
+	return v.repo.CompleteIdempotencyKey(ctx, record)
+}
+
+func (v v1RepositoryMetrics) ReleaseIdempotencyKey(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { v.observe("release_idempotency_key", start, err) }(v.now())
+	return v.repo.ReleaseIdempotencyKey(ctx, key)
+}
+
+func (v v1RepositoryMetrics) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (_ int64, err error) {
+	defer func(start time.Time) { v.observe("purge_idempotency_keys", start, err) }(v.now())
+	return v.repo.PurgeIdempotencyKeys(ctx, now)
+}
+
+// RunInTx records the unit of work as a whole, and the operations fn runs through the repository it is given.
+func (v v1RepositoryMetrics) RunInTx(ctx context.Context, fn func(context.Context, domain.TaskRepository) error) (err error) {
+	defer func(start time.Time) { v.observe("run_in_tx", start, err) }(v.now())
+	return v.repo.RunInTx(ctx, func(ctx context.Context, repo domain.TaskRepository) error {
+		return fn(ctx, v1RepositoryMetrics{repo: repo, metrics: v.metrics, now: v.now})
+	})
+}
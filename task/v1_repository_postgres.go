@@ -0,0 +1,66 @@
+package task
+
+import (
+	"errors"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// queryPostgresSearch ranks by the negated ts_rank so that, like bm25 in sqlite, a lower rank is a better match.
+const queryPostgresSearch = `SELECT ` + querySQLColumns + `, -ts_rank(search, q, 1)::float8 AS rank, ts_headline('simple', name, q, 'StartSel=<mark>, StopSel=</mark>, MaxWords=16, MinWords=8') AS snippet
+FROM tasks, to_tsquery('simple', $1) AS q
+WHERE search @@ q AND deleted_at IS NULL`
+
+// postgresDialect is the sqlDialect of postgres.
+var postgresDialect = sqlDialect{
+	system:        "postgresql",
+	now:           `date_trunc('second', CURRENT_TIMESTAMP)`,
+	like:          "ILIKE",
+	querySearch:   queryPostgresSearch,
+	searchArg:     postgresTSQuery,
+	timeArg:       func(t time.Time) any { return t.UTC() },
+	isRetryable:   postgresIsRetryable,
+	classifyError: postgresClassifyError,
+}
+
+// postgresTSQuery quotes every word of text as a tsquery lexeme so user input cannot inject query syntax.
+// The words are AND-ed, and the last one matches as a prefix to support search-as-you-type.
+func postgresTSQuery(text string) string {
+	quote := strings.NewReplacer(`'`, `''`, `\`, `\\`)
+
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = `'` + quote.Replace(w) + `'`
+	}
+
+	return strings.Join(words, " & ") + ":*"
+}
+
+// postgresIsRetryable reports whether err is a serialization failure or a deadlock, which succeed when the transaction is run again.
+func postgresIsRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code.Name() == "serialization_failure" || pqErr.Code.Name() == "deadlock_detected")
+}
+
+// postgresClassifyError wraps a postgres error into its domain error kind so callers can branch on it.
+// Errors that do not map to a known kind are returned as is.
+func postgresClassifyError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code.Class() {
+	case "23": // integrity constraint violation
+		if pqErr.Code.Name() == "unique_violation" {
+			return domain.NewError(domain.ErrConflict, err)
+		}
+		return domain.NewError(domain.ErrValidation, err)
+	case "08", "40", "53", "57": // connection exception, transaction rollback, insufficient resources, operator intervention
+		return domain.NewError(domain.ErrUnavailable, err)
+	}
+
+	return err
+}
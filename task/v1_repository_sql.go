@@ -0,0 +1,1454 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// queryDefaultTimeout is the deadline of a query when none is set with WithQueryTimeout.
+	queryDefaultTimeout time.Duration = 10 * time.Second
+
+	// txMaxAttempts is how many times a transaction is run while it fails with a retryable error.
+	txMaxAttempts int = 5
+
+	// txRetryBackoff is the delay before the first retry of a transaction, it doubles on every retry.
+	txRetryBackoff time.Duration = 10 * time.Millisecond
+
+	// querySQLColumns are the task columns in the order scanned by scanEntity.
+	querySQLColumns = `id, name, created_at, last_modified_at, is_active, deleted_at, version`
+
+	querySQLFetchByID = `SELECT ` + querySQLColumns + `
+FROM tasks
+WHERE id = $1 AND deleted_at IS NULL
+LIMIT 1`
+
+	querySQLFetchTrashedByID = `SELECT ` + querySQLColumns + `
+FROM tasks
+WHERE id = $1 AND deleted_at IS NOT NULL
+LIMIT 1`
+
+	querySQLStore = `INSERT INTO tasks (id, name)
+VALUES ($1, $2)
+RETURNING ` + querySQLColumns
+
+	querySQLStoreReplacement = `INSERT INTO tasks (id, name, is_active)
+VALUES ($1, $2, $3)
+RETURNING ` + querySQLColumns
+
+	querySQLExists = `SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)`
+
+	querySQLRestore = `UPDATE tasks
+SET deleted_at = NULL, version = version + 1
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING ` + querySQLColumns
+
+	querySQLPurge = `DELETE FROM tasks WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	querySQLPurgeTrashed = `DELETE FROM tasks WHERE deleted_at IS NOT NULL AND deleted_at < $1`
+
+	querySQLStoreHistory = `INSERT INTO task_history (task_id, operation, before, after, actor, request_id)
+VALUES ($1, $2, $3, $4, $5, $6)`
+
+	querySQLFetchHistory = `SELECT id, task_id, operation, before, after, actor, request_id, created_at
+FROM task_history
+WHERE task_id = $1`
+
+	querySQLFetchIdempotencyKey = `SELECT key, fingerprint, status, header, body, created_at, expires_at
+FROM idempotency_keys
+WHERE key = $1`
+
+	querySQLReserveIdempotencyKey = `INSERT INTO idempotency_keys (key, fingerprint, created_at, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (key) DO NOTHING`
+
+	querySQLCompleteIdempotencyKey = `UPDATE idempotency_keys
+SET status = $1, header = $2, body = $3
+WHERE key = $4 AND status = 0`
+
+	querySQLReleaseIdempotencyKey = `DELETE FROM idempotency_keys WHERE key = $1 AND status = 0`
+
+	querySQLPurgeExpiredIdempotencyKey = `DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= $2`
+
+	querySQLPurgeIdempotencyKeys = `DELETE FROM idempotency_keys WHERE expires_at <= $1`
+)
+
+// errTaskModified is the error of a write whose task changed since it was read, see checkWrite.
+var errTaskModified = fmt.Errorf("%w: task was modified concurrently", domain.ErrConflict)
+
+// errSqlDatabaseClosed is the message database/sql returns once the *sql.DB is closed.
+// It is not exported as a sentinel, so it can only be matched by text.
+const errSqlDatabaseClosed = "sql: database is closed"
+
+// sqlDialect is the database specific part of v1RepositorySQL, see sqliteDialect and postgresDialect.
+type sqlDialect struct {
+	// system names the database in the spans of its queries.
+	system string
+
+	// now is the current time truncated to the second precision timestamps are stored in.
+	now string
+
+	// like is the operator matching a name against a pattern case-insensitively.
+	like string
+
+	// querySearch selects the querySQLColumns, rank and snippet of the live tasks matching the argument $1.
+	// A lower rank is a better match.
+	querySearch string
+
+	// searchArg converts the text of a search into the argument of querySearch.
+	searchArg func(text string) string
+
+	// timeArg converts t into the argument compared against a timestamp column.
+	timeArg func(t time.Time) any
+
+	// isRetryable reports whether a transaction that failed with err succeeds when it is run again.
+	isRetryable func(err error) bool
+
+	// classifyError wraps an error of the driver into its domain error kind, or returns it as is.
+	classifyError func(err error) error
+}
+
+// v1RepositorySQL is the domain.TaskRepository of a SQL database, whose differences are described by its dialect.
+// It does not rely on the database serializing writers: postgres runs its transactions at READ COMMITTED,
+// so every write of a task is conditional on the version it was read at, see checkWrite.
+type v1RepositorySQL struct {
+	db *sql.DB
+
+	// queryTimeout is the deadline of every query, see WithQueryTimeout.
+	queryTimeout time.Duration
+
+	tracer sqlTracer
+
+	dialect sqlDialect
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// querier is implemented by *sql.DB, *sql.Tx and tracedQuerier.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// sqlTracer starts a span for every query of a database system, with the sanitized query as an attribute.
+// Its zero value traces nothing.
+type sqlTracer struct {
+	tracer *traceutil.Tracer
+	system string
+}
+
+// tracedQuerier runs every query of a querier in a span.
+type tracedQuerier struct {
+	querier
+	sqlTracer
+}
+
+// tracedStmt is a prepared statement that runs in a span.
+type tracedStmt struct {
+	*sql.Stmt
+	sqlTracer
+	query string
+}
+
+type ctxSQLTx struct{}
+
+var (
+	ctxSQLTxKey *ctxSQLTx = &ctxSQLTx{}
+)
+
+// sqlTx is the transaction of a unit of work, shared through the context with the repository calls made in it.
+type sqlTx struct {
+	*sql.Tx
+
+	// db is the database of the transaction, a repository of another database does not join it.
+	db *sql.DB
+
+	// savepoints numbers the savepoints of nested units of work.
+	savepoints int
+}
+
+// sqlArgs collects the arguments of a constructed query.
+// sqlite numbers the $n parameters in the order they first appear, so the placeholders must appear in the order they
+// are added.
+type sqlArgs []any
+
+// batchStmts are the statements of a task batch, prepared once and bound to the transaction of each operation.
+type batchStmts struct {
+	fetchByID    tracedStmt
+	store        tracedStmt
+	patch        tracedStmt
+	destroy      tracedStmt
+	storeHistory tracedStmt
+}
+
+// taskSnapshot is the JSON representation of a task stored in task_history.
+type taskSnapshot struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastModifiedAt time.Time  `json:"last_modified_at"`
+	IsActive       bool       `json:"is_active"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	Version        int64      `json:"version"`
+}
+
+// queryReplace replaces the fields of a live task that is still at the version it was read at.
+func (d sqlDialect) queryReplace() string {
+	return `UPDATE tasks
+SET name = $1, is_active = $2, last_modified_at = ` + d.now + `, version = version + 1
+WHERE id = $3 AND deleted_at IS NULL AND version = $4
+RETURNING ` + querySQLColumns
+}
+
+// queryBatchPatch patches the fields of a live task that is still at the version it was read at,
+// a NULL argument keeps its field.
+func (d sqlDialect) queryBatchPatch() string {
+	return `UPDATE tasks
+SET name = COALESCE($1, name), is_active = COALESCE($2, is_active), last_modified_at = ` + d.now + `, version = version + 1
+WHERE id = $3 AND deleted_at IS NULL AND version = $4
+RETURNING ` + querySQLColumns
+}
+
+// queryDestroy moves a live task that is still at the version it was read at to the trash.
+func (d sqlDialect) queryDestroy() string {
+	return `UPDATE tasks
+SET deleted_at = ` + d.now + `, version = version + 1
+WHERE id = $1 AND deleted_at IS NULL AND version = $2
+RETURNING ` + querySQLColumns
+}
+
+func (v v1RepositorySQL) Fetch(ctx context.Context, query domain.TaskQuery) (*domain.TaskEntityPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	querySQLFetch, args, cursor, err := v.constructQueryFetch(query)
+	if err != nil {
+		l.Debug("failed to construct query", "error", err)
+		return nil, err
+	}
+
+	rows, err := v.conn(ctx).QueryContext(ctx, querySQLFetch, args...)
+	if err != nil {
+		l.Debug("failed to fetch tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch tasks", v.classifyError(err))
+	}
+	defer rows.Close()
+
+	entities := make([]*domain.TaskEntity, 0, query.Page.Limit+1)
+	for rows.Next() {
+		var e domain.TaskEntity
+		if err := v.scanEntity(rows, &e); err != nil {
+			l.Debug("failed to scan tasks", "error", err)
+			return nil, fmt.Errorf("%w: failed to scan tasks", v.classifyError(err))
+		}
+
+		entities = append(entities, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		l.Debug("failed to fetch tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch tasks", v.classifyError(err))
+	}
+
+	return domain.NewTaskEntityPage(entities, query, cursor), nil
+}
+
+func (v v1RepositorySQL) Search(ctx context.Context, query domain.TaskSearchQuery) (*domain.TaskSearchEntityPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	querySQLSearch, args, cursor, err := v.constructQuerySearch(query)
+	if err != nil {
+		l.Debug("failed to construct query", "error", err)
+		return nil, err
+	}
+
+	rows, err := v.conn(ctx).QueryContext(ctx, querySQLSearch, args...)
+	if err != nil {
+		l.Debug("failed to search tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to search tasks", v.classifyError(err))
+	}
+	defer rows.Close()
+
+	entities := make([]*domain.TaskSearchEntity, 0, query.Page.Limit+1)
+	for rows.Next() {
+		var e domain.TaskSearchEntity
+		if err := v.scanEntity(rows, &e.TaskEntity, &e.Rank, &e.Snippet); err != nil {
+			l.Debug("failed to scan tasks", "error", err)
+			return nil, fmt.Errorf("%w: failed to scan tasks", v.classifyError(err))
+		}
+
+		e.Score = -e.Rank
+		entities = append(entities, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		l.Debug("failed to search tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to search tasks", v.classifyError(err))
+	}
+
+	return domain.NewTaskSearchEntityPage(entities, query, cursor), nil
+}
+
+func (v v1RepositorySQL) FetchByID(ctx context.Context, id string) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	e, err := v.fetchEntity(ctx, v.conn(ctx), querySQLFetchByID, id)
+	if err != nil {
+		l.Debug("failed to fetch task by id", "task_id", id, "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch task by id: %s", v.classifyError(err), id)
+	}
+
+	return e, nil
+}
+
+func (v v1RepositorySQL) Store(ctx context.Context, entity domain.TaskEntity) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	var stored *domain.TaskEntity
+	err := v.inTx(ctx, func(tx querier) (err error) {
+		stored, err = v.fetchEntity(ctx, tx, querySQLStore, entity.ID, entity.Name)
+		if err != nil {
+			return err
+		}
+
+		return v.storeHistory(ctx, tx, domain.TaskHistoryStore, nil, stored)
+	})
+	if err != nil {
+		l.Debug("failed to store task", "name", entity.Name, "error", err)
+		return nil, fmt.Errorf("%w: failed to store task: %s", v.classifyError(err), entity.Name)
+	}
+
+	return stored, nil
+}
+
+func (v v1RepositorySQL) Patch(ctx context.Context, entity domain.TaskPatchSpec) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	if entity.Name == nil && entity.IsActive == nil {
+		return nil, fmt.Errorf("%w: no fields to patch", domain.ErrValidation)
+	}
+
+	var patched *domain.TaskEntity
+	err := v.inTx(ctx, func(tx querier) error {
+		before, err := v.fetchEntity(ctx, tx, querySQLFetchByID, entity.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := v.checkVersion(before, entity.ExpectedVersion); err != nil {
+			return err
+		}
+
+		querySQLPatch, args := v.constructQueryPatch(entity, before.Version)
+		l.Debug("constructed query", "query", querySQLPatch, "args", args)
+
+		patched, err = v.fetchEntity(ctx, tx, querySQLPatch, args...)
+		if err = v.checkWrite(before, entity.ExpectedVersion, err); err != nil {
+			return err
+		}
+
+		return v.storeHistory(ctx, tx, domain.TaskHistoryPatch, before, patched)
+	})
+	if err != nil {
+		l.Debug("failed to patch task", "task_id", entity.ID, "error", err)
+		return nil, fmt.Errorf("%w: failed to patch task: %s", v.classifyError(err), entity.ID)
+	}
+
+	return patched, nil
+}
+
+func (v v1RepositorySQL) Replace(ctx context.Context, spec domain.TaskReplaceSpec) (*domain.TaskEntity, bool, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	var (
+		replaced *domain.TaskEntity
+		created  bool
+	)
+	err := v.inTx(ctx, func(tx querier) error {
+		before, err := v.fetchEntity(ctx, tx, querySQLFetchByID, spec.ID)
+		if errors.Is(err, domain.ErrNotFound) {
+			// a trashed task keeps its id until it is purged, it is restored rather than replaced
+			if _, err := v.fetchEntity(ctx, tx, querySQLFetchTrashedByID, spec.ID); !errors.Is(err, domain.ErrNotFound) {
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("%w: task with id: %s is trashed", domain.ErrGone, spec.ID)
+			}
+
+			// a conditional replace only applies to an existing task
+			if spec.ExpectedVersion != nil {
+				return fmt.Errorf("%w: task with id: %s does not exist", domain.ErrPreconditionFailed, spec.ID)
+			}
+
+			if replaced, err = v.fetchEntity(ctx, tx, querySQLStoreReplacement, spec.ID, spec.Name, spec.IsActive); err != nil {
+				return err
+			}
+			created = true
+
+			return v.storeHistory(ctx, tx, domain.TaskHistoryStore, nil, replaced)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := v.checkVersion(before, spec.ExpectedVersion); err != nil {
+			return err
+		}
+
+		replaced, err = v.fetchEntity(ctx, tx, v.dialect.queryReplace(), spec.Name, spec.IsActive, spec.ID, before.Version)
+		if err = v.checkWrite(before, spec.ExpectedVersion, err); err != nil {
+			return err
+		}
+
+		return v.storeHistory(ctx, tx, domain.TaskHistoryReplace, before, replaced)
+	})
+	if err != nil {
+		l.Debug("failed to replace task", "task_id", spec.ID, "error", err)
+		return nil, false, fmt.Errorf("%w: failed to replace task: %s", v.classifyError(err), spec.ID)
+	}
+
+	return replaced, created, nil
+}
+
+func (v v1RepositorySQL) DestroyByID(ctx context.Context, spec domain.TaskDestroySpec) error {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	err := v.inTx(ctx, func(tx querier) error {
+		before, err := v.fetchEntity(ctx, tx, querySQLFetchByID, spec.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := v.checkVersion(before, spec.ExpectedVersion); err != nil {
+			return err
+		}
+
+		destroyed, err := v.fetchEntity(ctx, tx, v.dialect.queryDestroy(), spec.ID, before.Version)
+		if err = v.checkWrite(before, spec.ExpectedVersion, err); err != nil {
+			return err
+		}
+
+		return v.storeHistory(ctx, tx, domain.TaskHistoryDestroy, before, destroyed)
+	})
+	if err != nil {
+		l.Debug("failed to destroy task by id", "task_id", spec.ID, "error", err)
+		return fmt.Errorf("%w: failed to destroy task by id: %s", v.classifyError(err), spec.ID)
+	}
+
+	return nil
+}
+
+func (v v1RepositorySQL) Restore(ctx context.Context, id string) (*domain.TaskEntity, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	var restored *domain.TaskEntity
+	err := v.inTx(ctx, func(tx querier) error {
+		before, err := v.fetchEntity(ctx, tx, querySQLFetchTrashedByID, id)
+		if err != nil {
+			return err
+		}
+
+		if restored, err = v.fetchEntity(ctx, tx, querySQLRestore, id); err != nil {
+			return err
+		}
+
+		return v.storeHistory(ctx, tx, domain.TaskHistoryRestore, before, restored)
+	})
+	if err != nil {
+		l.Debug("failed to restore task by id", "task_id", id, "error", err)
+		return nil, fmt.Errorf("%w: failed to restore task by id: %s", v.classifyError(err), id)
+	}
+
+	return restored, nil
+}
+
+func (v v1RepositorySQL) Purge(ctx context.Context, id string) error {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	res, err := v.conn(ctx).ExecContext(ctx, querySQLPurge, id)
+	if err != nil {
+		l.Debug("failed to purge task by id", "task_id", id, "error", err)
+		return fmt.Errorf("%w: failed to purge task by id: %s", v.classifyError(err), id)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		l.Debug("failed to purge task by id", "task_id", id, "error", err)
+		return fmt.Errorf("%w: failed to purge task by id: %s", v.classifyError(err), id)
+	}
+
+	if rowsAffected == 0 {
+		err := fmt.Errorf("%w: trashed task with id: %s", domain.ErrNotFound, id)
+		l.Debug("trashed task not found", "task_id", id)
+		return err
+	}
+
+	return nil
+}
+
+func (v v1RepositorySQL) PurgeTrashed(ctx context.Context, before time.Time) (int64, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	res, err := v.conn(ctx).ExecContext(ctx, querySQLPurgeTrashed, v.dialect.timeArg(before))
+	if err != nil {
+		l.Debug("failed to purge tasks trashed before", "before", before, "error", err)
+		return 0, fmt.Errorf("%w: failed to purge tasks trashed before: %v", v.classifyError(err), before)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		l.Debug("failed to purge tasks trashed before", "before", before, "error", err)
+		return 0, fmt.Errorf("%w: failed to purge tasks trashed before: %v", v.classifyError(err), before)
+	}
+
+	return rowsAffected, nil
+}
+
+// Batch runs the operations of spec. The deadline of a single query is scaled by the number of operations, plus one
+// for preparing the statements, so a large batch is not cut short although none of its statements is slow.
+func (v v1RepositorySQL) Batch(ctx context.Context, spec domain.TaskBatchSpec) ([]*domain.TaskBatchEntityResult, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout*time.Duration(len(spec.Operations)+1))
+	defer cancel()
+
+	stmts, err := v.prepareBatch(ctx)
+	if err != nil {
+		l.Debug("failed to prepare task batch", "error", err)
+		return nil, fmt.Errorf("%w: failed to prepare task batch", v.classifyError(err))
+	}
+	defer stmts.Close(ctx)
+
+	results := make([]*domain.TaskBatchEntityResult, len(spec.Operations))
+
+	if spec.Mode == domain.TaskBatchBestEffort {
+		for i, op := range spec.Operations {
+			var e *domain.TaskEntity
+			err := v.withTx(ctx, func(ctx context.Context) (err error) {
+				e, err = v.execBatchOperation(ctx, stmts.in(ctx, v.txFromCtx(ctx).Tx), op)
+				return err
+			})
+			if err != nil {
+				l.Debug("failed to run task batch operation", "op", op.Kind, "task_id", op.ID, "error", err)
+				err = fmt.Errorf("%w: failed to %s task: %s", v.classifyError(err), op.Kind, op.ID)
+			}
+
+			results[i] = &domain.TaskBatchEntityResult{Entity: e, Err: err}
+		}
+
+		return results, nil
+	}
+
+	failed := -1
+	err = v.withTx(ctx, func(ctx context.Context) error {
+		txStmts := stmts.in(ctx, v.txFromCtx(ctx).Tx)
+		for i, op := range spec.Operations {
+			e, err := v.execBatchOperation(ctx, txStmts, op)
+			if err != nil {
+				failed = i
+				return err
+			}
+
+			results[i] = &domain.TaskBatchEntityResult{Entity: e}
+		}
+
+		return nil
+	})
+	if err != nil {
+		l.Debug("failed to run task batch", "failed", failed, "error", err)
+		if failed < 0 {
+			return nil, fmt.Errorf("%w: failed to commit task batch", v.classifyError(err))
+		}
+
+		// nothing of an aborted batch was persisted, so every other operation is reported as aborted
+		for i, op := range spec.Operations {
+			results[i] = &domain.TaskBatchEntityResult{Err: fmt.Errorf("%w: operation %d failed", domain.ErrBatchAborted, failed)}
+			if i == failed {
+				results[i].Err = fmt.Errorf("%w: failed to %s task: %s", v.classifyError(err), op.Kind, op.ID)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (v v1RepositorySQL) FetchHistory(ctx context.Context, query domain.TaskHistoryQuery) (*domain.TaskHistoryEntityPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	querySQLFetchHistory, args, cursor, err := v.constructQueryFetchHistory(query)
+	if err != nil {
+		l.Debug("failed to construct query", "error", err)
+		return nil, err
+	}
+
+	rows, err := v.conn(ctx).QueryContext(ctx, querySQLFetchHistory, args...)
+	if err != nil {
+		l.Debug("failed to fetch history of task", "task_id", query.TaskID, "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch history of task: %s", v.classifyError(err), query.TaskID)
+	}
+	defer rows.Close()
+
+	entities := make([]*domain.TaskHistoryEntity, 0, query.Page.Limit+1)
+	for rows.Next() {
+		e, err := v.scanHistory(rows)
+		if err != nil {
+			l.Debug("failed to scan history of task", "task_id", query.TaskID, "error", err)
+			return nil, fmt.Errorf("%w: failed to scan history of task: %s", v.classifyError(err), query.TaskID)
+		}
+
+		entities = append(entities, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		l.Debug("failed to fetch history of task", "task_id", query.TaskID, "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch history of task: %s", v.classifyError(err), query.TaskID)
+	}
+
+	if len(entities) == 0 && cursor == nil {
+		var exists bool
+		if err := v.conn(ctx).QueryRowContext(ctx, querySQLExists, query.TaskID).Scan(&exists); err != nil {
+			l.Debug("failed to fetch history of task", "task_id", query.TaskID, "error", err)
+			return nil, fmt.Errorf("%w: failed to fetch history of task: %s", v.classifyError(err), query.TaskID)
+		}
+
+		if !exists {
+			err := fmt.Errorf("%w: task with id: %s", domain.ErrNotFound, query.TaskID)
+			l.Debug("task not found", "task_id", query.TaskID)
+			return nil, err
+		}
+	}
+
+	return domain.NewTaskHistoryEntityPage(entities, query, cursor), nil
+}
+
+// ReserveIdempotencyKey stores the pending record unless an unexpired one is stored under its key.
+// It returns the record stored under the key and whether it is the given one.
+func (v v1RepositorySQL) ReserveIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) (*domain.IdempotencyRecord, bool, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	// idempotency timestamps are stored with second precision
+	createdAt, expiresAt := v.dialect.timeArg(record.CreatedAt.Truncate(time.Second)), v.dialect.timeArg(record.ExpiresAt.Truncate(time.Second))
+
+	var (
+		stored   *domain.IdempotencyRecord
+		reserved bool
+	)
+	err := v.inTx(ctx, func(tx querier) error {
+		// an expired key is free again, even when it was not purged yet
+		if _, err := tx.ExecContext(ctx, querySQLPurgeExpiredIdempotencyKey, record.Key, createdAt); err != nil {
+			return err
+		}
+
+		// a concurrent reservation of the key makes the insert wait for it and then do nothing
+		res, err := tx.ExecContext(ctx, querySQLReserveIdempotencyKey, record.Key, record.Fingerprint, createdAt, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		stored, err = v.scanIdempotencyRecord(tx.QueryRowContext(ctx, querySQLFetchIdempotencyKey, record.Key))
+		reserved = rowsAffected == 1
+		return err
+	})
+	if err != nil {
+		l.Debug("failed to reserve idempotency key", "idempotency_key", record.Key, "error", err)
+		return nil, false, fmt.Errorf("%w: failed to reserve idempotency key: %s", v.classifyError(err), record.Key)
+	}
+
+	return stored, reserved, nil
+}
+
+// CompleteIdempotencyKey stores the response of the record reserved under its key.
+func (v v1RepositorySQL) CompleteIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) error {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		l.Debug("failed to complete idempotency key", "idempotency_key", record.Key, "error", err)
+		return fmt.Errorf("%w: failed to complete idempotency key: %s", err, record.Key)
+	}
+
+	res, err := v.conn(ctx).ExecContext(ctx, querySQLCompleteIdempotencyKey, record.Status, string(header), record.Body, record.Key)
+	if err != nil {
+		l.Debug("failed to complete idempotency key", "idempotency_key", record.Key, "error", err)
+		return fmt.Errorf("%w: failed to complete idempotency key: %s", v.classifyError(err), record.Key)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		l.Debug("failed to complete idempotency key", "idempotency_key", record.Key, "error", err)
+		return fmt.Errorf("%w: failed to complete idempotency key: %s", v.classifyError(err), record.Key)
+	}
+
+	if rowsAffected == 0 {
+		err := fmt.Errorf("%w: pending idempotency key: %s", domain.ErrNotFound, record.Key)
+		l.Debug("pending idempotency key not found", "idempotency_key", record.Key)
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes the pending record stored under key, a completed record is kept.
+func (v v1RepositorySQL) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	if _, err := v.conn(ctx).ExecContext(ctx, querySQLReleaseIdempotencyKey, key); err != nil {
+		l.Debug("failed to release idempotency key", "idempotency_key", key, "error", err)
+		return fmt.Errorf("%w: failed to release idempotency key: %s", v.classifyError(err), key)
+	}
+
+	return nil
+}
+
+func (v v1RepositorySQL) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	l := logutil.GetCtxLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, v.queryTimeout)
+	defer cancel()
+
+	res, err := v.conn(ctx).ExecContext(ctx, querySQLPurgeIdempotencyKeys, v.dialect.timeArg(now.Truncate(time.Second)))
+	if err != nil {
+		l.Debug("failed to purge expired idempotency keys", "now", now, "error", err)
+		return 0, fmt.Errorf("%w: failed to purge idempotency keys expired at: %v", v.classifyError(err), now)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		l.Debug("failed to purge expired idempotency keys", "now", now, "error", err)
+		return 0, fmt.Errorf("%w: failed to purge idempotency keys expired at: %v", v.classifyError(err), now)
+	}
+
+	return rowsAffected, nil
+}
+
+func (v v1RepositorySQL) RunInTx(ctx context.Context, fn func(context.Context, domain.TaskRepository) error) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	err := v.withTx(ctx, func(ctx context.Context) error {
+		return fn(ctx, v)
+	})
+	if err != nil {
+		l.Debug("failed to run in transaction", "error", err)
+		return fmt.Errorf("%w: failed to run in transaction", v.classifyError(err))
+	}
+
+	return nil
+}
+
+// inTx runs fn in the transaction of ctx, see withTx.
+func (v v1RepositorySQL) inTx(ctx context.Context, fn func(querier) error) error {
+	return v.withTx(ctx, func(ctx context.Context) error {
+		return fn(v.conn(ctx))
+	})
+}
+
+// withTx runs fn with a transaction in its context, which is committed when fn succeeds and rolled back otherwise.
+// When ctx already carries a transaction, fn runs in a savepoint of it instead, so only the changes of fn are rolled back.
+// A new transaction is retried while it fails with errTaskModified or an error the dialect deems retryable,
+// so fn may run more than once.
+func (v v1RepositorySQL) withTx(ctx context.Context, fn func(context.Context) error) error {
+	if tx := v.txFromCtx(ctx); tx != nil {
+		return v.withSavepoint(ctx, tx, fn)
+	}
+
+	backoff := txRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := v.beginTx(ctx, fn)
+		if err == nil || !(errors.Is(err, errTaskModified) || v.dialect.isRetryable(err)) || attempt == txMaxAttempts {
+			return err
+		}
+
+		logutil.GetCtxLogger(ctx).Warn("retrying transaction", "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (v v1RepositorySQL) beginTx(ctx context.Context, fn func(context.Context) error) error {
+	tx, err := v.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(context.WithValue(ctx, ctxSQLTxKey, &sqlTx{Tx: tx, db: v.db})); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			logutil.GetCtxLogger(ctx).Warn("failed to roll back transaction", "error", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withSavepoint runs fn in a savepoint of tx. Rolling back to the savepoint also clears the aborted state
+// a failed statement leaves a postgres transaction in, so the enclosing unit of work can carry on.
+func (v v1RepositorySQL) withSavepoint(ctx context.Context, tx *sqlTx, fn func(context.Context) error) error {
+	tx.savepoints++
+	savepoint := fmt.Sprintf("sp_%d", tx.savepoints)
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+
+	if err := fn(ctx); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); rbErr != nil {
+			logutil.GetCtxLogger(ctx).Warn("failed to roll back savepoint", "savepoint", savepoint, "error", rbErr)
+		}
+		if _, rbErr := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint); rbErr != nil {
+			logutil.GetCtxLogger(ctx).Warn("failed to release savepoint", "savepoint", savepoint, "error", rbErr)
+		}
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+	return err
+}
+
+// txFromCtx returns the transaction of ctx on the database of v, or nil.
+func (v v1RepositorySQL) txFromCtx(ctx context.Context) *sqlTx {
+	if tx, ok := ctx.Value(ctxSQLTxKey).(*sqlTx); ok && tx.db == v.db {
+		return tx
+	}
+
+	return nil
+}
+
+// conn returns the transaction of ctx, or the database when ctx carries none.
+func (v v1RepositorySQL) conn(ctx context.Context) querier {
+	if tx := v.txFromCtx(ctx); tx != nil {
+		return v.tracer.querier(tx.Tx)
+	}
+
+	return v.tracer.querier(v.db)
+}
+
+// fetchEntity runs a query selecting the querySQLColumns of a single task.
+// A query matching no task is reported as domain.ErrNotFound.
+func (v v1RepositorySQL) fetchEntity(ctx context.Context, q querier, query string, args ...any) (*domain.TaskEntity, error) {
+	var e domain.TaskEntity
+	if err := v.scanEntity(q.QueryRowContext(ctx, query, args...), &e); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// prepareBatch prepares the statements of a task batch.
+func (v v1RepositorySQL) prepareBatch(ctx context.Context) (*batchStmts, error) {
+	var stmts batchStmts
+
+	for _, p := range []struct {
+		stmt  *tracedStmt
+		query string
+	}{
+		{&stmts.fetchByID, querySQLFetchByID},
+		{&stmts.store, querySQLStoreReplacement},
+		{&stmts.patch, v.dialect.queryBatchPatch()},
+		{&stmts.destroy, v.dialect.queryDestroy()},
+		{&stmts.storeHistory, querySQLStoreHistory},
+	} {
+		stmt, err := v.conn(ctx).PrepareContext(ctx, p.query)
+		if err != nil {
+			stmts.Close(ctx)
+			return nil, err
+		}
+		*p.stmt = tracedStmt{Stmt: stmt, sqlTracer: v.tracer, query: p.query}
+	}
+
+	return &stmts, nil
+}
+
+// in returns the statements bound to tx.
+func (s *batchStmts) in(ctx context.Context, tx *sql.Tx) *batchStmts {
+	return &batchStmts{
+		fetchByID:    s.fetchByID.in(ctx, tx),
+		store:        s.store.in(ctx, tx),
+		patch:        s.patch.in(ctx, tx),
+		destroy:      s.destroy.in(ctx, tx),
+		storeHistory: s.storeHistory.in(ctx, tx),
+	}
+}
+
+// Close closes the prepared statements.
+func (s *batchStmts) Close(ctx context.Context) {
+	for _, stmt := range []*sql.Stmt{s.fetchByID.Stmt, s.store.Stmt, s.patch.Stmt, s.destroy.Stmt, s.storeHistory.Stmt} {
+		if stmt == nil {
+			continue
+		}
+
+		if err := stmt.Close(); err != nil {
+			logutil.GetCtxLogger(ctx).Warn("failed to close statement", "error", err)
+		}
+	}
+}
+
+// querier returns q running its queries in spans, or q itself when nothing is traced.
+func (t sqlTracer) querier(q querier) querier {
+	if t.tracer == nil {
+		return q
+	}
+
+	return tracedQuerier{querier: q, sqlTracer: t}
+}
+
+// start starts the span of query, named after its operation such as SELECT.
+func (t sqlTracer) start(ctx context.Context, query string) (context.Context, *traceutil.Span) {
+	statement := traceutil.SanitizeSQL(query)
+	operation, _, _ := strings.Cut(statement, " ")
+	operation = strings.ToUpper(operation)
+
+	return t.tracer.Start(ctx, operation, traceutil.SpanKindClient,
+		traceutil.String("db.system", t.system),
+		traceutil.String("db.operation", operation),
+		traceutil.String("db.statement", statement),
+	)
+}
+
+// end ends span, a query matching no row did not fail.
+func (t sqlTracer) end(span *traceutil.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func (q tracedQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := q.start(ctx, query)
+	res, err := q.querier.ExecContext(ctx, query, args...)
+	q.end(span, err)
+
+	return res, err
+}
+
+func (q tracedQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := q.start(ctx, query)
+	rows, err := q.querier.QueryContext(ctx, query, args...)
+	q.end(span, err)
+
+	return rows, err
+}
+
+func (q tracedQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := q.start(ctx, query)
+	row := q.querier.QueryRowContext(ctx, query, args...)
+	q.end(span, row.Err())
+
+	return row
+}
+
+func (q tracedQuerier) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := q.start(ctx, query)
+	stmt, err := q.querier.PrepareContext(ctx, query)
+	q.end(span, err)
+
+	return stmt, err
+}
+
+// in returns the statement bound to tx.
+func (s tracedStmt) in(ctx context.Context, tx *sql.Tx) tracedStmt {
+	s.Stmt = tx.StmtContext(ctx, s.Stmt)
+	return s
+}
+
+func (s tracedStmt) ExecContext(ctx context.Context, args ...any) (sql.Result, error) {
+	if s.tracer == nil {
+		return s.Stmt.ExecContext(ctx, args...)
+	}
+
+	ctx, span := s.start(ctx, s.query)
+	res, err := s.Stmt.ExecContext(ctx, args...)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s tracedStmt) QueryRowContext(ctx context.Context, args ...any) *sql.Row {
+	if s.tracer == nil {
+		return s.Stmt.QueryRowContext(ctx, args...)
+	}
+
+	ctx, span := s.start(ctx, s.query)
+	row := s.Stmt.QueryRowContext(ctx, args...)
+	s.end(span, row.Err())
+
+	return row
+}
+
+// execBatchOperation runs a single operation of a task batch with statements bound to its transaction.
+// The entity is nil for a delete.
+func (v v1RepositorySQL) execBatchOperation(ctx context.Context, stmts *batchStmts, op domain.TaskBatchOperation) (*domain.TaskEntity, error) {
+	row := func(stmt tracedStmt, args ...any) (*domain.TaskEntity, error) {
+		var e domain.TaskEntity
+		if err := v.scanEntity(stmt.QueryRowContext(ctx, args...), &e); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, domain.ErrNotFound
+			}
+			return nil, err
+		}
+
+		return &e, nil
+	}
+
+	var (
+		before, after *domain.TaskEntity
+		history       domain.TaskHistoryOperation
+		err           error
+	)
+
+	switch op.Kind {
+	case domain.TaskBatchCreate:
+		isActive := true
+		if op.IsActive != nil {
+			isActive = *op.IsActive
+		}
+
+		history = domain.TaskHistoryStore
+		if after, err = row(stmts.store, op.ID, *op.Name, isActive); err != nil {
+			return nil, err
+		}
+	case domain.TaskBatchPatch, domain.TaskBatchDelete:
+		if before, err = row(stmts.fetchByID, op.ID); err != nil {
+			return nil, err
+		}
+
+		if err := v.checkVersion(before, op.ExpectedVersion); err != nil {
+			return nil, err
+		}
+
+		if op.Kind == domain.TaskBatchPatch {
+			history = domain.TaskHistoryPatch
+			after, err = row(stmts.patch, op.Name, op.IsActive, op.ID, before.Version)
+		} else {
+			history = domain.TaskHistoryDestroy
+			after, err = row(stmts.destroy, op.ID, before.Version)
+		}
+		if err = v.checkWrite(before, op.ExpectedVersion, err); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown batch operation: %s", domain.ErrValidation, op.Kind)
+	}
+
+	args, err := v.historyArgs(ctx, history, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := stmts.storeHistory.ExecContext(ctx, args...); err != nil {
+		return nil, err
+	}
+
+	if op.Kind == domain.TaskBatchDelete {
+		return nil, nil
+	}
+
+	return after, nil
+}
+
+// checkVersion fails with domain.ErrPreconditionFailed when e does not meet the expected version.
+func (v v1RepositorySQL) checkVersion(e *domain.TaskEntity, expectedVersion *domain.VersionMatch) error {
+	if !expectedVersion.Matches(e.Version) {
+		return fmt.Errorf("%w: task with id: %s does not match: %s", domain.ErrPreconditionFailed, e.ID, expectedVersion)
+	}
+
+	return nil
+}
+
+// checkWrite converts the error of a write of a task read as before. A write matching no row means the task changed
+// since it was read: a conditional write fails with domain.ErrPreconditionFailed, any other write with
+// errTaskModified so that its transaction runs again from the current task.
+func (v v1RepositorySQL) checkWrite(before *domain.TaskEntity, expectedVersion *domain.VersionMatch, err error) error {
+	if !errors.Is(err, domain.ErrNotFound) {
+		return err
+	}
+
+	if expectedVersion != nil {
+		return fmt.Errorf("%w: task with id: %s changed since version: %d", domain.ErrPreconditionFailed, before.ID, before.Version)
+	}
+
+	return fmt.Errorf("%w: task with id: %s changed since version: %d", errTaskModified, before.ID, before.Version)
+}
+
+// storeHistory records a change of a task in the same transaction as the change.
+func (v v1RepositorySQL) storeHistory(ctx context.Context, tx querier, op domain.TaskHistoryOperation, before, after *domain.TaskEntity) error {
+	args, err := v.historyArgs(ctx, op, before, after)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, querySQLStoreHistory, args...)
+	return err
+}
+
+// historyArgs returns the arguments of querySQLStoreHistory recording a change of a task.
+func (v v1RepositorySQL) historyArgs(ctx context.Context, op domain.TaskHistoryOperation, before, after *domain.TaskEntity) ([]any, error) {
+	var beforeJSON sql.NullString
+	if before != nil {
+		b, err := json.Marshal(newTaskSnapshot(before))
+		if err != nil {
+			return nil, err
+		}
+		beforeJSON = sql.NullString{String: string(b), Valid: true}
+	}
+
+	afterJSON, err := json.Marshal(newTaskSnapshot(after))
+	if err != nil {
+		return nil, err
+	}
+
+	return []any{after.ID, op, beforeJSON, string(afterJSON), domain.GetCtxActor(ctx), logutil.GetCtxID(ctx)}, nil
+}
+
+// constructQueryFetch builds a parameterized keyset query over (sort column, id)
+// that selects one row more than the page limit.
+func (v v1RepositorySQL) constructQueryFetch(query domain.TaskQuery) (string, []any, *domain.Cursor, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args sqlArgs
+
+	f := query.Filter
+	if f.Trashed {
+		conditions[0] = "deleted_at IS NOT NULL"
+	}
+
+	if f.IsActive != nil {
+		conditions = append(conditions, "is_active = "+args.add(*f.IsActive))
+	}
+
+	if f.NamePrefix != "" {
+		conditions = append(conditions, "name "+v.dialect.like+" "+args.add(v.escapeLike(f.NamePrefix)+"%")+` ESCAPE '\'`)
+	}
+
+	if f.NameContains != "" {
+		conditions = append(conditions, "name "+v.dialect.like+" "+args.add("%"+v.escapeLike(f.NameContains)+"%")+` ESCAPE '\'`)
+	}
+
+	for _, r := range []struct {
+		cond string
+		t    time.Time
+	}{
+		{"created_at >= ", f.CreatedAfter},
+		{"created_at <= ", f.CreatedBefore},
+		{"last_modified_at >= ", f.LastModifiedAfter},
+		{"last_modified_at <= ", f.LastModifiedBefore},
+	} {
+		if !r.t.IsZero() {
+			conditions = append(conditions, r.cond+args.add(v.dialect.timeArg(r.t)))
+		}
+	}
+
+	// a backward page walks the keyset in reverse and is flipped back by domain.NewTaskEntityPage
+	column, desc := string(query.Sort.Field), query.Sort.Desc
+
+	var cursor *domain.Cursor
+	if query.Page.Cursor != "" {
+		c, err := domain.DecodeCursor(query.Page.Cursor)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		cursor = &c
+
+		value, err := v.sortArg(query.Sort.Field, c.Value)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		if c.Backward {
+			desc = !desc
+		}
+
+		op := ">"
+		if desc {
+			op = "<"
+		}
+
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, op, args.add(value), args.add(c.ID)))
+	}
+
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+
+	baseQuery := fmt.Sprintf("SELECT %s\nFROM tasks\nWHERE %s", querySQLColumns, strings.Join(conditions, " AND "))
+
+	q := fmt.Sprintf("%s\nORDER BY %s %s, id %s\nLIMIT %s", baseQuery, column, order, order, args.add(query.Page.Limit+1))
+	return q, args, cursor, nil
+}
+
+// constructQuerySearch builds a keyset query over (rank, id) that selects one row more than the page limit.
+func (v v1RepositorySQL) constructQuerySearch(query domain.TaskSearchQuery) (string, []any, *domain.Cursor, error) {
+	args := sqlArgs{v.dialect.searchArg(query.Text)}
+	baseQuery := `SELECT ` + querySQLColumns + `, rank, snippet
+FROM (` + v.dialect.querySearch + `) s`
+	order := "ASC"
+
+	var cursor *domain.Cursor
+	if query.Page.Cursor != "" {
+		c, err := domain.DecodeCursor(query.Page.Cursor)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		cursor = &c
+
+		rank, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return "", nil, nil, domain.NewFieldError("cursor", "is invalid")
+		}
+
+		op := ">"
+		if c.Backward {
+			op, order = "<", "DESC"
+		}
+
+		baseQuery = fmt.Sprintf("%s\nWHERE (rank, id) %s (%s, %s)", baseQuery, op, args.add(rank), args.add(c.ID))
+	}
+
+	q := fmt.Sprintf("%s\nORDER BY rank %s, id %s\nLIMIT %s", baseQuery, order, order, args.add(query.Page.Limit+1))
+	return q, args, cursor, nil
+}
+
+// constructQueryFetchHistory builds a keyset query over the history id that selects one row more than the page limit.
+func (v v1RepositorySQL) constructQueryFetchHistory(query domain.TaskHistoryQuery) (string, []any, *domain.Cursor, error) {
+	args := sqlArgs{query.TaskID}
+	baseQuery := querySQLFetchHistory
+	order := "ASC"
+
+	var cursor *domain.Cursor
+	if query.Page.Cursor != "" {
+		c, err := domain.DecodeCursor(query.Page.Cursor)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		cursor = &c
+
+		id, err := strconv.ParseInt(c.ID, 10, 64)
+		if err != nil {
+			return "", nil, nil, domain.NewFieldError("cursor", "is invalid")
+		}
+
+		op := ">"
+		if c.Backward {
+			op, order = "<", "DESC"
+		}
+
+		baseQuery = fmt.Sprintf("%s AND id %s %s", baseQuery, op, args.add(id))
+	}
+
+	q := fmt.Sprintf("%s\nORDER BY id %s\nLIMIT %s", baseQuery, order, args.add(query.Page.Limit+1))
+	return q, args, cursor, nil
+}
+
+// sortArg converts a cursor value back to the argument compared against the sort column.
+func (v v1RepositorySQL) sortArg(field domain.TaskSortField, value string) (any, error) {
+	switch field {
+	case domain.TaskSortByCreatedAt, domain.TaskSortByLastModifiedAt, domain.TaskSortByDeletedAt:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, domain.NewFieldError("cursor", "is invalid")
+		}
+		return v.dialect.timeArg(t), nil
+	case domain.TaskSortByIsActive:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, domain.NewFieldError("cursor", "is invalid")
+		}
+		return b, nil
+	default:
+		return value, nil
+	}
+}
+
+// add appends arg and returns its $n placeholder.
+func (a *sqlArgs) add(arg any) string {
+	*a = append(*a, arg)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func (v v1RepositorySQL) escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// constructQueryPatch builds the update of the patched fields of a task that is still at version.
+func (v v1RepositorySQL) constructQueryPatch(entity domain.TaskPatchSpec, version int64) (string, []any) {
+	var args sqlArgs
+	baseQuery := `UPDATE tasks
+SET last_modified_at = ` + v.dialect.now
+
+	if entity.Name != nil {
+		baseQuery = fmt.Sprintf("%s, name = %s", baseQuery, args.add(*entity.Name))
+	}
+
+	if entity.IsActive != nil {
+		baseQuery = fmt.Sprintf("%s, is_active = %s", baseQuery, args.add(*entity.IsActive))
+	}
+
+	baseQuery = fmt.Sprintf("%s, version = version + 1 WHERE id = %s AND deleted_at IS NULL AND version = %s", baseQuery, args.add(entity.ID), args.add(version))
+
+	return fmt.Sprintf("%s RETURNING %s", baseQuery, querySQLColumns), args
+}
+
+// scanEntity scans the querySQLColumns of a row into e, followed by the extra destinations.
+// Postgres returns timestamps in the session time zone, so every timestamp is converted to UTC.
+func (v v1RepositorySQL) scanEntity(s scanner, e *domain.TaskEntity, extra ...any) error {
+	var deletedAt sql.NullTime
+	dest := append([]any{&e.ID, &e.Name, &e.CreatedAt, &e.LastModifiedAt, &e.IsActive, &deletedAt, &e.Version}, extra...)
+	if err := s.Scan(dest...); err != nil {
+		return err
+	}
+
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.LastModifiedAt = e.LastModifiedAt.UTC()
+	if deletedAt.Valid {
+		e.DeletedAt = deletedAt.Time.UTC()
+	}
+	return nil
+}
+
+// scanHistory scans a row of querySQLFetchHistory.
+func (v v1RepositorySQL) scanHistory(s scanner) (*domain.TaskHistoryEntity, error) {
+	var (
+		e      domain.TaskHistoryEntity
+		before sql.NullString
+		after  string
+	)
+
+	if err := s.Scan(&e.ID, &e.TaskID, &e.Operation, &before, &after, &e.Actor, &e.RequestID, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+
+	if before.Valid {
+		var snapshot taskSnapshot
+		if err := json.Unmarshal([]byte(before.String), &snapshot); err != nil {
+			return nil, err
+		}
+		e.Before = snapshot.toEntity()
+	}
+
+	var snapshot taskSnapshot
+	if err := json.Unmarshal([]byte(after), &snapshot); err != nil {
+		return nil, err
+	}
+	e.After = snapshot.toEntity()
+
+	return &e, nil
+}
+
+// scanIdempotencyRecord scans a row of querySQLFetchIdempotencyKey.
+func (v v1RepositorySQL) scanIdempotencyRecord(s scanner) (*domain.IdempotencyRecord, error) {
+	var (
+		r      domain.IdempotencyRecord
+		header sql.NullString
+	)
+
+	if err := s.Scan(&r.Key, &r.Fingerprint, &r.Status, &header, &r.Body, &r.CreatedAt, &r.ExpiresAt); err != nil {
+		return nil, err
+	}
+	r.CreatedAt, r.ExpiresAt = r.CreatedAt.UTC(), r.ExpiresAt.UTC()
+
+	if header.Valid {
+		if err := json.Unmarshal([]byte(header.String), &r.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
+func newTaskSnapshot(e *domain.TaskEntity) taskSnapshot {
+	s := taskSnapshot{
+		ID:             e.ID,
+		Name:           e.Name,
+		CreatedAt:      e.CreatedAt,
+		LastModifiedAt: e.LastModifiedAt,
+		IsActive:       e.IsActive,
+		Version:        e.Version,
+	}
+
+	if !e.DeletedAt.IsZero() {
+		s.DeletedAt = &e.DeletedAt
+	}
+
+	return s
+}
+
+func (s taskSnapshot) toEntity() *domain.TaskEntity {
+	e := &domain.TaskEntity{
+		ID:             s.ID,
+		Name:           s.Name,
+		CreatedAt:      s.CreatedAt,
+		LastModifiedAt: s.LastModifiedAt,
+		IsActive:       s.IsActive,
+		Version:        s.Version,
+	}
+
+	if s.DeletedAt != nil {
+		e.DeletedAt = *s.DeletedAt
+	}
+
+	return e
+}
+
+// classifyError wraps an error into its domain error kind so callers can branch on it.
+// Errors that do not map to a known kind are returned as is.
+func (v v1RepositorySQL) classifyError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, driver.ErrBadConn) || err.Error() == errSqlDatabaseClosed {
+		return domain.NewError(domain.ErrUnavailable, err)
+	}
+
+	return v.dialect.classifyError(err)
+}
@@ -1,191 +1,82 @@
 package task
 
 import (
-	"context"
-	"database/sql"
 	"errors"
-	"fmt"
 	"github.com/anon-org/developing-api-services-with-golang/domain"
-	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"github.com/mattn/go-sqlite3"
+	"strings"
 	"time"
 )
 
 const (
-	queryDefaultTimeout time.Duration = 10 * time.Second
-
-	querySqliteFetch = `SELECT *
-FROM tasks
-ORDER BY created_at ASC`
-
-	querySqliteFetchByID = `SELECT *
-FROM tasks
-WHERE id = $1
-LIMIT 1`
-
-	querySqliteStore = `INSERT INTO tasks (id, name)
-VALUES ($1, $2)
-RETURNING *`
-
-	querySqliteDestroy = `DELETE FROM tasks WHERE id = $1`
+	sqliteTimeLayout string = "2006-01-02 15:04:05"
+
+	querySqliteSearch = `SELECT t.id, t.name, t.created_at, t.last_modified_at, t.is_active, t.deleted_at, t.version, m.rank, m.snippet
+FROM (
+	SELECT id, bm25(tasks_fts) AS rank, snippet(tasks_fts, 1, '<mark>', '</mark>', '…', 16) AS snippet
+	FROM tasks_fts
+	WHERE tasks_fts MATCH $1
+) m
+JOIN tasks t ON t.id = m.id
+WHERE t.deleted_at IS NULL`
 )
 
-type v1RepositorySqlite struct {
-	db *sql.DB
+// sqliteDialect is the sqlDialect of sqlite, whose LIKE is case-insensitive for ASCII already.
+var sqliteDialect = sqlDialect{
+	system:        "sqlite",
+	now:           "CURRENT_TIMESTAMP",
+	like:          "LIKE",
+	querySearch:   querySqliteSearch,
+	searchArg:     sqliteMatchExpression,
+	timeArg:       sqliteTimeArg,
+	isRetryable:   sqliteIsBusy,
+	classifyError: sqliteClassifyError,
 }
 
-func (v v1RepositorySqlite) Fetch(ctx context.Context) ([]*domain.TaskEntity, error) {
-	l := logutil.GetCtxLogger(ctx)
-	ctx, cancel := context.WithTimeout(ctx, queryDefaultTimeout)
-	defer cancel()
-
-	rows, err := v.db.QueryContext(ctx, querySqliteFetch)
-	if err != nil {
-		l.Println(err)
-		return nil, fmt.Errorf("%w: failed to fetch tasks", err)
+// sqliteMatchExpression quotes every word of text as an FTS5 string so user input cannot inject query syntax.
+// The words are implicitly AND-ed, and the last one matches as a prefix to support search-as-you-type.
+func sqliteMatchExpression(text string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
 	}
-	defer rows.Close()
 
-	entities := make([]*domain.TaskEntity, 0)
-	for rows.Next() {
-		var e domain.TaskEntity
-		if err := rows.Scan(&e.ID, &e.Name, &e.CreatedAt, &e.LastModifiedAt, &e.IsActive); err != nil {
-			l.Println(err)
-			return nil, fmt.Errorf("%w: failed to scan tasks", err)
-		}
-
-		entities = append(entities, &e)
-	}
-
-	return entities, nil
+	return strings.Join(words, " ") + "*"
 }
 
-func (v v1RepositorySqlite) FetchByID(ctx context.Context, id string) (*domain.TaskEntity, error) {
-	l := logutil.GetCtxLogger(ctx)
-	ctx, cancel := context.WithTimeout(ctx, queryDefaultTimeout)
-	defer cancel()
-
-	rows, err := v.db.QueryContext(ctx, querySqliteFetchByID, id)
-	if err != nil {
-		l.Println(err)
-		return nil, fmt.Errorf("%w: failed to fetch task by id: %s", err, id)
-	}
-	defer rows.Close()
-
-	if !rows.Next() {
-		err := fmt.Errorf("task with id: %s not found", id)
-		l.Println(err)
-		return nil, err
-	}
-
-	var e domain.TaskEntity
-	if err := rows.Scan(&e.ID, &e.Name, &e.CreatedAt, &e.LastModifiedAt, &e.IsActive); err != nil {
-		l.Println(err)
-		return nil, fmt.Errorf("%w: failed to scan task with id: %s", err, id)
+// sqliteTimeArg converts t to the representation sqlite stores timestamps in.
+// The zero last_modified_at is stored as the integer 0, so the unix epoch is bound as 0 to compare equal.
+func sqliteTimeArg(t time.Time) any {
+	if t.Unix() == 0 {
+		return 0
 	}
 
-	return &e, nil
+	return t.UTC().Format(sqliteTimeLayout)
 }
 
-func (v v1RepositorySqlite) Store(ctx context.Context, entity domain.TaskEntity) (*domain.TaskEntity, error) {
-	l := logutil.GetCtxLogger(ctx)
-	ctx, cancel := context.WithTimeout(ctx, queryDefaultTimeout)
-	defer cancel()
-
-	rows, err := v.db.QueryContext(ctx, querySqliteStore, entity.ID, entity.Name)
-	if err != nil {
-		l.Println(err)
-		return nil, fmt.Errorf("%w: failed to store task: %s", err, entity.Name)
-	}
-	defer rows.Close()
-
-	if !rows.Next() {
-		err := fmt.Errorf("failed to store task: %s", entity.Name)
-		l.Println(err)
-		return nil, err
-	}
-
-	if err := rows.Scan(&entity.ID, &entity.Name, &entity.CreatedAt, &entity.LastModifiedAt, &entity.IsActive); err != nil {
-		l.Println(err)
-		return nil, fmt.Errorf("%w: failed to scan task: %v", err, entity)
-	}
-
-	return &entity, nil
+// sqliteIsBusy reports whether err is caused by another connection holding a lock on the database.
+func sqliteIsBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
 }
 
-func (v v1RepositorySqlite) Patch(ctx context.Context, entity domain.TaskPatchSpec) (*domain.TaskEntity, error) {
-	l := logutil.GetCtxLogger(ctx)
-	ctx, cancel := context.WithTimeout(ctx, queryDefaultTimeout)
-	defer cancel()
-
-	querySqlitePatch, args := v.constructQuerySqlitePatch(entity)
-	if len(args) <= 1 {
-		return nil, errors.New("no fields to patch")
-	} else {
-		l.Println("constructed query:", querySqlitePatch, "with args:", args)
-	}
-
-	rows, err := v.db.QueryContext(ctx, querySqlitePatch, args...)
-	if err != nil {
-		l.Println(err)
-		return nil, fmt.Errorf("%w: failed to patch task: %s", err, entity.ID)
-	}
-	defer rows.Close()
-
-	if !rows.Next() {
-		err := fmt.Errorf("failed to patch task: %s", entity.ID)
-		l.Println(err)
-		return nil, err
-	}
-
-	var e domain.TaskEntity
-	if err := rows.Scan(&e.ID, &e.Name, &e.CreatedAt, &e.LastModifiedAt, &e.IsActive); err != nil {
-		l.Println(err)
-		return nil, fmt.Errorf("%w: failed to scan task: %v", err, e)
-	}
-
-	return &e, nil
-}
-
-func (v v1RepositorySqlite) DestroyByID(ctx context.Context, id string) error {
-	l := logutil.GetCtxLogger(ctx)
-	ctx, cancel := context.WithTimeout(ctx, queryDefaultTimeout)
-	defer cancel()
-
-	res, err := v.db.ExecContext(ctx, querySqliteDestroy, id)
-	if err != nil {
-		l.Println(err)
-		return fmt.Errorf("%w: failed to destroy task by id: %s", err, id)
-	}
-
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		l.Println(err)
-		return fmt.Errorf("%w: failed to destroy task by id: %s", err, id)
-	}
-
-	if rowsAffected == 0 {
-		err := fmt.Errorf("task with id: %s not found", id)
-		l.Println(err)
+// sqliteClassifyError wraps a sqlite error into its domain error kind so callers can branch on it.
+// Errors that do not map to a known kind are returned as is.
+func sqliteClassifyError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
 		return err
 	}
 
-	return nil
-}
-
-func (v v1RepositorySqlite) constructQuerySqlitePatch(entity domain.TaskPatchSpec) (string, []any) {
-	args := make([]any, 0)
-	baseQuery := `UPDATE tasks
-SET last_modified_at = CURRENT_TIMESTAMP`
-
-	if entity.Name != nil {
-		baseQuery = fmt.Sprintf("%s, name = ?", baseQuery)
-		args = append(args, *entity.Name)
-	}
-
-	if entity.IsActive != nil {
-		baseQuery = fmt.Sprintf("%s, is_active = ?", baseQuery)
-		args = append(args, *entity.IsActive)
+	switch sqliteErr.Code {
+	case sqlite3.ErrConstraint:
+		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return domain.NewError(domain.ErrConflict, err)
+		}
+		return domain.NewError(domain.ErrValidation, err)
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrCantOpen, sqlite3.ErrIoErr, sqlite3.ErrFull:
+		return domain.NewError(domain.ErrUnavailable, err)
 	}
 
-	return fmt.Sprintf("%s WHERE id = ? RETURNING *", baseQuery), append(args, entity.ID)
+	return err
 }
@@ -2,36 +2,61 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/anon-org/developing-api-services-with-golang/domain"
-	"github.com/anon-org/developing-api-services-with-golang/util/idutil"
 	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"time"
 )
 
 const (
-	defaultIdLength = 24
+	// idempotencyDefaultTTL is how long idempotency keys are kept when no TTL is set with WithIdempotencyTTL.
+	idempotencyDefaultTTL time.Duration = 24 * time.Hour
 )
 
 type v1Service struct {
 	repo domain.TaskRepository
+	now  func() time.Time
+	ids  domain.IDGenerator
+
+	// idempotencyTTL is how long the response stored under an idempotency key is replayed, see WithIdempotencyTTL.
+	idempotencyTTL time.Duration
 }
 
-func (v v1Service) Fetch(ctx context.Context) ([]*domain.Task, error) {
+func (v v1Service) Fetch(ctx context.Context, query domain.TaskQuery) (*domain.TaskPage, error) {
 	l := logutil.GetCtxLogger(ctx)
 
-	entities, err := v.repo.Fetch(ctx)
+	query, err := query.Normalize()
 	if err != nil {
-		l.Println(err)
+		l.Debug("failed to fetch tasks", "error", err)
 		return nil, fmt.Errorf("%w: failed to fetch tasks", err)
 	}
 
-	n := len(entities)
-	tasks := make([]*domain.Task, n)
-	for i, entity := range entities {
-		tasks[i] = entity.ToSpec()
+	entities, err := v.repo.Fetch(ctx, query)
+	if err != nil {
+		l.Debug("failed to fetch tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch tasks", err)
 	}
 
-	return tasks, nil
+	return entities.ToSpec(), nil
+}
+
+func (v v1Service) Search(ctx context.Context, query domain.TaskSearchQuery) (*domain.TaskSearchPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	query, err := query.Normalize()
+	if err != nil {
+		l.Debug("failed to search tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to search tasks", err)
+	}
+
+	entities, err := v.repo.Search(ctx, query)
+	if err != nil {
+		l.Debug("failed to search tasks", "error", err)
+		return nil, fmt.Errorf("%w: failed to search tasks", err)
+	}
+
+	return entities.ToSpec(), nil
 }
 
 func (v v1Service) FetchByID(ctx context.Context, id string) (*domain.Task, error) {
@@ -39,7 +64,7 @@ func (v v1Service) FetchByID(ctx context.Context, id string) (*domain.Task, erro
 
 	entity, err := v.repo.FetchByID(ctx, id)
 	if err != nil {
-		l.Println(err)
+		l.Debug("failed to fetch task by id", "task_id", id, "error", err)
 		return nil, fmt.Errorf("%w: failed to fetch task by id: %s", err, id)
 	}
 
@@ -49,14 +74,25 @@ func (v v1Service) FetchByID(ctx context.Context, id string) (*domain.Task, erro
 func (v v1Service) Store(ctx context.Context, name string) (*domain.Task, error) {
 	l := logutil.GetCtxLogger(ctx)
 
+	if err := (domain.TaskStoreRequest{Name: name}).Validate(); err != nil {
+		l.Debug("failed to store task", "error", err)
+		return nil, fmt.Errorf("%w: failed to store task", err)
+	}
+
+	id, err := v.ids.Generate()
+	if err != nil {
+		l.Debug("failed to generate task id", "error", err)
+		return nil, fmt.Errorf("%w: failed to generate task id", err)
+	}
+
 	e := domain.TaskEntity{
-		ID:   idutil.MustGenerateID(defaultIdLength),
+		ID:   id,
 		Name: name,
 	}
 
 	stored, err := v.repo.Store(ctx, e)
 	if err != nil {
-		l.Println(err)
+		l.Debug("failed to store task", "name", name, "error", err)
 		return nil, fmt.Errorf("%w: failed to store task: %s", err, name)
 	}
 
@@ -66,23 +102,294 @@ func (v v1Service) Store(ctx context.Context, name string) (*domain.Task, error)
 func (v v1Service) Patch(ctx context.Context, spec domain.TaskPatchSpec) (*domain.Task, error) {
 	l := logutil.GetCtxLogger(ctx)
 
+	if err := spec.Validate(); err != nil {
+		l.Debug("failed to patch task", "task_id", spec.ID, "error", err)
+		return nil, fmt.Errorf("%w: failed to patch task: %s", err, spec.ID)
+	}
+
 	patched, err := v.repo.Patch(ctx, spec)
 	if err != nil {
-		l.Println(err)
+		l.Debug("failed to patch task", "task_id", spec.ID, "error", err)
 		return nil, fmt.Errorf("%w: failed to patch task: %s", err, spec.ID)
 	}
 
 	return patched.ToSpec(), nil
 }
 
-func (v v1Service) DestroyByID(ctx context.Context, id string) error {
+// PatchDocument applies a merge patch or JSON patch document to the current task and persists the result.
+// The patch only applies to the version the document was applied to, so a concurrent change fails it
+// rather than being overwritten with a result computed from the old task.
+func (v v1Service) PatchDocument(ctx context.Context, spec domain.TaskDocumentPatchSpec) (*domain.Task, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	var patched *domain.TaskEntity
+	err := v.repo.RunInTx(ctx, func(ctx context.Context, repo domain.TaskRepository) error {
+		current, err := repo.FetchByID(ctx, spec.ID)
+		if err != nil {
+			return err
+		}
+
+		if !spec.ExpectedVersion.Matches(current.Version) {
+			return fmt.Errorf("%w: task with id: %s does not match: %s", domain.ErrPreconditionFailed, spec.ID, spec.ExpectedVersion)
+		}
+
+		patch, err := current.ToSpec().ApplyPatchDocument(spec.Format, spec.Document)
+		if err != nil {
+			return err
+		}
+
+		if patch.Name == nil && patch.IsActive == nil {
+			patched = current
+			return nil
+		}
+
+		if err := patch.Validate(); err != nil {
+			return err
+		}
+
+		patch.ExpectedVersion = domain.MatchVersion(&current.Version)
+		patched, err = repo.Patch(ctx, patch)
+		if errors.Is(err, domain.ErrPreconditionFailed) && spec.ExpectedVersion == nil {
+			// the client set no precondition, the task changed under the patch
+			return fmt.Errorf("%w: task with id: %s changed while it was patched", domain.ErrConflict, spec.ID)
+		}
+		return err
+	})
+	if err != nil {
+		l.Debug("failed to patch task", "task_id", spec.ID, "error", err)
+		return nil, fmt.Errorf("%w: failed to patch task: %s", err, spec.ID)
+	}
+
+	return patched.ToSpec(), nil
+}
+
+// Replace replaces the task at spec.ID, creating it when it does not exist. It reports whether the task was created.
+func (v v1Service) Replace(ctx context.Context, spec domain.TaskReplaceSpec) (*domain.Task, bool, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	if err := spec.Validate(); err != nil {
+		l.Debug("failed to replace task", "task_id", spec.ID, "error", err)
+		return nil, false, fmt.Errorf("%w: failed to replace task: %s", err, spec.ID)
+	}
+
+	replaced, created, err := v.repo.Replace(ctx, spec)
+	if err != nil {
+		l.Debug("failed to replace task", "task_id", spec.ID, "error", err)
+		return nil, false, fmt.Errorf("%w: failed to replace task: %s", err, spec.ID)
+	}
+
+	return replaced.ToSpec(), created, nil
+}
+
+func (v v1Service) DestroyByID(ctx context.Context, spec domain.TaskDestroySpec) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	err := v.repo.DestroyByID(ctx, spec)
+	if err != nil {
+		l.Debug("failed to destroy task by id", "task_id", spec.ID, "error", err)
+		return fmt.Errorf("%w: failed to destroy task by id: %s", err, spec.ID)
+	}
+
+	return nil
+}
+
+func (v v1Service) FetchTrash(ctx context.Context, query domain.TaskQuery) (*domain.TaskPage, error) {
+	query.Filter.Trashed = true
+	if query.Sort.Field == "" {
+		query.Sort = domain.TaskSort{Field: domain.TaskSortByDeletedAt, Desc: true}
+	}
+
+	return v.Fetch(ctx, query)
+}
+
+func (v v1Service) Restore(ctx context.Context, id string) (*domain.Task, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	restored, err := v.repo.Restore(ctx, id)
+	if err != nil {
+		l.Debug("failed to restore task by id", "task_id", id, "error", err)
+		return nil, fmt.Errorf("%w: failed to restore task by id: %s", err, id)
+	}
+
+	return restored.ToSpec(), nil
+}
+
+func (v v1Service) Purge(ctx context.Context, id string) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	if err := v.repo.Purge(ctx, id); err != nil {
+		l.Debug("failed to purge task by id", "task_id", id, "error", err)
+		return fmt.Errorf("%w: failed to purge task by id: %s", err, id)
+	}
+
+	return nil
+}
+
+func (v v1Service) PurgeTrashed(ctx context.Context, retention time.Duration) (int64, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	n, err := v.repo.PurgeTrashed(ctx, v.now().Add(-retention))
+	if err != nil {
+		l.Debug("failed to purge tasks trashed for longer than", "retention", retention, "error", err)
+		return 0, fmt.Errorf("%w: failed to purge tasks trashed for longer than: %v", err, retention)
+	}
+
+	return n, nil
+}
+
+func (v v1Service) FetchHistory(ctx context.Context, query domain.TaskHistoryQuery) (*domain.TaskHistoryPage, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	query, err := query.Normalize()
+	if err != nil {
+		l.Debug("failed to fetch task history", "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch task history", err)
+	}
+
+	entities, err := v.repo.FetchHistory(ctx, query)
+	if err != nil {
+		l.Debug("failed to fetch task history", "error", err)
+		return nil, fmt.Errorf("%w: failed to fetch task history", err)
+	}
+
+	return entities.ToSpec(), nil
+}
+
+// Batch runs the operations of a batch, the results are in the order of the operations.
+// An invalid operation fails on its own in a best-effort batch and aborts an all-or-nothing batch without touching the repository.
+func (v v1Service) Batch(ctx context.Context, spec domain.TaskBatchSpec) ([]*domain.TaskBatchResult, error) {
 	l := logutil.GetCtxLogger(ctx)
 
-	err := v.repo.DestroyByID(ctx, id)
+	if err := spec.Validate(); err != nil {
+		l.Debug("failed to run task batch", "error", err)
+		return nil, fmt.Errorf("%w: failed to run task batch", err)
+	}
+
+	results := make([]*domain.TaskBatchResult, len(spec.Operations))
+	valid := domain.TaskBatchSpec{Mode: spec.Mode}
+	indexes := make([]int, 0, len(spec.Operations))
+
+	invalid := -1
+	for i, op := range spec.Operations {
+		if err := op.Validate(); err != nil {
+			results[i] = &domain.TaskBatchResult{Kind: op.Kind, Err: err}
+			if invalid < 0 {
+				invalid = i
+			}
+			continue
+		}
+
+		if op.Kind == domain.TaskBatchCreate {
+			id, err := v.ids.Generate()
+			if err != nil {
+				l.Debug("failed to generate task id", "error", err)
+				return nil, fmt.Errorf("%w: failed to generate task id", err)
+			}
+			op.ID = id
+		}
+
+		valid.Operations = append(valid.Operations, op)
+		indexes = append(indexes, i)
+	}
+
+	if spec.Mode == domain.TaskBatchAllOrNothing && invalid >= 0 {
+		for i, op := range spec.Operations {
+			if results[i] == nil {
+				results[i] = &domain.TaskBatchResult{Kind: op.Kind, Err: fmt.Errorf("%w: operation %d is invalid", domain.ErrBatchAborted, invalid)}
+			}
+		}
+
+		return results, nil
+	}
+
+	if len(valid.Operations) == 0 {
+		return results, nil
+	}
+
+	entities, err := v.repo.Batch(ctx, valid)
 	if err != nil {
-		l.Println(err)
-		return fmt.Errorf("%w: failed to destroy task by id: %s", err, id)
+		l.Debug("failed to run task batch", "error", err)
+		return nil, fmt.Errorf("%w: failed to run task batch", err)
+	}
+
+	for j, e := range entities {
+		results[indexes[j]] = e.ToSpec(valid.Operations[j].Kind)
+	}
+
+	return results, nil
+}
+
+// ReserveIdempotencyKey reserves the key of spec for the request it identifies.
+// It returns nil when the key is newly reserved, and the stored record to replay when the request was already answered.
+func (v v1Service) ReserveIdempotencyKey(ctx context.Context, spec domain.IdempotencySpec) (*domain.IdempotencyRecord, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	if err := spec.Validate(); err != nil {
+		l.Debug("failed to reserve idempotency key", "error", err)
+		return nil, fmt.Errorf("%w: failed to reserve idempotency key", err)
+	}
+
+	now := v.now()
+	record := domain.IdempotencyRecord{
+		Key:         spec.Key,
+		Fingerprint: spec.Fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(v.idempotencyTTL),
+	}
+
+	stored, reserved, err := v.repo.ReserveIdempotencyKey(ctx, record)
+	if err != nil {
+		l.Debug("failed to reserve idempotency key", "idempotency_key", spec.Key, "error", err)
+		return nil, fmt.Errorf("%w: failed to reserve idempotency key: %s", err, spec.Key)
+	}
+
+	switch {
+	case reserved:
+		return nil, nil
+	case stored.Fingerprint != spec.Fingerprint:
+		err := fmt.Errorf("%w: %s", domain.ErrIdempotencyKeyReused, spec.Key)
+		l.Debug("idempotency key reused", "idempotency_key", spec.Key)
+		return nil, err
+	case stored.IsPending():
+		err := fmt.Errorf("%w: a request with idempotency key: %s is in progress", domain.ErrConflict, spec.Key)
+		l.Debug("idempotency key in progress", "idempotency_key", spec.Key)
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (v v1Service) CompleteIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	if err := v.repo.CompleteIdempotencyKey(ctx, record); err != nil {
+		l.Debug("failed to complete idempotency key", "idempotency_key", record.Key, "error", err)
+		return fmt.Errorf("%w: failed to complete idempotency key: %s", err, record.Key)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey drops the reservation of key, so that the request can be retried.
+func (v v1Service) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	l := logutil.GetCtxLogger(ctx)
+
+	if err := v.repo.ReleaseIdempotencyKey(ctx, key); err != nil {
+		l.Debug("failed to release idempotency key", "idempotency_key", key, "error", err)
+		return fmt.Errorf("%w: failed to release idempotency key: %s", err, key)
 	}
 
 	return nil
 }
+
+func (v v1Service) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	l := logutil.GetCtxLogger(ctx)
+
+	n, err := v.repo.PurgeIdempotencyKeys(ctx, v.now())
+	if err != nil {
+		l.Debug("failed to purge expired idempotency keys", "error", err)
+		return 0, fmt.Errorf("%w: failed to purge expired idempotency keys", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,159 @@
+package task
+
+import (
+	"context"
+	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+	"time"
+)
+
+// v1ServiceTracing runs every call of the service it wraps in a span.
+type v1ServiceTracing struct {
+	svc    domain.TaskService
+	tracer *traceutil.Tracer
+}
+
+// start starts the span of the method of the service.
+func (v v1ServiceTracing) start(ctx context.Context, method string, attrs ...traceutil.Attribute) (context.Context, *traceutil.Span) {
+	return v.tracer.Start(ctx, "v1Service."+method, traceutil.SpanKindInternal, attrs...)
+}
+
+// end ends span, recording err with its domain kind.
+func (v v1ServiceTracing) end(span *traceutil.Span, err error) {
+	if err != nil {
+		span.SetAttributes(traceutil.String("error.type", errorKind(err)))
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func (v v1ServiceTracing) Fetch(ctx context.Context, query domain.TaskQuery) (_ *domain.TaskPage, err error) {
+	ctx, span := v.start(ctx, "Fetch")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.Fetch(ctx, query)
+}
+
+func (v v1ServiceTracing) Search(ctx context.Context, query domain.TaskSearchQuery) (_ *domain.TaskSearchPage, err error) {
+	ctx, span := v.start(ctx, "Search")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.Search(ctx, query)
+}
+
+func (v v1ServiceTracing) FetchByID(ctx context.Context, id string) (_ *domain.Task, err error) {
+	ctx, span := v.start(ctx, "FetchByID", traceutil.String("task.id", id))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.FetchByID(ctx, id)
+}
+
+func (v v1ServiceTracing) Store(ctx context.Context, name string) (_ *domain.Task, err error) {
+	ctx, span := v.start(ctx, "Store")
+	defer func() { v.end(span, err) }()
+
+	task, err := v.svc.Store(ctx, name)
+	if err == nil {
+		span.SetAttributes(traceutil.String("task.id", task.ID))
+	}
+
+	return task, err
+}
+
+func (v v1ServiceTracing) Patch(ctx context.Context, spec domain.TaskPatchSpec) (_ *domain.Task, err error) {
+	ctx, span := v.start(ctx, "Patch", traceutil.String("task.id", spec.ID))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.Patch(ctx, spec)
+}
+
+func (v v1ServiceTracing) PatchDocument(ctx context.Context, spec domain.TaskDocumentPatchSpec) (_ *domain.Task, err error) {
+	ctx, span := v.start(ctx, "PatchDocument", traceutil.String("task.id", spec.ID))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.PatchDocument(ctx, spec)
+}
+
+func (v v1ServiceTracing) Replace(ctx context.Context, spec domain.TaskReplaceSpec) (_ *domain.Task, _ bool, err error) {
+	ctx, span := v.start(ctx, "Replace", traceutil.String("task.id", spec.ID))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.Replace(ctx, spec)
+}
+
+func (v v1ServiceTracing) DestroyByID(ctx context.Context, spec domain.TaskDestroySpec) (err error) {
+	ctx, span := v.start(ctx, "DestroyByID", traceutil.String("task.id", spec.ID))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.DestroyByID(ctx, spec)
+}
+
+func (v v1ServiceTracing) FetchTrash(ctx context.Context, query domain.TaskQuery) (_ *domain.TaskPage, err error) {
+	ctx, span := v.start(ctx, "FetchTrash")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.FetchTrash(ctx, query)
+}
+
+func (v v1ServiceTracing) Restore(ctx context.Context, id string) (_ *domain.Task, err error) {
+	ctx, span := v.start(ctx, "Restore", traceutil.String("task.id", id))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.Restore(ctx, id)
+}
+
+func (v v1ServiceTracing) Purge(ctx context.Context, id string) (err error) {
+	ctx, span := v.start(ctx, "Purge", traceutil.String("task.id", id))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.Purge(ctx, id)
+}
+
+func (v v1ServiceTracing) PurgeTrashed(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, span := v.start(ctx, "PurgeTrashed")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.PurgeTrashed(ctx, retention)
+}
+
+func (v v1ServiceTracing) FetchHistory(ctx context.Context, query domain.TaskHistoryQuery) (_ *domain.TaskHistoryPage, err error) {
+	ctx, span := v.start(ctx, "FetchHistory", traceutil.String("task.id", query.TaskID))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.FetchHistory(ctx, query)
+}
+
+func (v v1ServiceTracing) Batch(ctx context.Context, spec domain.TaskBatchSpec) (_ []*domain.TaskBatchResult, err error) {
+	ctx, span := v.start(ctx, "Batch", traceutil.Int("task.batch.size", len(spec.Operations)))
+	defer func() { v.end(span, err) }()
+
+	return v.svc.Batch(ctx, spec)
+}
+
+func (v v1ServiceTracing) ReserveIdempotencyKey(ctx context.Context, spec domain.IdempotencySpec) (_ *domain.IdempotencyRecord, err error) {
+	ctx, span := v.start(ctx, "ReserveIdempotencyKey")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.ReserveIdempotencyKey(ctx, spec)
+}
+
+func (v v1ServiceTracing) CompleteIdempotencyKey(ctx context.Context, record domain.IdempotencyRecord) (err error) {
+	ctx, span := v.start(ctx, "CompleteIdempotencyKey")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.CompleteIdempotencyKey(ctx, record)
+}
+
+func (v v1ServiceTracing) ReleaseIdempotencyKey(ctx context.Context, key string) (err error) {
+	ctx, span := v.start(ctx, "ReleaseIdempotencyKey")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.ReleaseIdempotencyKey(ctx, key)
+}
+
+func (v v1ServiceTracing) PurgeIdempotencyKeys(ctx context.Context) (_ int64, err error) {
+	ctx, span := v.start(ctx, "PurgeIdempotencyKeys")
+	defer func() { v.end(span, err) }()
+
+	return v.svc.PurgeIdempotencyKeys(ctx)
+}
@@ -1,142 +1,380 @@
 package task
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/anon-org/developing-api-services-with-golang/domain"
 	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	ErrInvalidPath error = errors.New("invalid path")
+	// v1HTTPFetchParams are the query parameters accepted by the task listing.
+	v1HTTPFetchParams = map[string]struct{}{
+		"limit":                {},
+		"cursor":               {},
+		"is_active":            {},
+		"name_prefix":          {},
+		"name_contains":        {},
+		"created_after":        {},
+		"created_before":       {},
+		"last_modified_after":  {},
+		"last_modified_before": {},
+		"sort":                 {},
+		"order":                {},
+	}
+
+	// v1HTTPSearchParams are the query parameters accepted by the task search.
+	v1HTTPSearchParams = map[string]struct{}{
+		"q":      {},
+		"limit":  {},
+		"cursor": {},
+	}
+
+	// v1HTTPHistoryParams are the query parameters accepted by the task history.
+	v1HTTPHistoryParams = map[string]struct{}{
+		"limit":  {},
+		"cursor": {},
+	}
+)
+
+var (
+	ErrInvalidPath      error = errors.New("invalid path")
+	ErrMalformedBody    error = errors.New("malformed request body")
+	ErrBodyTooLarge     error = errors.New("request body too large")
+	ErrMethodNotAllowed error = errors.New("method not allowed")
+
+	ErrUnsupportedMediaType error = errors.New("unsupported media type")
 )
 
 const (
 	// V1HTTPEndpoint is the endpoint for the v1 HTTP API.
 	V1HTTPEndpoint string = "/v1/tasks/"
+
+	// V1HTTPSearchEndpoint is the endpoint for the v1 HTTP full-text search API.
+	V1HTTPSearchEndpoint string = V1HTTPEndpoint + "search"
+
+	// V1HTTPBatchEndpoint is the endpoint for the v1 HTTP batch API.
+	V1HTTPBatchEndpoint string = V1HTTPEndpoint + "batch"
+
+	// V1HTTPTrashEndpoint is the endpoint for the v1 HTTP trash API.
+	V1HTTPTrashEndpoint string = V1HTTPEndpoint + "trash/"
+
+	// v1HTTPRestoreSuffix is the path suffix restoring a trashed task.
+	v1HTTPRestoreSuffix string = "/restore"
+
+	// v1HTTPHistorySuffix is the path suffix listing the change history of a task.
+	v1HTTPHistorySuffix string = "/history"
+
+	// v1HTTPActorHeader is the request header naming the actor recorded in the task history.
+	v1HTTPActorHeader string = "X-Actor"
+
+	// v1HTTPRequestIDHeader is the header correlating a request with its response and log records.
+	// An incoming id is kept when valid and otherwise replaced, the response always carries the id in use.
+	v1HTTPRequestIDHeader string = "X-Request-ID"
+
+	// v1HTTPRequestIDMaxLength is the maximum length of an incoming request id.
+	v1HTTPRequestIDMaxLength int = 128
+
+	// v1HTTPIdempotencyKeyHeader is the request header making a task creation safe to retry.
+	v1HTTPIdempotencyKeyHeader string = "Idempotency-Key"
+
+	// v1HTTPIdempotentReplayedHeader is the response header marking a response replayed for an idempotency key.
+	v1HTTPIdempotentReplayedHeader string = "Idempotent-Replayed"
+
+	// v1HTTPMaxBodyDefaultBytes is the size limit of a request body when none is set with WithMaxBodyBytes.
+	v1HTTPMaxBodyDefaultBytes int64 = 1 << 20
+
+	// problemTypePrefix is the prefix of the problem type URIs returned by the v1 HTTP API.
+	problemTypePrefix string = "urn:problem-type:"
 )
 
+// statusRecorder records the status and size of the response written through it, for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// idempotencyRecorder records the response written through it, see v1TransportHTTP.idempotent.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
 type v1TransportHTTP struct {
-	svc domain.TaskService
+	svc            domain.TaskService
+	debug          bool
+	logger         *slog.Logger
+	now            func() time.Time
+	requestTimeout time.Duration
+	maxBodyBytes   int64
+	ids            domain.IDGenerator
+	metrics        *v1Metrics
+	tracer         *traceutil.Tracer
+}
+
+// WithDebug toggles whether internal error details are exposed in problem responses.
+func (v *v1TransportHTTP) WithDebug(debug bool) *v1TransportHTTP {
+	v.debug = debug
+	return v
 }
 
 func (v v1TransportHTTP) Route() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// create contextual logger
-		l := logutil.NewCtxLogger()
-		ctx := logutil.PutCtxLogger(r.Context(), l)
+		// continue the trace of the client, or start a new one
+		route := v1Route(r.URL.Path)
+		ctx, span := v.tracer.Start(traceutil.Extract(r.Context(), r.Header), r.Method+" "+route, traceutil.SpanKindServer,
+			traceutil.String("http.request.method", r.Method),
+			traceutil.String("http.route", route),
+			traceutil.String("url.path", r.URL.Path),
+		)
+
+		// create contextual logger, correlated with the client by the request id
+		id := v.extractRequestID(r)
+		w.Header().Set(v1HTTPRequestIDHeader, id)
+		l := logutil.NewChildLogger(v.logger, id)
+		if sc := span.SpanContext(); sc.IsValid() {
+			l = l.With("trace_id", sc.TraceID.String())
+		}
+		ctx = logutil.PutCtxLogger(ctx, l)
+		ctx = logutil.PutCtxID(ctx, id)
+		ctx = domain.PutCtxActor(ctx, r.Header.Get(v1HTTPActorHeader))
+
+		if v.requestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, v.requestTimeout)
+			defer cancel()
+		}
 		r = r.WithContext(ctx)
 
-		// track latency
-		now := time.Now()
-		defer l.Println(r.Method, r.URL.Path, time.Since(now))
+		// no handler may buffer more than the limit, whether it decodes or hashes the body
+		if r.Body != nil && v.maxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, v.maxBodyBytes)
+		}
+
+		// track latency and outcome
+		now := v.now()
+		rec := &statusRecorder{ResponseWriter: w}
+		w = rec
+		defer func() {
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			duration := v.now().Sub(now)
+			l.Info("request served", "method", r.Method, "path", r.URL.Path, "status", rec.status, "bytes", rec.size, "duration", duration)
+			v.metrics.observeRequest(route, r.Method, rec.status, duration)
+
+			span.SetAttributes(traceutil.Int("http.response.status_code", rec.status))
+			if rec.status >= http.StatusInternalServerError {
+				span.SetStatus(traceutil.StatusError, http.StatusText(rec.status))
+			}
+			span.End()
+		}()
 
 		switch r.Method {
 		case http.MethodGet:
 			if V1HTTPEndpoint == r.URL.Path {
 				v.Fetch().ServeHTTP(w, r)
+			} else if V1HTTPSearchEndpoint == r.URL.Path {
+				v.Search().ServeHTTP(w, r)
+			} else if V1HTTPTrashEndpoint == r.URL.Path {
+				v.FetchTrash().ServeHTTP(w, r)
+			} else if strings.HasSuffix(r.URL.Path, v1HTTPHistorySuffix) {
+				v.FetchHistory().ServeHTTP(w, r)
 			} else {
 				v.FetchByID().ServeHTTP(w, r)
 			}
 		case http.MethodPost:
-			v.Store().ServeHTTP(w, r)
-		case http.MethodPatch, http.MethodPut:
+			if strings.HasPrefix(r.URL.Path, V1HTTPTrashEndpoint) && strings.HasSuffix(r.URL.Path, v1HTTPRestoreSuffix) {
+				v.Restore().ServeHTTP(w, r)
+			} else if V1HTTPBatchEndpoint == r.URL.Path {
+				v.Batch().ServeHTTP(w, r)
+			} else {
+				v.idempotent(v.Store()).ServeHTTP(w, r)
+			}
+		case http.MethodPatch:
 			v.Patch().ServeHTTP(w, r)
+		case http.MethodPut:
+			// the API endpoints below /v1/tasks/ are not task ids a client can create
+			if V1HTTPSearchEndpoint == r.URL.Path || V1HTTPBatchEndpoint == r.URL.Path || strings.HasPrefix(r.URL.Path+"/", V1HTTPTrashEndpoint) {
+				v.writeProblem(w, r, ErrMethodNotAllowed)
+			} else {
+				v.Replace().ServeHTTP(w, r)
+			}
 		case http.MethodDelete:
-			v.DestroyByID().ServeHTTP(w, r)
+			if strings.HasPrefix(r.URL.Path, V1HTTPTrashEndpoint) {
+				v.Purge().ServeHTTP(w, r)
+			} else {
+				v.DestroyByID().ServeHTTP(w, r)
+			}
+		default:
+			v.writeProblem(w, r, ErrMethodNotAllowed)
 		}
 	}
 }
 
 func (v v1TransportHTTP) Fetch() http.HandlerFunc {
+	return v.fetch(v.svc.Fetch)
+}
+
+func (v v1TransportHTTP) FetchTrash() http.HandlerFunc {
+	return v.fetch(v.svc.FetchTrash)
+}
+
+// fetch serves a page of tasks listed by fn.
+func (v v1TransportHTTP) fetch(fn func(context.Context, domain.TaskQuery) (*domain.TaskPage, error)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := logutil.GetCtxLogger(r.Context())
-		w.Header().Set("Content-Type", "application/json")
 
-		tasks, err := v.svc.Fetch(r.Context())
+		query, err := v.extractTaskQuery(r.URL.Query())
 		if err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			v.writeProblem(w, r, err)
 			return
 		}
 
-		responses := make([]*domain.TaskResponse, len(tasks))
-		for i, t := range tasks {
-			responses[i] = t.ToResponse()
+		tasks, err := fn(r.Context(), query)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
 		}
 
+		v.writeLinks(w, r, tasks.NextCursor, tasks.PrevCursor)
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(responses); err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		if err := json.NewEncoder(w).Encode(tasks.ToResponse()); err != nil {
+			l.Warn("failed to write response", "error", err)
 		}
 	}
 }
 
-func (v v1TransportHTTP) FetchByID() http.HandlerFunc {
+func (v v1TransportHTTP) Search() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := logutil.GetCtxLogger(r.Context())
 
+		query, err := v.extractTaskSearchQuery(r.URL.Query())
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		hits, err := v.svc.Search(r.Context(), query)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		v.writeLinks(w, r, hits.NextCursor, hits.PrevCursor)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(hits.ToResponse()); err != nil {
+			l.Warn("failed to write response", "error", err)
+		}
+	}
+}
+
+func (v v1TransportHTTP) FetchByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := logutil.GetCtxLogger(r.Context())
 
 		id, err := v.extractID(r.URL.Path)
 		if err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			v.writeProblem(w, r, err)
 			return
 		}
 
 		task, err := v.svc.FetchByID(r.Context(), id)
 		if err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", v.etag(task.Version))
+		if v.matchesIfNoneMatch(r, task.Version) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		if err := json.NewEncoder(w).Encode(task.ToResponse()); err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			l.Warn("failed to write response", "error", err)
 		}
 	}
 }
 
-func (v v1TransportHTTP) Store() http.HandlerFunc {
+func (v v1TransportHTTP) FetchHistory() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := logutil.GetCtxLogger(r.Context())
 
+		id, err := v.extractHistoryID(r.URL.Path)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		values := r.URL.Query()
+		if err := v.checkParams(values, v1HTTPHistoryParams); err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		page, err := v.extractPageSpec(values)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		history, err := v.svc.FetchHistory(r.Context(), domain.TaskHistoryQuery{TaskID: id, Page: page})
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		v.writeLinks(w, r, history.NextCursor, history.PrevCursor)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(history.ToResponse()); err != nil {
+			l.Warn("failed to write response", "error", err)
+		}
+	}
+}
+
+func (v v1TransportHTTP) Store() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := logutil.GetCtxLogger(r.Context())
 
 		var t domain.TaskStoreRequest
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		if err := v.decodeBody(r, &t); err != nil {
+			v.writeProblem(w, r, err)
 			return
 		}
 
 		stored, err := v.svc.Store(r.Context(), t.Name)
 		if err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			v.writeProblem(w, r, err)
 			return
 		}
 
+		w.Header().Set("ETag", v.etag(stored.Version))
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		if err := json.NewEncoder(w).Encode(stored.ToResponse()); err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			l.Warn("failed to write response", "error", err)
 		}
 	}
 }
@@ -145,65 +383,246 @@ func (v v1TransportHTTP) Patch() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := logutil.GetCtxLogger(r.Context())
 
+		id, err := v.extractID(r.URL.Path)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		expectedVersion, err := v.extractIfMatch(r)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		mediaType, err := v.extractMediaType(r)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		var patched *domain.Task
+		switch mediaType {
+		case "application/json":
+			var tr domain.TaskPatchRequest
+			if err := v.decodeBody(r, &tr); err != nil {
+				v.writeProblem(w, r, err)
+				return
+			}
+
+			t := domain.TaskPatchSpec{
+				ID:              id,
+				Name:            tr.Name,
+				IsActive:        tr.IsActive,
+				ExpectedVersion: expectedVersion,
+			}
+
+			patched, err = v.svc.Patch(r.Context(), t)
+		case string(domain.TaskMergePatch), string(domain.TaskJSONPatch):
+			var document json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&document); err != nil {
+				v.writeProblem(w, r, v.bodyError(err))
+				return
+			}
+
+			t := domain.TaskDocumentPatchSpec{
+				ID:              id,
+				Format:          domain.TaskPatchFormat(mediaType),
+				Document:        document,
+				ExpectedVersion: expectedVersion,
+			}
+
+			patched, err = v.svc.PatchDocument(r.Context(), t)
+		default:
+			err = fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+		}
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", v.etag(patched.Version))
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(patched.ToResponse()); err != nil {
+			l.Warn("failed to write response", "error", err)
+		}
+	}
+}
+
+func (v v1TransportHTTP) Replace() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := logutil.GetCtxLogger(r.Context())
 
 		id, err := v.extractID(r.URL.Path)
 		if err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		expectedVersion, err := v.extractIfMatch(r)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		var tr domain.TaskReplaceRequest
+		if err := v.decodeBody(r, &tr); err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		// PUT replaces the whole task, so unlike PATCH no field may be left out
+		if err := tr.Validate(); err != nil {
+			v.writeProblem(w, r, err)
 			return
 		}
 
-		var tr domain.TaskPatchRequest
-		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		t := domain.TaskReplaceSpec{
+			ID:              id,
+			Name:            *tr.Name,
+			IsActive:        *tr.IsActive,
+			ExpectedVersion: expectedVersion,
+		}
+
+		replaced, created, err := v.svc.Replace(r.Context(), t)
+		if err != nil {
+			v.writeProblem(w, r, err)
 			return
 		}
 
-		t := domain.TaskPatchSpec{
-			ID:       id,
-			Name:     tr.Name,
-			IsActive: tr.IsActive,
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+
+		w.Header().Set("ETag", v.etag(replaced.Version))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(replaced.ToResponse()); err != nil {
+			l.Warn("failed to write response", "error", err)
 		}
+	}
+}
 
-		patched, err := v.svc.Patch(r.Context(), t)
+func (v v1TransportHTTP) Batch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := logutil.GetCtxLogger(r.Context())
 
+		var br domain.TaskBatchRequest
+		if err := v.decodeBody(r, &br); err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		spec := br.ToSpec()
+		results, err := v.svc.Batch(r.Context(), spec)
 		if err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			v.writeProblem(w, r, err)
 			return
 		}
 
+		res := &domain.TaskBatchResponse{
+			Mode:    spec.Mode,
+			Results: make([]*domain.TaskBatchResultResponse, len(results)),
+		}
+
+		for i, result := range results {
+			rr := &domain.TaskBatchResultResponse{
+				Index: i,
+				Op:    result.Kind,
+			}
+
+			switch {
+			case result.Err != nil:
+				p := v.problem(r, result.Err)
+				rr.Status, rr.Error = p.Status, &p
+				res.Failed++
+			case result.Kind == domain.TaskBatchCreate:
+				rr.Status, rr.Task = http.StatusCreated, result.Task.ToResponse()
+				res.Succeeded++
+			case result.Kind == domain.TaskBatchDelete:
+				rr.Status = http.StatusNoContent
+				res.Succeeded++
+			default:
+				rr.Status, rr.Task = http.StatusOK, result.Task.ToResponse()
+				res.Succeeded++
+			}
+
+			res.Results[i] = rr
+		}
+
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(patched.ToResponse()); err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			l.Warn("failed to write response", "error", err)
 		}
 	}
 }
 
 func (v v1TransportHTTP) DestroyByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := v.extractID(r.URL.Path)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		expectedVersion, err := v.extractIfMatch(r)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		spec := domain.TaskDestroySpec{
+			ID:              id,
+			ExpectedVersion: expectedVersion,
+		}
+
+		if err := v.svc.DestroyByID(r.Context(), spec); err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (v v1TransportHTTP) Restore() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l := logutil.GetCtxLogger(r.Context())
+
+		id, err := v.extractTrashID(r.URL.Path)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		restored, err := v.svc.Restore(r.Context(), id)
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", v.etag(restored.Version))
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(restored.ToResponse()); err != nil {
+			l.Warn("failed to write response", "error", err)
+		}
+	}
+}
 
-		id, err := v.extractID(r.URL.Path)
+func (v v1TransportHTTP) Purge() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := v.extractTrashID(r.URL.Path)
 		if err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			v.writeProblem(w, r, err)
 			return
 		}
 
-		if err := v.svc.DestroyByID(r.Context(), id); err != nil {
-			l.Println(err)
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		if err := v.svc.Purge(r.Context(), id); err != nil {
+			v.writeProblem(w, r, err)
 			return
 		}
 
@@ -211,6 +630,517 @@ func (v v1TransportHTTP) DestroyByID() http.HandlerFunc {
 	}
 }
 
+// idempotent makes next safe to retry with the same Idempotency-Key header. The first response is stored under the key
+// and replayed to the retries, while a server error releases the key so that the request can run again.
+func (v v1TransportHTTP) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := logutil.GetCtxLogger(r.Context())
+
+		key := r.Header.Get(v1HTTPIdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			v.writeProblem(w, r, v.bodyError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, err := v.svc.ReserveIdempotencyKey(r.Context(), domain.IdempotencySpec{Key: key, Fingerprint: v.fingerprint(r, body)})
+		if err != nil {
+			v.writeProblem(w, r, err)
+			return
+		}
+
+		if stored != nil {
+			// the replay keeps the request id of the retry, the stored header has none
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(v1HTTPIdempotentReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			if _, err := w.Write(stored.Body); err != nil {
+				l.Warn("failed to write response", "error", err)
+			}
+			return
+		}
+
+		// the key is settled even when the request is canceled or times out
+		ctx := logutil.PutCtxLogger(context.Background(), l)
+
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+
+			if err := v.svc.ReleaseIdempotencyKey(ctx, key); err != nil {
+				l.Error("failed to release idempotency key", "idempotency_key", key, "error", err)
+			}
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+
+		header := w.Header().Clone()
+		header.Del(v1HTTPRequestIDHeader)
+
+		record := domain.IdempotencyRecord{
+			Key:    key,
+			Status: rec.status,
+			Header: header,
+			Body:   rec.body.Bytes(),
+		}
+
+		if err := v.svc.CompleteIdempotencyKey(ctx, record); err != nil {
+			l.Error("failed to complete idempotency key", "idempotency_key", key, "error", err)
+			return
+		}
+		completed = true
+	}
+}
+
+// fingerprint identifies a request by what it asks for, so that an idempotency key is only replayed to the same request.
+// A JSON body is compacted first, so that its formatting does not matter.
+func (v v1TransportHTTP) fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, r.Header.Get(v1HTTPActorHeader), r.Header.Get("Content-Type")} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, body); err == nil {
+		body = compact.Bytes()
+	}
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteHeader records the status and writes it through.
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records b and writes it through.
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// WriteHeader records the status and writes it through.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of b and writes it through.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// writeProblem writes err as an application/problem+json response.
+// The message of a client error is always exposed as detail, the message of a server error only when debug mode is on,
+// since it may contain storage internals.
+// Server errors are logged as errors, client errors only as information.
+func (v v1TransportHTTP) writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	l := logutil.GetCtxLogger(r.Context())
+	p := v.problem(r, err)
+
+	level := slog.LevelInfo
+	if p.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	l.Log(r.Context(), level, "request failed", "status", p.Status, "error", err)
+	if p.Status >= http.StatusInternalServerError {
+		traceutil.SpanFromContext(r.Context()).RecordError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		l.Warn("failed to write response", "error", err)
+	}
+}
+
+// problem builds the problem details of err.
+func (v v1TransportHTTP) problem(r *http.Request, err error) domain.ProblemResponse {
+	status, problemType := v.errorStatus(err)
+	p := domain.ProblemResponse{
+		Type:      problemType,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.Path,
+		RequestID: logutil.GetCtxID(r.Context()),
+	}
+
+	switch {
+	case status < http.StatusInternalServerError:
+		p.Detail = v.clientDetail(err)
+	case v.debug:
+		p.Detail = err.Error()
+	}
+
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		p.Errors = validationErr.Errors
+	}
+
+	return p
+}
+
+// clientDetail returns the message of a client error without the "failed to" context the layers it passed through
+// wrapped it in. A storage error classified as a domain.Error is reduced to its kind, its driver message is internal.
+func (v v1TransportHTTP) clientDetail(err error) string {
+	var details []string
+	for e := err; e != nil; {
+		if domainErr, ok := e.(*domain.Error); ok {
+			return strings.Join(append([]string{domainErr.Kind.Error()}, details...), ": ")
+		}
+
+		// every layer wraps with "%w: message", so its own message follows the one of the error it wraps
+		inner := errors.Unwrap(e)
+		if inner == nil || !strings.HasPrefix(e.Error(), inner.Error()+": ") {
+			return strings.Join(append([]string{e.Error()}, details...), ": ")
+		}
+
+		if msg := strings.TrimPrefix(e.Error(), inner.Error()+": "); !strings.HasPrefix(msg, "failed to ") {
+			details = append([]string{msg}, details...)
+		}
+		e = inner
+	}
+
+	return strings.Join(details, ": ")
+}
+
+// errorStatus maps an error returned by the service to its HTTP status code and problem type.
+func (v v1TransportHTTP) errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrInvalidPath), errors.Is(err, domain.ErrNotFound):
+		return http.StatusNotFound, problemTypePrefix + "not-found"
+	case errors.Is(err, domain.ErrGone):
+		return http.StatusGone, problemTypePrefix + "gone"
+	case errors.Is(err, ErrMalformedBody):
+		return http.StatusBadRequest, problemTypePrefix + "malformed-body"
+	case errors.Is(err, ErrBodyTooLarge):
+		return http.StatusRequestEntityTooLarge, problemTypePrefix + "body-too-large"
+	case errors.Is(err, ErrMethodNotAllowed):
+		return http.StatusMethodNotAllowed, problemTypePrefix + "method-not-allowed"
+	case errors.Is(err, domain.ErrBatchAborted):
+		return http.StatusFailedDependency, problemTypePrefix + "batch-aborted"
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType, problemTypePrefix + "unsupported-media-type"
+	case errors.Is(err, domain.ErrConflict):
+		return http.StatusConflict, problemTypePrefix + "conflict"
+	case errors.Is(err, domain.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, problemTypePrefix + "precondition-failed"
+	case errors.Is(err, domain.ErrIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity, problemTypePrefix + "idempotency-key-reused"
+	case errors.Is(err, domain.ErrValidation):
+		return http.StatusUnprocessableEntity, problemTypePrefix + "validation"
+	case errors.Is(err, domain.ErrUnavailable):
+		return http.StatusServiceUnavailable, problemTypePrefix + "unavailable"
+	default:
+		return http.StatusInternalServerError, "about:blank"
+	}
+}
+
+// decodeBody decodes the JSON request body into dst, rejecting fields dst does not declare.
+// Unknown and mistyped fields are reported as field errors, anything else as ErrMalformedBody.
+func (v v1TransportHTTP) decodeBody(r *http.Request, dst any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		return nil
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		return domain.NewFieldError(typeErr.Field, fmt.Sprintf("must be a %s", typeErr.Type))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return domain.NewFieldError(field, "is not allowed")
+	default:
+		return v.bodyError(err)
+	}
+}
+
+// bodyError classifies an error reading the request body as ErrBodyTooLarge or ErrMalformedBody.
+func (v v1TransportHTTP) bodyError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return fmt.Errorf("%w: the limit is %d bytes", ErrBodyTooLarge, maxErr.Limit)
+	}
+
+	return fmt.Errorf("%w: %v", ErrMalformedBody, err)
+}
+
+// extractMediaType returns the media type of the request body, which defaults to application/json.
+func (v v1TransportHTTP) extractMediaType(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return "application/json", nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedMediaType, err)
+	}
+
+	return mediaType, nil
+}
+
+// etag returns the strong entity tag of a task version.
+func (v v1TransportHTTP) etag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// extractIfMatch parses the If-Match header into the versions a write is conditional on, or nil when it is absent.
+// "*" is met by any existing task, so that a PUT with it never creates one.
+func (v v1TransportHTTP) extractIfMatch(r *http.Request) (*domain.VersionMatch, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return nil, nil
+	}
+
+	if ifMatch == "*" {
+		return &domain.VersionMatch{Any: true}, nil
+	}
+
+	match := &domain.VersionMatch{}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+
+		// If-Match uses the strong comparison, so a weak tag can never match
+		weak := strings.HasPrefix(tag, "W/")
+		opaque := strings.TrimPrefix(tag, "W/")
+		if len(opaque) < 2 || !strings.HasPrefix(opaque, `"`) || !strings.HasSuffix(opaque, `"`) {
+			return nil, fmt.Errorf("%w: If-Match must be \"*\" or a list of entity tags", domain.ErrValidation)
+		}
+
+		// a tag that is not one of ours can never match either
+		version, err := strconv.ParseInt(opaque[1:len(opaque)-1], 10, 64)
+		if weak || err != nil {
+			continue
+		}
+
+		match.Versions = append(match.Versions, version)
+	}
+
+	if len(match.Versions) == 0 {
+		return nil, fmt.Errorf("%w: If-Match does not match any task version", domain.ErrPreconditionFailed)
+	}
+
+	return match, nil
+}
+
+// matchesIfNoneMatch reports whether the If-None-Match header matches the task version, using the weak comparison.
+func (v v1TransportHTTP) matchesIfNoneMatch(r *http.Request, version int64) bool {
+	ifNoneMatch := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if ifNoneMatch == "*" {
+		return true
+	}
+
+	etag := v.etag(version)
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extractRequestID returns the X-Request-ID of the request, or else the trace id of its traceparent, or else a new id.
+// Malformed ids are replaced rather than rejected, so that they never reach the logs. A request is never failed for
+// want of an id: the task id generator stands in for a failing source of randomness, and the clock for both.
+func (v v1TransportHTTP) extractRequestID(r *http.Request) string {
+	if id := r.Header.Get(v1HTTPRequestIDHeader); id != "" && len(id) <= v1HTTPRequestIDMaxLength {
+		valid := true
+		for _, c := range id {
+			if c < 0x21 || c > 0x7e {
+				valid = false
+				break
+			}
+		}
+
+		if valid {
+			return id
+		}
+	}
+
+	if sc, err := traceutil.ParseTraceparent(r.Header.Get(traceutil.TraceparentHeader)); err == nil {
+		return sc.TraceID.String()
+	}
+
+	id, err := logutil.NewID()
+	if err == nil {
+		return id
+	}
+
+	v.logger.Warn("failed to generate request id", "error", err)
+	if id, err := v.ids.Generate(); err == nil {
+		return id
+	}
+
+	return strconv.FormatInt(v.now().UnixNano(), 36)
+}
+
+// extractTaskQuery parses the filter, sort and page query parameters of a task listing.
+// Unknown or malformed parameters are rejected rather than ignored.
+func (v v1TransportHTTP) extractTaskQuery(values url.Values) (domain.TaskQuery, error) {
+	var q domain.TaskQuery
+
+	if err := v.checkParams(values, v1HTTPFetchParams); err != nil {
+		return q, err
+	}
+
+	page, err := v.extractPageSpec(values)
+	if err != nil {
+		return q, err
+	}
+	q.Page = page
+
+	if isActive := values.Get("is_active"); isActive != "" {
+		b, err := strconv.ParseBool(isActive)
+		if err != nil {
+			return q, domain.NewFieldError("is_active", "must be a boolean")
+		}
+		q.Filter.IsActive = &b
+	}
+
+	q.Filter.NamePrefix = values.Get("name_prefix")
+	q.Filter.NameContains = values.Get("name_contains")
+
+	for key, t := range map[string]*time.Time{
+		"created_after":        &q.Filter.CreatedAfter,
+		"created_before":       &q.Filter.CreatedBefore,
+		"last_modified_after":  &q.Filter.LastModifiedAfter,
+		"last_modified_before": &q.Filter.LastModifiedBefore,
+	} {
+		if value := values.Get(key); value != "" {
+			parsed, err := v.parseTime(value)
+			if err != nil {
+				return q, domain.NewFieldError(key, "must be unix milliseconds or RFC 3339")
+			}
+			*t = parsed
+		}
+	}
+
+	q.Sort.Field = domain.TaskSortField(values.Get("sort"))
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Sort.Desc = true
+	default:
+		return q, domain.NewFieldError("order", "must be asc or desc")
+	}
+
+	return q, nil
+}
+
+// extractTaskSearchQuery parses the text and page query parameters of a task search.
+func (v v1TransportHTTP) extractTaskSearchQuery(values url.Values) (domain.TaskSearchQuery, error) {
+	var q domain.TaskSearchQuery
+
+	if err := v.checkParams(values, v1HTTPSearchParams); err != nil {
+		return q, err
+	}
+
+	page, err := v.extractPageSpec(values)
+	if err != nil {
+		return q, err
+	}
+
+	q.Text = values.Get("q")
+	q.Page = page
+
+	return q, nil
+}
+
+// extractPageSpec parses the limit and cursor query parameters.
+func (v v1TransportHTTP) extractPageSpec(values url.Values) (domain.PageSpec, error) {
+	page := domain.PageSpec{
+		Cursor: values.Get("cursor"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return page, domain.NewFieldError("limit", "must be an integer")
+		}
+		page.Limit = n
+	}
+
+	return page, nil
+}
+
+// checkParams rejects query parameters that are not in allowed.
+func (v v1TransportHTTP) checkParams(values url.Values, allowed map[string]struct{}) error {
+	for key := range values {
+		if _, ok := allowed[key]; !ok {
+			return domain.NewFieldError(key, "is not a known query parameter")
+		}
+	}
+
+	return nil
+}
+
+// parseTime parses unix milliseconds, the format of timestamps in task responses, or RFC 3339.
+func (v v1TransportHTTP) parseTime(value string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// writeLinks sets the RFC 8288 Link header for the next and previous pages.
+func (v v1TransportHTTP) writeLinks(w http.ResponseWriter, r *http.Request, next, prev string) {
+	link := func(cursor, rel string) string {
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+
+	if next != "" {
+		w.Header().Add("Link", link(next, "next"))
+	}
+
+	if prev != "" {
+		w.Header().Add("Link", link(prev, "prev"))
+	}
+}
+
 func (v v1TransportHTTP) extractID(path string) (string, error) {
 	if V1HTTPEndpoint == path {
 		return "", ErrInvalidPath
@@ -224,3 +1154,23 @@ func (v v1TransportHTTP) extractID(path string) (string, error) {
 
 	return list[1], nil
 }
+
+// extractTrashID extracts the id of /v1/tasks/trash/{id} and /v1/tasks/trash/{id}/restore.
+func (v v1TransportHTTP) extractTrashID(path string) (string, error) {
+	id := strings.TrimSuffix(strings.TrimPrefix(path, V1HTTPTrashEndpoint), v1HTTPRestoreSuffix)
+	if id == "" || strings.Contains(id, "/") {
+		return "", ErrInvalidPath
+	}
+
+	return id, nil
+}
+
+// extractHistoryID extracts the id of /v1/tasks/{id}/history.
+func (v v1TransportHTTP) extractHistoryID(path string) (string, error) {
+	id := strings.TrimSuffix(strings.TrimPrefix(path, V1HTTPEndpoint), v1HTTPHistorySuffix)
+	if id == "" || strings.Contains(id, "/") {
+		return "", ErrInvalidPath
+	}
+
+	return id, nil
+}
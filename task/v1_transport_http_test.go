@@ -2,33 +2,48 @@ package task_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"github.com/anon-org/developing-api-services-with-golang/domain"
+	"github.com/anon-org/developing-api-services-with-golang/migrations"
 	"github.com/anon-org/developing-api-services-with-golang/task"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	db, _ = sql.Open("sqlite3", ":memory:")
 	api   = task.Wire(db)
+
+	// searchAvailable reports whether sqlite was built with FTS5, see the sqlite_fts5 build tag.
+	searchAvailable bool
 )
 
 func TestMain(m *testing.M) {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS tasks(
-	id TEXT PRIMARY KEY,
-	name TEXT UNIQUE NOT NULL,
-	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	last_modified_at TIMESTAMP NOT NULL DEFAULT 0,
-	is_active BOOL NOT NULL DEFAULT TRUE)`)
+	// every connection to :memory: opens a new empty database, so all requests must share one
+	db.SetMaxOpenConns(1)
+
+	migrator, err := migrations.New(db, migrations.Sqlite)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// the search migrations are skipped unless sqlite was built with FTS5, see searchAvailable
+	if err := migrator.WithSkipMissing(true).Up(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&searchAvailable); err != nil {
+		log.Fatal(err)
+	}
+
 	defer db.Close()
 	m.Run()
 }
@@ -101,12 +116,38 @@ func TestV1TransportHTTP_Store(t *testing.T) {
 
 	api.Route().ServeHTTP(res, req)
 
-	if ct := res.Header().Get("Content-Type"); ct != "application/json" {
-		t.Errorf("expected Content-Type to be application/json, got %s", ct)
+	if ct := res.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected Content-Type to be application/problem+json, got %s", ct)
+	}
+
+	if res.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", res.Code)
+	}
+
+	var p domain.ProblemResponse
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	if p.Status != http.StatusConflict {
+		t.Errorf("expected 409, got %d", p.Status)
+	}
+
+	if p.Type == "" || p.Title == "" {
+		t.Errorf("expected non-empty type and title, got %v", p)
+	}
+
+	if p.Instance != task.V1HTTPEndpoint {
+		t.Errorf("expected %s, got %s", task.V1HTTPEndpoint, p.Instance)
+	}
+
+	if p.RequestID == "" {
+		t.Errorf("expected non-empty RequestID, got %s", p.RequestID)
 	}
 
-	if res.Code != http.StatusInternalServerError {
-		t.Errorf("expected 500, got %d", res.Code)
+	// the storage error behind the conflict is internal, only its kind is exposed
+	if p.Detail != domain.ErrConflict.Error() {
+		t.Errorf("expected Detail %q, got %q", domain.ErrConflict, p.Detail)
 	}
 }
 
@@ -127,14 +168,111 @@ func TestV1TransportHTTP_Fetch(t *testing.T) {
 		t.Errorf("expected 200, got %d", res.Code)
 	}
 
-	var tasks []domain.TaskResponse
-	if err := json.NewDecoder(res.Body).Decode(&tasks); err != nil {
+	var page domain.TaskPageResponse
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	if len(page.Data) == 0 {
+		t.Errorf("expected non-empty tasks, got %v", page.Data)
+	}
+}
+
+func v1TransportHTTP_FetchPage(t *testing.T, query string) (domain.TaskPageResponse, http.Header) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, task.V1HTTPEndpoint+"?"+query, nil)
+	res := httptest.NewRecorder()
+
+	api.Route().ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.Code)
+	}
+
+	var page domain.TaskPageResponse
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
 
-	if len(tasks) == 0 {
-		t.Errorf("expected non-empty tasks, got %v", tasks)
+	return page, res.Header()
+}
+
+func TestV1TransportHTTP_Fetch_Pagination(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		v1TransportHTTP_Store(fmt.Sprintf("TestV1TransportHTTP_Fetch_Pagination%d", i))(t)
+	}
+
+	first, header := v1TransportHTTP_FetchPage(t, "limit=2")
+	if len(first.Data) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(first.Data))
+	}
+
+	if first.NextCursor == "" || first.PrevCursor != "" {
+		t.Errorf("expected only next cursor, got next %q prev %q", first.NextCursor, first.PrevCursor)
+	}
+
+	if link := header.Get("Link"); !strings.Contains(link, `rel="next"`) {
+		t.Errorf("expected next Link header, got %s", link)
+	}
+
+	second, _ := v1TransportHTTP_FetchPage(t, "limit=2&cursor="+first.NextCursor)
+	if len(second.Data) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(second.Data))
 	}
+
+	if second.Data[0].ID == first.Data[1].ID {
+		t.Errorf("expected pages not to overlap, got %s twice", second.Data[0].ID)
+	}
+
+	if second.PrevCursor == "" {
+		t.Fatalf("expected prev cursor, got none")
+	}
+
+	back, _ := v1TransportHTTP_FetchPage(t, "limit=2&cursor="+second.PrevCursor)
+	if len(back.Data) != 2 || back.Data[0].ID != first.Data[0].ID || back.Data[1].ID != first.Data[1].ID {
+		t.Errorf("expected prev page to equal first page, got %v", back.Data)
+	}
+
+	t.Run("invalid limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, task.V1HTTPEndpoint+"?limit=foo", nil)
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", res.Code)
+		}
+
+		var p domain.ProblemResponse
+		if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		if len(p.Errors) != 1 || p.Errors[0].Field != "limit" || p.Detail == "" {
+			t.Errorf("expected a single error of field limit and a detail, got %+v", p)
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, task.V1HTTPEndpoint+"?cursor=foo", nil)
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", res.Code)
+		}
+
+		var p domain.ProblemResponse
+		if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		if len(p.Errors) != 1 || p.Errors[0].Field != "cursor" || p.Detail == "" {
+			t.Errorf("expected a single error of field cursor and a detail, got %+v", p)
+		}
+	})
 }
 
 func TestV1RepositorySqlite_FetchByID(t *testing.T) {
@@ -179,6 +317,17 @@ func TestV1RepositorySqlite_FetchByID(t *testing.T) {
 	}
 }
 
+func TestV1RepositorySqlite_FetchByID_NotFound(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, task.V1HTTPEndpoint+"foo", nil)
+	res := httptest.NewRecorder()
+
+	api.Route().ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", res.Code)
+	}
+}
+
 func TestV1RepositorySqlite_Patch(t *testing.T) {
 	const taskName string = "TestV1RepositorySqlite_Patch"
 	id := v1TransportHTTP_Store(taskName)(t)
@@ -338,6 +487,51 @@ func TestV1RepositorySqlite_Patch(t *testing.T) {
 		}
 	})
 
+	t.Run("conflict", func(t *testing.T) {
+		patchName := "TestV1RepositorySqlite_Patch_Conflict"
+		v1TransportHTTP_Store(patchName)(t)
+
+		var b bytes.Buffer
+		p := &domain.TaskPatchRequest{
+			Name: &patchName,
+		}
+
+		if err := json.NewEncoder(&b).Encode(p); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPatch, task.V1HTTPEndpoint+id, &b)
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		if res.Code != http.StatusConflict {
+			t.Errorf("expected 409, got %d", res.Code)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		patchName := "TestV1RepositorySqlite_Patch_NotFound"
+
+		var b bytes.Buffer
+		p := &domain.TaskPatchRequest{
+			Name: &patchName,
+		}
+
+		if err := json.NewEncoder(&b).Encode(p); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPatch, task.V1HTTPEndpoint+"foo", &b)
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		if res.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", res.Code)
+		}
+	})
+
 	t.Run("no patch", func(t *testing.T) {
 		var b bytes.Buffer
 		p := &domain.TaskPatchRequest{}
@@ -351,12 +545,12 @@ func TestV1RepositorySqlite_Patch(t *testing.T) {
 
 		api.Route().ServeHTTP(res, req)
 
-		if ct := res.Header().Get("Content-Type"); ct != "application/json" {
-			t.Errorf("expected Content-Type to be application/json, got %s", ct)
+		if ct := res.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("expected Content-Type to be application/problem+json, got %s", ct)
 		}
 
-		if res.Code != http.StatusBadRequest {
-			t.Errorf("expected 400, got %d", res.Code)
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", res.Code)
 		}
 	})
 }
@@ -387,3 +581,1076 @@ func TestV1RepositorySqlite_DestroyByID(t *testing.T) {
 		}
 	})
 }
+
+func TestV1TransportHTTP_Fetch_Filter(t *testing.T) {
+	const prefix string = "TestV1TransportHTTP_Fetch_Filter"
+	for _, suffix := range []string{"_b", "_a", "_c"} {
+		v1TransportHTTP_Store(prefix + suffix)(t)
+	}
+	v1TransportHTTP_Store("Other" + prefix)(t)
+
+	t.Run("name prefix sorted by name desc", func(t *testing.T) {
+		page, _ := v1TransportHTTP_FetchPage(t, "name_prefix="+prefix+"&sort=name&order=desc&limit=2")
+		if len(page.Data) != 2 {
+			t.Fatalf("expected 2 tasks, got %d", len(page.Data))
+		}
+
+		if page.Data[0].Name != prefix+"_c" || page.Data[1].Name != prefix+"_b" {
+			t.Errorf("expected _c then _b, got %s then %s", page.Data[0].Name, page.Data[1].Name)
+		}
+
+		next, _ := v1TransportHTTP_FetchPage(t, "name_prefix="+prefix+"&sort=name&order=desc&limit=2&cursor="+page.NextCursor)
+		if len(next.Data) != 1 || next.Data[0].Name != prefix+"_a" {
+			t.Errorf("expected only _a, got %v", next.Data)
+		}
+
+		if next.NextCursor != "" {
+			t.Errorf("expected no next cursor, got %s", next.NextCursor)
+		}
+	})
+
+	t.Run("name contains", func(t *testing.T) {
+		page, _ := v1TransportHTTP_FetchPage(t, "name_contains="+prefix)
+		if len(page.Data) != 4 {
+			t.Errorf("expected 4 tasks, got %d", len(page.Data))
+		}
+	})
+
+	t.Run("created range", func(t *testing.T) {
+		page, _ := v1TransportHTTP_FetchPage(t, "name_prefix="+prefix+"&created_after=2000-01-01T00:00:00Z&created_before=2000-01-02T00:00:00Z")
+		if len(page.Data) != 0 {
+			t.Errorf("expected no tasks, got %d", len(page.Data))
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for query, field := range map[string]string{
+			"is_active=maybe":                        "is_active",
+			"sort=foo":                               "sort",
+			"sort=deleted_at":                        "sort",
+			"order=up":                               "order",
+			"created_after=yesterday":                "created_after",
+			"created_after=2000&created_before=1000": "created_after",
+			"foo=bar":                                "foo",
+		} {
+			req := httptest.NewRequest(http.MethodGet, task.V1HTTPEndpoint+"?"+query, nil)
+			res := httptest.NewRecorder()
+
+			api.Route().ServeHTTP(res, req)
+
+			if res.Code != http.StatusUnprocessableEntity {
+				t.Errorf("expected 422 for %s, got %d", query, res.Code)
+			}
+
+			var p domain.ProblemResponse
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if len(p.Errors) != 1 || p.Errors[0].Field != field || p.Errors[0].Message == "" {
+				t.Errorf("expected a single error of field %s for %s, got %+v", field, query, p.Errors)
+			}
+		}
+	})
+}
+
+func TestV1TransportHTTP_Search(t *testing.T) {
+	if !searchAvailable {
+		t.Skip("sqlite was built without FTS5, run the tests with -tags sqlite_fts5")
+	}
+
+	v1TransportHTTP_Store("searchable quarterly report")(t)
+	v1TransportHTTP_Store("searchable report")(t)
+	v1TransportHTTP_Store("searchable quarterly planning")(t)
+	id := v1TransportHTTP_Store("searchable renamed")(t)
+
+	search := func(t *testing.T, query string) domain.TaskSearchPageResponse {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, task.V1HTTPSearchEndpoint+"?"+query, nil)
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", res.Code)
+		}
+
+		var page domain.TaskSearchPageResponse
+		if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		return page
+	}
+
+	t.Run("ranked with snippets", func(t *testing.T) {
+		page := search(t, "q=report")
+		if len(page.Data) != 2 {
+			t.Fatalf("expected 2 hits, got %d", len(page.Data))
+		}
+
+		if page.Data[0].Name != "searchable report" {
+			t.Errorf("expected the shorter name to rank first, got %s", page.Data[0].Name)
+		}
+
+		if page.Data[0].Score < page.Data[1].Score {
+			t.Errorf("expected descending scores, got %v then %v", page.Data[0].Score, page.Data[1].Score)
+		}
+
+		if !strings.Contains(page.Data[0].Snippet, "<mark>report</mark>") {
+			t.Errorf("expected highlighted snippet, got %s", page.Data[0].Snippet)
+		}
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		first := search(t, "q=searchable&limit=2")
+		if len(first.Data) != 2 || first.NextCursor == "" {
+			t.Fatalf("expected 2 hits and a next cursor, got %d and %q", len(first.Data), first.NextCursor)
+		}
+
+		second := search(t, "q=searchable&limit=2&cursor="+first.NextCursor)
+		if len(second.Data) != 2 || second.NextCursor != "" {
+			t.Errorf("expected 2 hits and no next cursor, got %d and %q", len(second.Data), second.NextCursor)
+		}
+	})
+
+	t.Run("kept in sync", func(t *testing.T) {
+		name := "searchable retitled"
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(&domain.TaskPatchRequest{Name: &name}); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPatch, task.V1HTTPEndpoint+id, &b)
+		api.Route().ServeHTTP(httptest.NewRecorder(), req)
+
+		if page := search(t, "q=retitled"); len(page.Data) != 1 || page.Data[0].ID != id {
+			t.Errorf("expected the patched task, got %v", page.Data)
+		}
+
+		if page := search(t, "q=renamed"); len(page.Data) != 0 {
+			t.Errorf("expected no hits for the old name, got %v", page.Data)
+		}
+	})
+
+	t.Run("missing text", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, task.V1HTTPSearchEndpoint, nil)
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", res.Code)
+		}
+	})
+}
+
+func v1TransportHTTP_Do(t *testing.T, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+
+	api.Route().ServeHTTP(res, req)
+
+	return res
+}
+
+func v1TransportHTTP_InTrash(t *testing.T, id string) bool {
+	t.Helper()
+
+	res := v1TransportHTTP_Do(t, http.MethodGet, task.V1HTTPTrashEndpoint+"?limit=100")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.Code)
+	}
+
+	var page domain.TaskPageResponse
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	for _, tr := range page.Data {
+		if tr.ID == id {
+			if tr.DeletedAt == 0 {
+				t.Errorf("expected non-zero DeletedAt, got %v", tr.DeletedAt)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestV1TransportHTTP_Trash(t *testing.T) {
+	t.Run("destroy and restore", func(t *testing.T) {
+		id := v1TransportHTTP_Store("TestV1TransportHTTP_Trash_Restore")(t)
+
+		if res := v1TransportHTTP_Do(t, http.MethodDelete, task.V1HTTPEndpoint+id); res.Code != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", res.Code)
+		}
+
+		if res := v1TransportHTTP_Do(t, http.MethodGet, task.V1HTTPEndpoint+id); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", res.Code)
+		}
+
+		if res := v1TransportHTTP_Do(t, http.MethodDelete, task.V1HTTPEndpoint+id); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404 destroying twice, got %d", res.Code)
+		}
+
+		if !v1TransportHTTP_InTrash(t, id) {
+			t.Errorf("expected %s in trash", id)
+		}
+
+		// a trashed task is restored rather than replaced
+		req := httptest.NewRequest(http.MethodPut, task.V1HTTPEndpoint+id, strings.NewReader(`{"name":"TestV1TransportHTTP_Trash_Restore","is_active":true}`))
+		res := httptest.NewRecorder()
+		api.Route().ServeHTTP(res, req)
+		if res.Code != http.StatusGone {
+			t.Errorf("expected 410 replacing a trashed task, got %d", res.Code)
+		}
+
+		if res := v1TransportHTTP_Do(t, http.MethodPost, task.V1HTTPTrashEndpoint+id+"/restore"); res.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", res.Code)
+		}
+
+		if res := v1TransportHTTP_Do(t, http.MethodGet, task.V1HTTPEndpoint+id); res.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", res.Code)
+		}
+
+		if v1TransportHTTP_InTrash(t, id) {
+			t.Errorf("expected %s not in trash", id)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		id := v1TransportHTTP_Store("TestV1TransportHTTP_Trash_Purge")(t)
+
+		if res := v1TransportHTTP_Do(t, http.MethodDelete, task.V1HTTPTrashEndpoint+id); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404 purging a live task, got %d", res.Code)
+		}
+
+		v1TransportHTTP_Do(t, http.MethodDelete, task.V1HTTPEndpoint+id)
+
+		if res := v1TransportHTTP_Do(t, http.MethodDelete, task.V1HTTPTrashEndpoint+id); res.Code != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", res.Code)
+		}
+
+		if v1TransportHTTP_InTrash(t, id) {
+			t.Errorf("expected %s not in trash", id)
+		}
+
+		if res := v1TransportHTTP_Do(t, http.MethodPost, task.V1HTTPTrashEndpoint+id+"/restore"); res.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", res.Code)
+		}
+	})
+
+	t.Run("purger", func(t *testing.T) {
+		id := v1TransportHTTP_Store("TestV1TransportHTTP_Trash_Purger")(t)
+		v1TransportHTTP_Do(t, http.MethodDelete, task.V1HTTPEndpoint+id)
+
+		// trashed tasks are stamped with second precision, so wait for a second to exceed the zero retention
+		time.Sleep(time.Second)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		purger := task.ProvideV1Purger(task.ProvideV1Service(task.ProvideV1RepositorySqlite(db)), 0, 10*time.Millisecond)
+		go purger.Run(ctx)
+
+		deadline := time.Now().Add(time.Second)
+		for v1TransportHTTP_InTrash(t, id) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s to be purged", id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestV1TransportHTTP_ConditionalRequests(t *testing.T) {
+	id := v1TransportHTTP_Store("TestV1TransportHTTP_ConditionalRequests")(t)
+
+	do := func(t *testing.T, method, path string, header http.Header, body any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var b bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&b).Encode(body); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}
+
+		req := httptest.NewRequest(method, path, &b)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		return res
+	}
+
+	res := do(t, http.MethodGet, task.V1HTTPEndpoint+id, nil, nil)
+	etag := res.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf(`expected ETag "1", got %s`, etag)
+	}
+
+	t.Run("if none match", func(t *testing.T) {
+		res := do(t, http.MethodGet, task.V1HTTPEndpoint+id, http.Header{"If-None-Match": {etag}}, nil)
+		if res.Code != http.StatusNotModified {
+			t.Errorf("expected 304, got %d", res.Code)
+		}
+
+		res = do(t, http.MethodGet, task.V1HTTPEndpoint+id, http.Header{"If-None-Match": {`"42"`}}, nil)
+		if res.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", res.Code)
+		}
+	})
+
+	t.Run("if match", func(t *testing.T) {
+		active := false
+		body := &domain.TaskPatchRequest{IsActive: &active}
+
+		res := do(t, http.MethodPatch, task.V1HTTPEndpoint+id, http.Header{"If-Match": {etag}}, body)
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", res.Code)
+		}
+
+		if got := res.Header().Get("ETag"); got != `"2"` {
+			t.Errorf(`expected ETag "2", got %s`, got)
+		}
+
+		res = do(t, http.MethodPatch, task.V1HTTPEndpoint+id, http.Header{"If-Match": {etag}}, body)
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected 412, got %d", res.Code)
+		}
+
+		res = do(t, http.MethodPatch, task.V1HTTPEndpoint+"foo", http.Header{"If-Match": {etag}}, body)
+		if res.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", res.Code)
+		}
+
+		res = do(t, http.MethodDelete, task.V1HTTPEndpoint+id, http.Header{"If-Match": {etag}}, nil)
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected 412, got %d", res.Code)
+		}
+
+		res = do(t, http.MethodPatch, task.V1HTTPEndpoint+id, http.Header{"If-Match": {`W/"2", "abc"`}}, body)
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected 412 for tags that cannot match, got %d", res.Code)
+		}
+
+		res = do(t, http.MethodPatch, task.V1HTTPEndpoint+id, http.Header{"If-Match": {`1`}}, body)
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422 for a malformed tag, got %d", res.Code)
+		}
+
+		// a list matches any of its versions
+		res = do(t, http.MethodDelete, task.V1HTTPEndpoint+id, http.Header{"If-Match": {`"1", "3"`}}, nil)
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected 412, got %d", res.Code)
+		}
+
+		res = do(t, http.MethodDelete, task.V1HTTPEndpoint+id, http.Header{"If-Match": {`"1", "2"`}}, nil)
+		if res.Code != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", res.Code)
+		}
+	})
+}
+
+func TestV1TransportHTTP_FetchHistory(t *testing.T) {
+	id := v1TransportHTTP_Store("TestV1TransportHTTP_FetchHistory")(t)
+
+	var b bytes.Buffer
+	active := false
+	if err := json.NewEncoder(&b).Encode(&domain.TaskPatchRequest{IsActive: &active}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, task.V1HTTPEndpoint+id, &b)
+	req.Header.Set("X-Actor", "alice")
+	res := httptest.NewRecorder()
+	api.Route().ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.Code)
+	}
+
+	if res := v1TransportHTTP_Do(t, http.MethodDelete, task.V1HTTPEndpoint+id); res.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", res.Code)
+	}
+
+	fetchHistory := func(t *testing.T, query string) (domain.TaskHistoryPageResponse, int) {
+		t.Helper()
+
+		res := v1TransportHTTP_Do(t, http.MethodGet, task.V1HTTPEndpoint+id+"/history"+query)
+
+		var page domain.TaskHistoryPageResponse
+		if res.Code == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}
+
+		return page, res.Code
+	}
+
+	t.Run("entries", func(t *testing.T) {
+		page, code := fetchHistory(t, "")
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if len(page.Data) != 3 {
+			t.Fatalf("expected 3 entries, got %d", len(page.Data))
+		}
+
+		store, patch, destroy := page.Data[0], page.Data[1], page.Data[2]
+
+		if store.Operation != domain.TaskHistoryStore || store.Before != nil || store.After.Version != 1 {
+			t.Errorf("expected store without before at version 1, got %+v", store)
+		}
+
+		if patch.Operation != domain.TaskHistoryPatch || !patch.Before.IsActive || patch.After.IsActive {
+			t.Errorf("expected patch deactivating the task, got %+v", patch)
+		}
+
+		if patch.Actor != "alice" {
+			t.Errorf("expected actor alice, got %s", patch.Actor)
+		}
+
+		if store.Actor != domain.AnonymousActor {
+			t.Errorf("expected actor %s, got %s", domain.AnonymousActor, store.Actor)
+		}
+
+		if patch.RequestID == "" {
+			t.Errorf("expected request id, got empty")
+		}
+
+		if destroy.Operation != domain.TaskHistoryDestroy || destroy.Before.DeletedAt != 0 || destroy.After.DeletedAt == 0 {
+			t.Errorf("expected destroy trashing the task, got %+v", destroy)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		first, code := fetchHistory(t, "?limit=2")
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if len(first.Data) != 2 || first.NextCursor == "" || first.PrevCursor != "" {
+			t.Fatalf("expected 2 entries with a next cursor, got %+v", first)
+		}
+
+		second, code := fetchHistory(t, "?limit=2&cursor="+first.NextCursor)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if len(second.Data) != 1 || second.NextCursor != "" || second.PrevCursor == "" {
+			t.Fatalf("expected 1 entry with a prev cursor, got %+v", second)
+		}
+
+		if second.Data[0].Operation != domain.TaskHistoryDestroy {
+			t.Errorf("expected destroy, got %s", second.Data[0].Operation)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		res := v1TransportHTTP_Do(t, http.MethodGet, task.V1HTTPEndpoint+"foo/history")
+		if res.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", res.Code)
+		}
+	})
+
+	t.Run("unknown parameter", func(t *testing.T) {
+		if _, code := fetchHistory(t, "?sort=name"); code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", code)
+		}
+	})
+}
+
+func TestV1TransportHTTP_Replace(t *testing.T) {
+	do := func(t *testing.T, path string, header http.Header, body any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPut, path, &b)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		return res
+	}
+
+	id := "TestV1TransportHTTP_Replace"
+	name := "TestV1TransportHTTP_Replace"
+	active := false
+
+	t.Run("create", func(t *testing.T) {
+		res := do(t, task.V1HTTPEndpoint+id, nil, &domain.TaskReplaceRequest{Name: &name, IsActive: &active})
+		if res.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", res.Code)
+		}
+
+		var tr domain.TaskResponse
+		if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		if tr.ID != id || tr.Name != name || tr.IsActive || tr.Version != 1 {
+			t.Errorf("expected inactive %s at version 1, got %+v", id, tr)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		replacedName := name + "_Replaced"
+		replacedActive := true
+
+		res := do(t, task.V1HTTPEndpoint+id, http.Header{"If-Match": {`"1"`}}, &domain.TaskReplaceRequest{Name: &replacedName, IsActive: &replacedActive})
+		if res.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", res.Code)
+		}
+
+		var tr domain.TaskResponse
+		if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		if tr.Name != replacedName || !tr.IsActive || tr.Version != 2 {
+			t.Errorf("expected active %s at version 2, got %+v", replacedName, tr)
+		}
+
+		if got := res.Header().Get("ETag"); got != `"2"` {
+			t.Errorf(`expected ETag "2", got %s`, got)
+		}
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		res := do(t, task.V1HTTPEndpoint+id, nil, &domain.TaskReplaceRequest{Name: &name})
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", res.Code)
+		}
+	})
+
+	t.Run("precondition failed", func(t *testing.T) {
+		res := do(t, task.V1HTTPEndpoint+id, http.Header{"If-Match": {`"1"`}}, &domain.TaskReplaceRequest{Name: &name, IsActive: &active})
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected 412, got %d", res.Code)
+		}
+
+		res = do(t, task.V1HTTPEndpoint+id+"_Missing", http.Header{"If-Match": {`"1"`}}, &domain.TaskReplaceRequest{Name: &name, IsActive: &active})
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected 412 replacing a missing task conditionally, got %d", res.Code)
+		}
+
+		// "*" only matches an existing task, so it never creates one
+		res = do(t, task.V1HTTPEndpoint+id+"_Missing", http.Header{"If-Match": {"*"}}, &domain.TaskReplaceRequest{Name: &name, IsActive: &active})
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected 412 replacing a missing task with If-Match: *, got %d", res.Code)
+		}
+
+		replacedName := name + "_Replaced"
+		res = do(t, task.V1HTTPEndpoint+id, http.Header{"If-Match": {"*"}}, &domain.TaskReplaceRequest{Name: &replacedName, IsActive: &active})
+		if res.Code != http.StatusOK {
+			t.Errorf("expected 200 replacing an existing task with If-Match: *, got %d", res.Code)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		taken := name + "_Replaced"
+		res := do(t, task.V1HTTPEndpoint+id+"_Conflict", nil, &domain.TaskReplaceRequest{Name: &taken, IsActive: &active})
+		if res.Code != http.StatusConflict {
+			t.Errorf("expected 409, got %d", res.Code)
+		}
+	})
+}
+
+func TestV1TransportHTTP_Patch_Documents(t *testing.T) {
+	id := v1TransportHTTP_Store("TestV1TransportHTTP_Patch_Documents")(t)
+
+	do := func(t *testing.T, contentType, body string) (domain.TaskResponse, int) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPatch, task.V1HTTPEndpoint+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		var tr domain.TaskResponse
+		if res.Code == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}
+
+		return tr, res.Code
+	}
+
+	t.Run("merge patch", func(t *testing.T) {
+		tr, code := do(t, "application/merge-patch+json", `{"name":"TestV1TransportHTTP_Patch_Documents_Merge","is_active":false}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if tr.Name != "TestV1TransportHTTP_Patch_Documents_Merge" || tr.IsActive {
+			t.Errorf("expected merged task, got %+v", tr)
+		}
+
+		tr, code = do(t, "application/merge-patch+json", `{"is_active":true}`)
+		if code != http.StatusOK || !tr.IsActive {
+			t.Fatalf("expected active task, got %d %+v", code, tr)
+		}
+
+		tr, code = do(t, "application/merge-patch+json", `{"is_active":null}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if tr.IsActive {
+			t.Errorf("expected is_active cleared to false, got %v", tr.IsActive)
+		}
+	})
+
+	t.Run("merge patch invalid", func(t *testing.T) {
+		for _, body := range []string{`{"name":null}`, `{"version":42}`, `{"color":"red"}`, `{"name":1}`, `[]`} {
+			if _, code := do(t, "application/merge-patch+json", body); code != http.StatusUnprocessableEntity {
+				t.Errorf("expected 422 for %s, got %d", body, code)
+			}
+		}
+	})
+
+	t.Run("json patch", func(t *testing.T) {
+		tr, code := do(t, "application/json-patch+json", `[
+			{"op":"test","path":"/name","value":"TestV1TransportHTTP_Patch_Documents_Merge"},
+			{"op":"replace","path":"/name","value":"TestV1TransportHTTP_Patch_Documents_JSON"},
+			{"op":"add","path":"/is_active","value":true}
+		]`)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if tr.Name != "TestV1TransportHTTP_Patch_Documents_JSON" || !tr.IsActive {
+			t.Errorf("expected patched task, got %+v", tr)
+		}
+	})
+
+	t.Run("json patch test failed", func(t *testing.T) {
+		_, code := do(t, "application/json-patch+json", `[
+			{"op":"test","path":"/name","value":"TestV1TransportHTTP_Patch_Documents_Merge"},
+			{"op":"replace","path":"/name","value":"TestV1TransportHTTP_Patch_Documents_Stale"}
+		]`)
+		if code != http.StatusConflict {
+			t.Errorf("expected 409, got %d", code)
+		}
+	})
+
+	t.Run("json patch null value", func(t *testing.T) {
+		// null is a value to compare with, not a missing one
+		_, code := do(t, "application/json-patch+json", `[{"op":"test","path":"/name","value":null}]`)
+		if code != http.StatusConflict {
+			t.Errorf("expected 409, got %d", code)
+		}
+
+		if _, code := do(t, "application/json-patch+json", `[{"op":"replace","path":"/name","value":null}]`); code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", code)
+		}
+	})
+
+	t.Run("json patch invalid", func(t *testing.T) {
+		for _, body := range []string{`[{"op":"remove","path":"/name"}]`, `[{"op":"replace","path":"/id","value":"foo"}]`, `[{"op":"jump","path":"/name"}]`, `[{"op":"replace","path":"/name"}]`, `[{"op":"replace","path":"/color","value":"red"}]`} {
+			if _, code := do(t, "application/json-patch+json", body); code != http.StatusUnprocessableEntity {
+				t.Errorf("expected 422 for %s, got %d", body, code)
+			}
+		}
+	})
+
+	t.Run("unsupported media type", func(t *testing.T) {
+		if _, code := do(t, "text/plain", `name`); code != http.StatusUnsupportedMediaType {
+			t.Errorf("expected 415, got %d", code)
+		}
+	})
+}
+
+func TestV1TransportHTTP_Validation(t *testing.T) {
+	id := v1TransportHTTP_Store("TestV1TransportHTTP_Validation")(t)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		field  string
+	}{
+		{"store empty name", http.MethodPost, task.V1HTTPEndpoint, `{"name":""}`, "name"},
+		{"store untrimmed name", http.MethodPost, task.V1HTTPEndpoint, `{"name":" padded "}`, "name"},
+		{"store long name", http.MethodPost, task.V1HTTPEndpoint, `{"name":"` + strings.Repeat("a", domain.TaskNameMaxLength+1) + `"}`, "name"},
+		{"store control character", http.MethodPost, task.V1HTTPEndpoint, `{"name":"tab\tname"}`, "name"},
+		{"store unknown field", http.MethodPost, task.V1HTTPEndpoint, `{"name":"TestV1TransportHTTP_Validation_Unknown","color":"red"}`, "color"},
+		{"store mistyped field", http.MethodPost, task.V1HTTPEndpoint, `{"name":42}`, "name"},
+		{"patch empty name", http.MethodPatch, task.V1HTTPEndpoint + id, `{"name":""}`, "name"},
+		{"patch unknown field", http.MethodPatch, task.V1HTTPEndpoint + id, `{"done":true}`, "done"},
+		{"replace missing field", http.MethodPut, task.V1HTTPEndpoint + id, `{"name":"TestV1TransportHTTP_Validation"}`, "is_active"},
+		{"replace empty name", http.MethodPut, task.V1HTTPEndpoint + id, `{"name":"","is_active":true}`, "name"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			res := httptest.NewRecorder()
+
+			api.Route().ServeHTTP(res, req)
+
+			if res.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("expected 422, got %d", res.Code)
+			}
+
+			var p domain.ProblemResponse
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if len(p.Errors) != 1 || p.Errors[0].Field != c.field || p.Errors[0].Message == "" {
+				t.Errorf("expected a single error of field %s, got %+v", c.field, p.Errors)
+			}
+		})
+	}
+}
+
+func TestV1TransportHTTP_Batch(t *testing.T) {
+	id := v1TransportHTTP_Store("TestV1TransportHTTP_Batch")(t)
+
+	do := func(t *testing.T, body string) (domain.TaskBatchResponse, int) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, task.V1HTTPBatchEndpoint, strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		api.Route().ServeHTTP(res, req)
+
+		var br domain.TaskBatchResponse
+		if res.Code == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&br); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}
+
+		return br, res.Code
+	}
+
+	statuses := func(br domain.TaskBatchResponse) []int {
+		s := make([]int, len(br.Results))
+		for i, r := range br.Results {
+			s[i] = r.Status
+		}
+		return s
+	}
+
+	t.Run("all or nothing", func(t *testing.T) {
+		br, code := do(t, `{"operations":[
+			{"op":"create","name":"TestV1TransportHTTP_Batch_Create"},
+			{"op":"patch","id":"`+id+`","is_active":false,"version":1},
+			{"op":"delete","id":"`+id+`"}
+		]}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if fmt.Sprint(statuses(br)) != "[201 200 204]" || br.Succeeded != 3 || br.Failed != 0 {
+			t.Fatalf("expected [201 200 204], got %+v", statuses(br))
+		}
+
+		if br.Mode != domain.TaskBatchAllOrNothing || br.Results[0].Task.Name != "TestV1TransportHTTP_Batch_Create" || br.Results[1].Task.IsActive {
+			t.Errorf("expected created and patched tasks, got %+v", br)
+		}
+
+		if !v1TransportHTTP_InTrash(t, id) {
+			t.Errorf("expected %s in trash", id)
+		}
+	})
+
+	t.Run("all or nothing rolled back", func(t *testing.T) {
+		br, code := do(t, `{"mode":"all_or_nothing","operations":[
+			{"op":"create","name":"TestV1TransportHTTP_Batch_RolledBack"},
+			{"op":"delete","id":"foo"},
+			{"op":"create","name":"TestV1TransportHTTP_Batch_NeverRun"}
+		]}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if fmt.Sprint(statuses(br)) != "[424 404 424]" || br.Succeeded != 0 || br.Failed != 3 {
+			t.Fatalf("expected [424 404 424], got %+v", statuses(br))
+		}
+
+		page, _ := v1TransportHTTP_FetchPage(t, "name_prefix=TestV1TransportHTTP_Batch_")
+		for _, tr := range page.Data {
+			if tr.Name != "TestV1TransportHTTP_Batch_Create" {
+				t.Errorf("expected %s to be rolled back", tr.Name)
+			}
+		}
+	})
+
+	t.Run("all or nothing invalid", func(t *testing.T) {
+		br, code := do(t, `{"operations":[
+			{"op":"create","name":"TestV1TransportHTTP_Batch_Valid"},
+			{"op":"create","name":""}
+		]}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if fmt.Sprint(statuses(br)) != "[424 422]" {
+			t.Fatalf("expected [424 422], got %+v", statuses(br))
+		}
+
+		if errs := br.Results[1].Error.Errors; len(errs) != 1 || errs[0].Field != "name" {
+			t.Errorf("expected a name error, got %+v", errs)
+		}
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		br, code := do(t, `{"mode":"best_effort","operations":[
+			{"op":"create","name":"TestV1TransportHTTP_Batch_BestEffort","is_active":false},
+			{"op":"create","name":"TestV1TransportHTTP_Batch_BestEffort"},
+			{"op":"patch","id":"foo","name":"TestV1TransportHTTP_Batch_Missing"},
+			{"op":"delete"}
+		]}`)
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+
+		if fmt.Sprint(statuses(br)) != "[201 409 404 422]" || br.Succeeded != 1 || br.Failed != 3 {
+			t.Fatalf("expected [201 409 404 422], got %+v", statuses(br))
+		}
+
+		if br.Results[0].Task.IsActive {
+			t.Errorf("expected inactive task, got %+v", br.Results[0].Task)
+		}
+	})
+
+	t.Run("invalid batch", func(t *testing.T) {
+		for _, body := range []string{`{"mode":"sometimes","operations":[{"op":"delete","id":"foo"}]}`, `{"operations":[]}`, `{"operations":[{"op":"delete","id":"foo","extra":1}]}`} {
+			if _, code := do(t, body); code != http.StatusUnprocessableEntity {
+				t.Errorf("expected 422 for %s, got %d", body, code)
+			}
+		}
+	})
+
+	t.Run("reserved id", func(t *testing.T) {
+		if res := v1TransportHTTP_Do(t, http.MethodPut, task.V1HTTPBatchEndpoint); res.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected 405, got %d", res.Code)
+		}
+	})
+}
+
+func TestV1TransportHTTP_Idempotency(t *testing.T) {
+	do := func(t *testing.T, route http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, task.V1HTTPEndpoint, strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		res := httptest.NewRecorder()
+
+		route.ServeHTTP(res, req)
+
+		return res
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		first := do(t, api.Route(), "TestV1TransportHTTP_Idempotency_Replay", `{"name":"TestV1TransportHTTP_Idempotency"}`)
+		if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("expected a fresh 201, got %d", first.Code)
+		}
+
+		// the formatting of the body is not part of the request
+		retry := do(t, api.Route(), "TestV1TransportHTTP_Idempotency_Replay", `{ "name": "TestV1TransportHTTP_Idempotency" }`)
+		if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatalf("expected a replayed 201, got %d", retry.Code)
+		}
+
+		if retry.Body.String() != first.Body.String() || retry.Header().Get("ETag") != first.Header().Get("ETag") {
+			t.Errorf("expected the first response, got %s", retry.Body.String())
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		if res := do(t, api.Route(), "TestV1TransportHTTP_Idempotency_Mismatch", `{"name":"TestV1TransportHTTP_Idempotency_Mismatch"}`); res.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", res.Code)
+		}
+
+		res := do(t, api.Route(), "TestV1TransportHTTP_Idempotency_Mismatch", `{"name":"TestV1TransportHTTP_Idempotency_Other"}`)
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected 422, got %d", res.Code)
+		}
+
+		var p domain.ProblemResponse
+		if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		if p.Type != "urn:problem-type:idempotency-key-reused" {
+			t.Errorf("expected urn:problem-type:idempotency-key-reused, got %s", p.Type)
+		}
+	})
+
+	t.Run("client errors are replayed", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			res := do(t, api.Route(), "TestV1TransportHTTP_Idempotency_Invalid", `{"name":""}`)
+			if res.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("expected 422, got %d", res.Code)
+			}
+
+			if replayed := res.Header().Get("Idempotent-Replayed") == "true"; replayed != (i > 0) {
+				t.Errorf("expected only the retry to be replayed, got %v on attempt %d", replayed, i)
+			}
+		}
+	})
+
+	t.Run("server errors release the key", func(t *testing.T) {
+		route := task.New(task.WithIDGenerator(fixedID(""))).Transport.Route()
+
+		// a retry runs again instead of finding the request in progress
+		for i := 0; i < 2; i++ {
+			if res := do(t, route, "TestV1TransportHTTP_Idempotency_Failure", `{"name":"failure"}`); res.Code != http.StatusInternalServerError {
+				t.Fatalf("expected 500, got %d", res.Code)
+			}
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		if res := do(t, api.Route(), strings.Repeat("k", domain.IdempotencyKeyMaxLength+1), `{"name":"TestV1TransportHTTP_Idempotency_Key"}`); res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", res.Code)
+		}
+	})
+}
+
+func TestV1TransportHTTP_RequestID(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	for _, tc := range []struct {
+		name   string
+		header map[string]string
+		want   string
+	}{
+		{name: "incoming", header: map[string]string{"X-Request-ID": "client-id-1"}, want: "client-id-1"},
+		{name: "incoming over traceparent", header: map[string]string{"X-Request-ID": "client-id-2", "traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"}, want: "client-id-2"},
+		{name: "traceparent", header: map[string]string{"traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"}, want: traceID},
+		{name: "invalid traceparent", header: map[string]string{"traceparent": "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01"}},
+		{name: "invalid incoming", header: map[string]string{"X-Request-ID": "with spaces"}},
+		{name: "generated"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, task.V1HTTPEndpoint+"TestV1TransportHTTP_RequestID_Missing", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			res := httptest.NewRecorder()
+
+			api.Route().ServeHTTP(res, req)
+
+			id := res.Header().Get("X-Request-ID")
+			if tc.want != "" && id != tc.want {
+				t.Errorf("expected request id %s, got %s", tc.want, id)
+			}
+
+			if id == "" || id == tc.header["X-Request-ID"] && tc.want == "" {
+				t.Errorf("expected a generated request id, got %q", id)
+			}
+
+			var p domain.ProblemResponse
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if p.RequestID != id {
+				t.Errorf("expected the problem to carry request id %s, got %s", id, p.RequestID)
+			}
+		})
+	}
+
+	t.Run("idempotent replay", func(t *testing.T) {
+		ids := make([]string, 2)
+		for i := range ids {
+			req := httptest.NewRequest(http.MethodPost, task.V1HTTPEndpoint, strings.NewReader(`{"name":"TestV1TransportHTTP_RequestID"}`))
+			req.Header.Set("Idempotency-Key", "TestV1TransportHTTP_RequestID")
+			req.Header.Set("X-Request-ID", fmt.Sprint("replay-", i))
+			res := httptest.NewRecorder()
+
+			api.Route().ServeHTTP(res, req)
+
+			ids[i] = res.Header().Get("X-Request-ID")
+		}
+
+		if ids[0] != "replay-0" || ids[1] != "replay-1" {
+			t.Errorf("expected every response to carry its own request id, got %v", ids)
+		}
+	})
+}
+
+func TestV1TransportHTTP_MaxBodyBytes(t *testing.T) {
+	route := task.New(task.WithMaxBodyBytes(64)).Transport.Route()
+	large := `{"name":"` + strings.Repeat("a", 128) + `"}`
+
+	do := func(t *testing.T, method, path string, header http.Header, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res := httptest.NewRecorder()
+
+		route.ServeHTTP(res, req)
+
+		return res
+	}
+
+	res := do(t, http.MethodPost, task.V1HTTPEndpoint, nil, `{"name":"TestV1TransportHTTP_MaxBodyBytes"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected 201 within the limit, got %d", res.Code)
+	}
+
+	var created domain.TaskResponse
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		method string
+		path   string
+		header http.Header
+	}{
+		"store":       {http.MethodPost, task.V1HTTPEndpoint, nil},
+		"idempotent":  {http.MethodPost, task.V1HTTPEndpoint, http.Header{"Idempotency-Key": {"TestV1TransportHTTP_MaxBodyBytes"}}},
+		"merge patch": {http.MethodPatch, task.V1HTTPEndpoint + created.ID, http.Header{"Content-Type": {string(domain.TaskMergePatch)}}},
+		"replace":     {http.MethodPut, task.V1HTTPEndpoint + created.ID, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := do(t, tc.method, tc.path, tc.header, large)
+			if res.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("expected 413, got %d", res.Code)
+			}
+
+			var p domain.ProblemResponse
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if p.Type != "urn:problem-type:body-too-large" || res.Header().Get("Content-Type") != "application/problem+json" {
+				t.Errorf("expected a body-too-large problem, got %+v", p)
+			}
+		})
+	}
+}
@@ -3,16 +3,43 @@ package idutil
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"log"
+	"errors"
+	"io"
 )
 
-func MustGenerateID(n uint8) string {
-	b := make([]byte, n/2)
-	_, err := rand.Read(b)
+var (
+	// ErrExhausted is the error of a generator that ran out of ids for the current time.
+	ErrExhausted error = errors.New("id space exhausted")
 
-	if err != nil {
-		log.Fatal(err)
+	// ErrTimeOutOfRange is the error of a generator whose clock is outside of the range its ids can encode.
+	ErrTimeOutOfRange error = errors.New("time out of range of the id encoding")
+)
+
+// Random generates ids of random hex characters, which are not time-sortable.
+type Random struct {
+	length uint8
+	rand   io.Reader
+}
+
+// NewRandom returns a Random generator of ids of length hex characters.
+func NewRandom(length uint8) *Random {
+	return &Random{
+		length: length,
+		rand:   rand.Reader,
 	}
+}
+
+// Generate returns a new id.
+func (g *Random) Generate() (string, error) {
+	b := make([]byte, g.length/2)
+	if _, err := io.ReadFull(g.rand, b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
 
-	return hex.EncodeToString(b)
+// GenerateID returns an id of n random hex characters.
+func GenerateID(n uint8) (string, error) {
+	return NewRandom(n).Generate()
 }
@@ -0,0 +1,172 @@
+package idutil_test
+
+import (
+	"errors"
+	"github.com/anon-org/developing-api-services-with-golang/util/idutil"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// decodeDigits decodes s as a number written with the digits of alphabet.
+func decodeDigits(s, alphabet string) *big.Int {
+	n := new(big.Int)
+	base := big.NewInt(int64(len(alphabet)))
+	for _, r := range s {
+		n.Mul(n, base).Add(n, big.NewInt(int64(strings.IndexRune(alphabet, r))))
+	}
+
+	return n
+}
+
+func TestGenerators(t *testing.T) {
+	snowflake, err := idutil.NewSnowflake(42)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		gen     interface{ Generate() (string, error) }
+		pattern *regexp.Regexp
+		sorted  bool
+
+		// timestamp decodes the time an id was generated at, with its precision
+		timestamp func(id string) (time.Time, time.Duration)
+	}{
+		{
+			name:    "random",
+			gen:     idutil.NewRandom(24),
+			pattern: regexp.MustCompile(`^[0-9a-f]{24}$`),
+		},
+		{
+			name:    "ulid",
+			gen:     idutil.NewULID(),
+			pattern: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+			sorted:  true,
+			timestamp: func(id string) (time.Time, time.Duration) {
+				ms := decodeDigits(id[:10], "0123456789ABCDEFGHJKMNPQRSTVWXYZ")
+				return time.UnixMilli(ms.Int64()), time.Millisecond
+			},
+		},
+		{
+			name:    "uuidv7",
+			gen:     idutil.NewUUIDv7(),
+			pattern: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+			sorted:  true,
+			timestamp: func(id string) (time.Time, time.Duration) {
+				ms, _ := strconv.ParseInt(strings.ReplaceAll(id[:13], "-", ""), 16, 64)
+				return time.UnixMilli(ms), time.Millisecond
+			},
+		},
+		{
+			name:    "ksuid",
+			gen:     idutil.NewKSUID(),
+			pattern: regexp.MustCompile(`^[0-9A-Za-z]{27}$`),
+			timestamp: func(id string) (time.Time, time.Duration) {
+				n := decodeDigits(id, "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+				return time.Unix(new(big.Int).Rsh(n, 128).Int64()+1400000000, 0), time.Second
+			},
+		},
+		{
+			name:    "snowflake",
+			gen:     snowflake,
+			pattern: regexp.MustCompile(`^[0-9]{19}$`),
+			sorted:  true,
+			timestamp: func(id string) (time.Time, time.Duration) {
+				n, _ := strconv.ParseInt(id, 10, 64)
+				if node := n >> 12 & idutil.SnowflakeMaxNode; node != 42 {
+					t.Errorf("expected node 42, got %d", node)
+				}
+				return idutil.SnowflakeEpoch.Add(time.Duration(n>>22) * time.Millisecond), time.Millisecond
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := time.Now()
+
+			ids := make([]string, 10000)
+			for i := range ids {
+				id, err := tc.gen.Generate()
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				ids[i] = id
+			}
+
+			after := time.Now()
+
+			seen := make(map[string]struct{}, len(ids))
+			for i, id := range ids {
+				if !tc.pattern.MatchString(id) {
+					t.Fatalf("expected %s to match %s", id, tc.pattern)
+				}
+
+				if _, ok := seen[id]; ok {
+					t.Fatalf("expected unique ids, got %s twice", id)
+				}
+				seen[id] = struct{}{}
+
+				if tc.sorted && i > 0 && id <= ids[i-1] {
+					t.Fatalf("expected increasing ids, got %s after %s", id, ids[i-1])
+				}
+			}
+
+			if tc.timestamp != nil {
+				// the last id may be ahead of the clock when a millisecond ran out of ids
+				for _, id := range []string{ids[0], ids[len(ids)-1]} {
+					ts, precision := tc.timestamp(id)
+					if ts.Before(before.Truncate(precision)) || ts.After(after.Add(precision)) {
+						t.Errorf("expected %s to be generated between %v and %v, got %v", id, before, after, ts)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerators_Concurrent(t *testing.T) {
+	gen := idutil.NewULID()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		seen = make(map[string]struct{})
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				id, err := gen.Generate()
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+					return
+				}
+
+				mu.Lock()
+				if _, ok := seen[id]; ok {
+					t.Errorf("expected unique ids, got %s twice", id)
+				}
+				seen[id] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestNewSnowflake_InvalidNode(t *testing.T) {
+	for _, node := range []int64{-1, idutil.SnowflakeMaxNode + 1} {
+		if _, err := idutil.NewSnowflake(node); !errors.Is(err, idutil.ErrInvalidNode) {
+			t.Errorf("expected %v for node %d, got %v", idutil.ErrInvalidNode, node, err)
+		}
+	}
+}
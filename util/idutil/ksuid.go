@@ -0,0 +1,69 @@
+package idutil
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	// ksuidEpoch is the unix time of the zero KSUID timestamp.
+	ksuidEpoch int64 = 1400000000
+
+	// base62Alphabet is the base62 alphabet of KSUIDs, its characters sort in the order of their values.
+	base62Alphabet string = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	ksuidLength int = 27
+)
+
+// KSUID generates K-Sortable Unique IDentifiers: 27 base62 characters of a 32-bit second timestamp
+// followed by 128 random bits. Ids of the same second are not ordered.
+type KSUID struct {
+	now  func() time.Time
+	rand io.Reader
+}
+
+// NewKSUID returns a KSUID generator.
+func NewKSUID() *KSUID {
+	return &KSUID{
+		now:  time.Now,
+		rand: rand.Reader,
+	}
+}
+
+// Generate returns a new id.
+func (g *KSUID) Generate() (string, error) {
+	ts := g.now().Unix() - ksuidEpoch
+	if ts < 0 || ts >= 1<<32 {
+		return "", ErrTimeOutOfRange
+	}
+
+	var b [20]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(ts))
+	if _, err := io.ReadFull(g.rand, b[4:]); err != nil {
+		return "", err
+	}
+
+	return encodeBase62(b[:], ksuidLength), nil
+}
+
+// encodeBase62 encodes the big-endian number b as length base62 characters, padded with leading zeros.
+func encodeBase62(b []byte, length int) string {
+	n := append([]byte(nil), b...)
+	out := make([]byte, length)
+
+	for j := length - 1; j >= 0; j-- {
+		// divide n by 62 in place, the remainder is the next digit
+		var rem uint
+		for i := range n {
+			acc := rem<<8 | uint(n[i])
+			n[i] = byte(acc / 62)
+			rem = acc % 62
+		}
+
+		out[j] = base62Alphabet[rem]
+	}
+
+	return string(out)
+}
@@ -0,0 +1,79 @@
+package idutil
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+
+	// SnowflakeMaxNode is the highest node id of a Snowflake generator.
+	SnowflakeMaxNode int64 = 1<<snowflakeNodeBits - 1
+)
+
+var (
+	// ErrInvalidNode is the error of a Snowflake node id outside of 0 to SnowflakeMaxNode.
+	ErrInvalidNode error = errors.New("invalid snowflake node id")
+
+	// SnowflakeEpoch is the time of the zero Snowflake timestamp.
+	SnowflakeEpoch time.Time = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Snowflake generates Snowflake-style ids: a 63-bit number of a 41-bit millisecond timestamp since SnowflakeEpoch,
+// a 10-bit node id and a 12-bit sequence, formatted as 19 zero-padded digits so that they sort as text.
+// Every node generating ids concurrently needs a distinct node id. The ids of a generator are strictly increasing,
+// when the sequence of a millisecond runs out the next millisecond is used ahead of the clock.
+type Snowflake struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	node     int64
+	lastMs   int64
+	sequence int64
+}
+
+// NewSnowflake returns a Snowflake generator of the node id node.
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > SnowflakeMaxNode {
+		return nil, fmt.Errorf("%w: %d is not between 0 and %d", ErrInvalidNode, node, SnowflakeMaxNode)
+	}
+
+	return &Snowflake{
+		now:    time.Now,
+		node:   node,
+		lastMs: -1,
+	}, nil
+}
+
+// Generate returns a new id.
+func (g *Snowflake) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(SnowflakeEpoch).Milliseconds()
+	if ms < 0 {
+		return "", ErrTimeOutOfRange
+	}
+
+	// a clock moving backwards continues from the last millisecond to keep the ids increasing
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		g.sequence = (g.sequence + 1) & (1<<snowflakeSequenceBits - 1)
+		if g.sequence == 0 {
+			ms++
+		}
+	} else {
+		g.sequence = 0
+	}
+
+	if ms >= 1<<41 {
+		return "", ErrTimeOutOfRange
+	}
+	g.lastMs = ms
+
+	id := ms<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence
+	return fmt.Sprintf("%019d", id), nil
+}
@@ -0,0 +1,94 @@
+package idutil
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+)
+
+// crockfordAlphabet is the Crockford base32 alphabet of ULIDs, its characters sort in the order of their values.
+const crockfordAlphabet string = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates Universally Unique Lexicographically Sortable Identifiers: 26 Crockford base32 characters
+// of a 48-bit millisecond timestamp followed by 80 random bits.
+// The ids of a generator are strictly increasing, ids of the same millisecond increment the random bits of the previous one.
+type ULID struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	rand   io.Reader
+	lastMs uint64
+	last   [10]byte
+}
+
+// NewULID returns a ULID generator.
+func NewULID() *ULID {
+	return &ULID{
+		now:  time.Now,
+		rand: rand.Reader,
+	}
+}
+
+// Generate returns a new id.
+func (g *ULID) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(g.now().UnixMilli())
+	if ms >= 1<<48 {
+		return "", ErrTimeOutOfRange
+	}
+
+	// a clock moving backwards continues from the last millisecond to keep the ids increasing
+	if ms <= g.lastMs && g.lastMs != 0 {
+		ms = g.lastMs
+		if !increment(g.last[:]) {
+			return "", ErrExhausted
+		}
+	} else if _, err := io.ReadFull(g.rand, g.last[:]); err != nil {
+		return "", err
+	}
+	g.lastMs = ms
+
+	var b [16]byte
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(b[6:], g.last[:])
+
+	return encodeCrockford(b), nil
+}
+
+// increment adds one to the big-endian number b, it reports false when b overflows.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encodeCrockford encodes the 128 bits of b as 26 Crockford base32 characters, the first one holds the 3 leading bits.
+func encodeCrockford(b [16]byte) string {
+	var out [26]byte
+	var acc uint16
+	bits := 0
+
+	// the 2 padding bits making 130 bits are leading zeros, so the bits are taken from the end
+	for i, j := len(b)-1, len(out)-1; j >= 0; j-- {
+		for bits < 5 && i >= 0 {
+			acc |= uint16(b[i]) << bits
+			bits += 8
+			i--
+		}
+
+		out[j] = crockfordAlphabet[acc&31]
+		acc >>= 5
+		bits -= 5
+	}
+
+	return string(out[:])
+}
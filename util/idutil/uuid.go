@@ -0,0 +1,70 @@
+package idutil
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+)
+
+// UUIDv7 generates RFC 9562 version 7 UUIDs: a 48-bit millisecond timestamp followed by 74 random bits.
+// The ids of a generator are strictly increasing, ids of the same millisecond increment the random bits of the previous one.
+type UUIDv7 struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	rand   io.Reader
+	lastMs uint64
+
+	// randA and randB are the 12 and 62 random bits around the version and variant bits.
+	randA uint16
+	randB uint64
+}
+
+// NewUUIDv7 returns a UUIDv7 generator.
+func NewUUIDv7() *UUIDv7 {
+	return &UUIDv7{
+		now:  time.Now,
+		rand: rand.Reader,
+	}
+}
+
+// Generate returns a new id.
+func (g *UUIDv7) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(g.now().UnixMilli())
+	if ms >= 1<<48 {
+		return "", ErrTimeOutOfRange
+	}
+
+	// a clock moving backwards continues from the last millisecond to keep the ids increasing
+	if ms <= g.lastMs && g.lastMs != 0 {
+		ms = g.lastMs
+		g.randB = (g.randB + 1) & (1<<62 - 1)
+		if g.randB == 0 {
+			g.randA = (g.randA + 1) & (1<<12 - 1)
+			if g.randA == 0 {
+				return "", ErrExhausted
+			}
+		}
+	} else {
+		var r [10]byte
+		if _, err := io.ReadFull(g.rand, r[:]); err != nil {
+			return "", err
+		}
+
+		g.randA = binary.BigEndian.Uint16(r[:2]) & (1<<12 - 1)
+		g.randB = binary.BigEndian.Uint64(r[2:]) & (1<<62 - 1)
+	}
+	g.lastMs = ms
+
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], ms<<16|0x7<<12|uint64(g.randA))
+	binary.BigEndian.PutUint64(b[8:], 0x2<<62|g.randB)
+
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32], nil
+}
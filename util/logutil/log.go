@@ -4,40 +4,147 @@ import (
 	"context"
 	"fmt"
 	"github.com/anon-org/developing-api-services-with-golang/util/idutil"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
 )
 
 const (
-	defaultPrefix string = "[MAIN] "
-	defaultFlag   int    = log.LstdFlags | log.Lshortfile | log.Lmsgprefix
+	defaultIDLen uint8 = 8
+
+	// RequestIDKey is the key of the attribute correlating the records of a single request.
+	RequestIDKey string = "request_id"
+)
+
+// Format is the encoding of log records.
+type Format string
+
+const (
+	// FormatText encodes records as logfmt-style key=value pairs.
+	FormatText Format = "text"
+
+	// FormatJSON encodes records as JSON objects, one per line.
+	FormatJSON Format = "json"
 )
 
+// Config configures NewLogger. Its zero value writes text records of level info and above to stdout.
+type Config struct {
+	Output    io.Writer
+	Level     slog.Leveler
+	Format    Format
+	AddSource bool
+}
+
 type ctxLogger struct{}
 
+type ctxID struct{}
+
 var (
-	ctxLoggerKey      *ctxLogger  = &ctxLogger{}
-	stdLoggerInstance *log.Logger = log.New(os.Stdout, defaultPrefix, defaultFlag)
+	ctxLoggerKey      *ctxLogger = &ctxLogger{}
+	ctxIDKey          *ctxID     = &ctxID{}
+	stdLoggerInstance atomic.Pointer[slog.Logger]
 )
 
-func NewStdLogger() *log.Logger {
-	return stdLoggerInstance
+func init() {
+	stdLoggerInstance.Store(NewLogger(Config{}))
+}
+
+// NewLogger returns a structured logger configured by c.
+func NewLogger(c Config) *slog.Logger {
+	if c.Output == nil {
+		c.Output = os.Stdout
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:     c.Level,
+		AddSource: c.AddSource,
+	}
+
+	if c.Format == FormatJSON {
+		return slog.New(slog.NewJSONHandler(c.Output, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(c.Output, opts))
+}
+
+// ParseLevel parses one of debug, info, warn and error, optionally with an offset such as warn+2.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level: %q", s)
+	}
+
+	return level, nil
+}
+
+// ParseFormat parses text or json.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid log format: %q", s)
+	}
+}
+
+// NewStdLogger returns the process-wide logger, see SetStdLogger.
+func NewStdLogger() *slog.Logger {
+	return stdLoggerInstance.Load()
+}
+
+// SetStdLogger replaces the process-wide logger, which is also used by contexts that carry no logger.
+func SetStdLogger(logger *slog.Logger) {
+	stdLoggerInstance.Store(logger)
+}
+
+// NewCtxLogger returns a logger recording a new id with every record, or the process-wide logger when no id can be
+// generated.
+func NewCtxLogger() *slog.Logger {
+	id, err := NewID()
+	if err != nil {
+		return NewStdLogger()
+	}
+
+	return NewCtxLoggerWithID(id)
+}
+
+// NewCtxLoggerWithID returns a logger recording the given id with every record.
+func NewCtxLoggerWithID(id string) *slog.Logger {
+	return NewChildLogger(NewStdLogger(), id)
+}
+
+// NewChildLogger returns a logger writing through parent, recording the given id with every record.
+func NewChildLogger(parent *slog.Logger, id string) *slog.Logger {
+	return parent.With(RequestIDKey, id)
 }
 
-func NewCtxLogger() *log.Logger {
-	id := idutil.MustGenerateID(8)
-	prefix := fmt.Sprintf("[%s] ", id)
-	return log.New(os.Stdout, prefix, defaultFlag)
+// NewID generates an id suitable for correlating log lines of a single request.
+// It fails when the source of randomness does, callers must not depend on getting one.
+func NewID() (string, error) {
+	return idutil.GenerateID(defaultIDLen)
 }
 
-func PutCtxLogger(ctx context.Context, logger *log.Logger) context.Context {
+func PutCtxLogger(ctx context.Context, logger *slog.Logger) context.Context {
 	return context.WithValue(ctx, ctxLoggerKey, logger)
 }
 
-func GetCtxLogger(ctx context.Context) *log.Logger {
-	logger, ok := ctx.Value(ctxLoggerKey).(*log.Logger)
+func GetCtxLogger(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(ctxLoggerKey).(*slog.Logger)
 	if !ok {
 		logger = NewCtxLogger()
 	}
 	return logger
 }
+
+// PutCtxID stores the correlation id of the current request in ctx.
+func PutCtxID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxIDKey, id)
+}
+
+// GetCtxID returns the correlation id stored in ctx, or an empty string.
+func GetCtxID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxIDKey).(string)
+	return id
+}
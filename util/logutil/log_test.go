@@ -0,0 +1,85 @@
+package logutil_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger := logutil.NewLogger(logutil.Config{Output: &b, Level: slog.LevelWarn, Format: logutil.FormatJSON})
+
+	logutil.NewChildLogger(logger, "abc").Info("filtered out")
+	logutil.NewChildLogger(logger, "abc").Warn("kept", "task_id", "42")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 record, got %d: %s", len(lines), b.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for key, want := range map[string]string{"level": "WARN", "msg": "kept", "task_id": "42", logutil.RequestIDKey: "abc"} {
+		if record[key] != want {
+			t.Errorf("expected %s to be %s, got %v", key, want, record[key])
+		}
+	}
+}
+
+func TestNewLogger_Text(t *testing.T) {
+	var b bytes.Buffer
+	logutil.NewLogger(logutil.Config{Output: &b}).Info("served", "status", 200)
+
+	if out := b.String(); !strings.Contains(out, "level=INFO") || !strings.Contains(out, "msg=served") || !strings.Contains(out, "status=200") {
+		t.Errorf("expected a text record, got %s", out)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if level, err := logutil.ParseLevel("debug"); err != nil || level != slog.LevelDebug {
+		t.Errorf("expected %v, got %v %v", slog.LevelDebug, level, err)
+	}
+
+	if _, err := logutil.ParseLevel("verbose"); err == nil {
+		t.Errorf("expected an error, got none")
+	}
+
+	if format, err := logutil.ParseFormat("JSON"); err != nil || format != logutil.FormatJSON {
+		t.Errorf("expected %v, got %v %v", logutil.FormatJSON, format, err)
+	}
+
+	if _, err := logutil.ParseFormat("xml"); err == nil {
+		t.Errorf("expected an error, got none")
+	}
+}
+
+func TestCtxLogger(t *testing.T) {
+	logger := logutil.NewLogger(logutil.Config{})
+	if got := logutil.GetCtxLogger(logutil.PutCtxLogger(context.Background(), logger)); got != logger {
+		t.Errorf("expected the logger stored in the context")
+	}
+
+	if got := logutil.GetCtxLogger(context.Background()); got == nil {
+		t.Errorf("expected a fallback logger, got nil")
+	}
+}
+
+func TestNewID(t *testing.T) {
+	a, err := logutil.NewID()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	b, _ := logutil.NewID()
+	if len(a) != 8 || a == b {
+		t.Errorf("expected two distinct ids of 8 characters, got %q and %q", a, b)
+	}
+}
@@ -0,0 +1,418 @@
+package metricutil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// ContentType is the media type of the Prometheus text exposition format.
+	ContentType string = "text/plain; version=0.0.4; charset=utf-8"
+
+	typeCounter   string = "counter"
+	typeGauge     string = "gauge"
+	typeHistogram string = "histogram"
+
+	// labelSeparator joins the label values of a series into its key, it cannot appear in valid UTF-8.
+	labelSeparator string = "\xff"
+)
+
+var (
+	// DefaultBuckets are the upper bounds of histogram buckets suited to latencies in seconds.
+	DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type (
+	// Registry holds metrics and writes them in the Prometheus text exposition format.
+	// Registering a metric again with the same type and labels returns the registered one, so that
+	// independent components can share a Registry.
+	Registry struct {
+		mu      sync.Mutex
+		metrics map[string]metric
+	}
+
+	metric interface {
+		describe() *desc
+		write(w *bufio.Writer)
+	}
+
+	desc struct {
+		name   string
+		help   string
+		typ    string
+		labels []string
+	}
+
+	// CounterVec is a family of counters partitioned by label values.
+	CounterVec struct {
+		*desc
+		mu     sync.Mutex
+		series map[string]*Counter
+	}
+
+	// Counter is a value that only goes up.
+	Counter struct {
+		values []string
+		bits   atomic.Uint64
+	}
+
+	// HistogramVec is a family of histograms partitioned by label values.
+	HistogramVec struct {
+		*desc
+		buckets []float64
+		mu      sync.Mutex
+		series  map[string]*Histogram
+	}
+
+	// Histogram counts observations in buckets of configurable upper bounds.
+	Histogram struct {
+		values  []string
+		buckets []float64
+		mu      sync.Mutex
+		counts  []uint64
+		sum     float64
+		count   uint64
+	}
+
+	// funcMetric is a single unlabeled value read when the registry is written.
+	funcMetric struct {
+		*desc
+		fn func() float64
+	}
+)
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// NewCounterVec registers a counter family, it panics when name or labels are invalid or name is registered differently.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	d := &desc{name: name, help: help, typ: typeCounter, labels: labels}
+	return r.register(d, func() metric {
+		return &CounterVec{desc: d, series: make(map[string]*Counter)}
+	}).(*CounterVec)
+}
+
+// NewHistogramVec registers a histogram family with the given bucket upper bounds, DefaultBuckets when nil.
+// It panics when name or labels are invalid or name is registered differently.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metricutil: buckets of %s are not sorted", name))
+	}
+
+	for _, l := range labels {
+		if l == "le" {
+			panic(fmt.Sprintf("metricutil: label le of %s is reserved", name))
+		}
+	}
+
+	d := &desc{name: name, help: help, typ: typeHistogram, labels: labels}
+	return r.register(d, func() metric {
+		return &HistogramVec{desc: d, buckets: buckets, series: make(map[string]*Histogram)}
+	}).(*HistogramVec)
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn whenever the registry is written.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	d := &desc{name: name, help: help, typ: typeGauge}
+	r.register(d, func() metric { return &funcMetric{desc: d, fn: fn} })
+}
+
+// NewCounterFunc registers a counter whose value is read from fn whenever the registry is written.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	d := &desc{name: name, help: help, typ: typeCounter}
+	r.register(d, func() metric { return &funcMetric{desc: d, fn: fn} })
+}
+
+func (r *Registry) register(d *desc, create func() metric) metric {
+	if !metricNamePattern.MatchString(d.name) {
+		panic(fmt.Sprintf("metricutil: invalid metric name %q", d.name))
+	}
+
+	for _, l := range d.labels {
+		if !labelNamePattern.MatchString(l) || strings.HasPrefix(l, "__") {
+			panic(fmt.Sprintf("metricutil: invalid label name %q of %s", l, d.name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[d.name]; ok {
+		// func metrics read a value they own, another one cannot share the name
+		if _, isFunc := m.(*funcMetric); isFunc || !m.describe().equal(d) {
+			panic(fmt.Sprintf("metricutil: metric %s is already registered", d.name))
+		}
+		return m
+	}
+
+	m := create()
+	r.metrics[d.name] = m
+
+	return m
+}
+
+// WriteTo writes every metric of the registry to w, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].describe().name < metrics[j].describe().name })
+
+	var b bytes.Buffer
+	bw := bufio.NewWriter(&b)
+	for _, m := range metrics {
+		d := m.describe()
+		fmt.Fprintf(bw, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.typ)
+		m.write(bw)
+	}
+	bw.Flush()
+
+	return b.WriteTo(w)
+}
+
+// Handler returns a handler serving the registry in the Prometheus text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType)
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		r.WriteTo(w)
+	})
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+func (d *desc) equal(o *desc) bool {
+	if d.typ != o.typ || len(d.labels) != len(o.labels) {
+		return false
+	}
+
+	for i := range d.labels {
+		if d.labels[i] != o.labels[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metricutil: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, labelSeparator)
+}
+
+// With returns the counter of the given label values, in the order of the labels of the family.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := v.key(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.series[key]
+	if !ok {
+		c = &Counter{values: append([]string(nil), values...)}
+		v.series[key] = c
+	}
+
+	return c
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	series := make([]*Counter, 0, len(v.series))
+	for _, c := range v.series {
+		series = append(series, c)
+	}
+	v.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool { return lessValues(series[i].values, series[j].values) })
+
+	for _, c := range series {
+		writeSample(w, v.name, v.labels, c.values, "", "", c.Value())
+	}
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by delta, it panics when delta is negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metricutil: counter cannot decrease")
+	}
+
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// With returns the histogram of the given label values, in the order of the labels of the family.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := v.key(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	h, ok := v.series[key]
+	if !ok {
+		h = &Histogram{
+			values:  append([]string(nil), values...),
+			buckets: v.buckets,
+			counts:  make([]uint64, len(v.buckets)),
+		}
+		v.series[key] = h
+	}
+
+	return h
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	series := make([]*Histogram, 0, len(v.series))
+	for _, h := range v.series {
+		series = append(series, h)
+	}
+	v.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool { return lessValues(series[i].values, series[j].values) })
+
+	for _, h := range series {
+		h.mu.Lock()
+		counts, sum, count := append([]uint64(nil), h.counts...), h.sum, h.count
+		h.mu.Unlock()
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, v.name+"_bucket", v.labels, h.values, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, v.name+"_bucket", v.labels, h.values, "le", "+Inf", float64(count))
+		writeSample(w, v.name+"_sum", v.labels, h.values, "", "", sum)
+		writeSample(w, v.name+"_count", v.labels, h.values, "", "", float64(count))
+	}
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// an observation above the last bucket is only counted in +Inf
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeSample(w, f.name, nil, nil, "", "", f.fn())
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabelValue(values[i]))
+		}
+
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func lessValues(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+
+	return false
+}
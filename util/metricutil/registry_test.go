@@ -0,0 +1,143 @@
+package metricutil_test
+
+import (
+	"bytes"
+	"database/sql"
+	"github.com/anon-org/developing-api-services-with-golang/util/metricutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := metricutil.NewRegistry()
+
+	requests := r.NewCounterVec("requests_total", "Total requests.\nBy path.", "path", "code")
+	requests.With("/b", "200").Inc()
+	requests.With("/a", "500").Add(2)
+	requests.With("/a", "500").Inc()
+	requests.With(`/"q"\`, "200").Inc()
+
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "path")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.With("/a").Observe(v)
+	}
+
+	r.NewGaugeFunc("up", "Whether the target is up.", func() float64 { return 1 })
+
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// metrics are sorted by name and series by label values
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a",le="0.1"} 2
+latency_seconds_bucket{path="/a",le="1"} 3
+latency_seconds_bucket{path="/a",le="+Inf"} 4
+latency_seconds_sum{path="/a"} 3.65
+latency_seconds_count{path="/a"} 4
+# HELP requests_total Total requests.\nBy path.
+# TYPE requests_total counter
+requests_total{path="/\"q\"\\",code="200"} 1
+requests_total{path="/a",code="500"} 3
+requests_total{path="/b",code="200"} 1
+# HELP up Whether the target is up.
+# TYPE up gauge
+up 1
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := metricutil.NewRegistry()
+
+	// registering the same metric again shares it
+	r.NewCounterVec("shared_total", "Shared.", "kind").With("a").Inc()
+	if c := r.NewCounterVec("shared_total", "Shared.", "kind").With("a"); c.Value() != 1 {
+		t.Errorf("expected the registered counter, got value %v", c.Value())
+	}
+
+	for name, register := range map[string]func(){
+		"different labels": func() { r.NewCounterVec("shared_total", "Shared.", "other") },
+		"different type":   func() { r.NewHistogramVec("shared_total", "Shared.", nil, "kind") },
+		"invalid name":     func() { r.NewCounterVec("invalid-name", "Invalid.") },
+		"reserved label":   func() { r.NewHistogramVec("reserved", "Reserved.", nil, "le") },
+		"label values":     func() { r.NewCounterVec("shared_total", "Shared.", "kind").With("a", "b") },
+		"func twice": func() {
+			r.NewGaugeFunc("func", "Func.", func() float64 { return 0 })
+			r.NewGaugeFunc("func", "Func.", func() float64 { return 0 })
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic")
+				}
+			}()
+
+			register()
+		})
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := metricutil.NewRegistry()
+	r.NewCounterVec("hits_total", "Hits.").With().Inc()
+
+	res := httptest.NewRecorder()
+	r.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != metricutil.ContentType {
+		t.Errorf("expected 200 %s, got %d %s", metricutil.ContentType, res.Code, res.Header().Get("Content-Type"))
+	}
+
+	if !strings.Contains(res.Body.String(), "\nhits_total 1\n") {
+		t.Errorf("expected the counter to be served, got %s", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	r.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", res.Code)
+	}
+}
+
+func TestRegisterDBStats(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(3)
+	if err := db.Ping(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	r := metricutil.NewRegistry()
+	metricutil.RegisterDBStats(r, db)
+
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, sample := range []string{
+		"\nsql_db_max_open_connections 3\n",
+		"\nsql_db_open_connections 1\n",
+		"\nsql_db_idle_connections 1\n",
+		"\nsql_db_in_use_connections 0\n",
+		"# TYPE sql_db_wait_count_total counter\n",
+	} {
+		if !strings.Contains(b.String(), sample) {
+			t.Errorf("expected %q, got\n%s", sample, b.String())
+		}
+	}
+}
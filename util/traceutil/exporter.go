@@ -0,0 +1,306 @@
+package traceutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// scopeName is the instrumentation scope of the exported spans.
+	scopeName string = "github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+)
+
+type (
+	// StdoutExporter writes every span as a JSON object on its own line.
+	StdoutExporter struct {
+		mu  sync.Mutex
+		enc *json.Encoder
+	}
+
+	// OTLPConfig configures NewOTLPExporter.
+	OTLPConfig struct {
+		// Endpoint is the URL spans are posted to, such as http://localhost:4318/v1/traces.
+		Endpoint string
+
+		// ServiceName is the service.name resource attribute of the exported spans.
+		ServiceName string
+
+		// Header is sent with every request, for instance to authenticate with the collector.
+		Header http.Header
+
+		// Client sends the requests, it defaults to http.DefaultClient.
+		Client *http.Client
+	}
+
+	// OTLPExporter posts spans to a collector with the OTLP/HTTP protocol, encoded as JSON.
+	OTLPExporter struct {
+		config OTLPConfig
+	}
+
+	stdoutSpan struct {
+		Name          string         `json:"name"`
+		Kind          string         `json:"kind"`
+		TraceID       string         `json:"trace_id"`
+		SpanID        string         `json:"span_id"`
+		ParentSpanID  string         `json:"parent_span_id,omitempty"`
+		Start         time.Time      `json:"start"`
+		End           time.Time      `json:"end"`
+		Duration      string         `json:"duration"`
+		Attributes    map[string]any `json:"attributes,omitempty"`
+		Events        []stdoutEvent  `json:"events,omitempty"`
+		Status        string         `json:"status"`
+		StatusMessage string         `json:"status_message,omitempty"`
+	}
+
+	stdoutEvent struct {
+		Name       string         `json:"name"`
+		Time       time.Time      `json:"time"`
+		Attributes map[string]any `json:"attributes,omitempty"`
+	}
+
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		TraceState        string          `json:"traceState,omitempty"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Events            []otlpEvent     `json:"events,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpEvent struct {
+		TimeUnixNano string          `json:"timeUnixNano"`
+		Name         string          `json:"name"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    StatusCode `json:"code,omitempty"`
+		Message string     `json:"message,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	// otlpValue is an AnyValue of the OTLP protocol, 64-bit integers are encoded as strings in its JSON mapping.
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+// NewStdoutExporter returns a StdoutExporter writing to w.
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Export writes spans to the writer of e.
+func (e *StdoutExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, s := range spans {
+		out := stdoutSpan{
+			Name:          s.Name,
+			Kind:          s.Kind.String(),
+			TraceID:       s.SpanContext.TraceID.String(),
+			SpanID:        s.SpanContext.SpanID.String(),
+			Start:         s.Start,
+			End:           s.End,
+			Duration:      s.End.Sub(s.Start).String(),
+			Attributes:    attributeMap(s.Attributes),
+			Status:        s.StatusCode.String(),
+			StatusMessage: s.StatusMessage,
+		}
+
+		if s.Parent.IsValid() {
+			out.ParentSpanID = s.Parent.String()
+		}
+
+		for _, ev := range s.Events {
+			out.Events = append(out.Events, stdoutEvent{Name: ev.Name, Time: ev.Time, Attributes: attributeMap(ev.Attributes)})
+		}
+
+		if err := e.enc.Encode(out); err != nil {
+			return fmt.Errorf("%w: failed to write span", err)
+		}
+	}
+
+	return nil
+}
+
+// NewOTLPExporter returns an OTLPExporter configured by c.
+func NewOTLPExporter(c OTLPConfig) *OTLPExporter {
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+
+	return &OTLPExporter{
+		config: c,
+	}
+}
+
+// Export posts spans to the collector of e in a single request.
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: scopeName}}
+	for _, s := range spans {
+		out := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			TraceState:        s.SpanContext.TraceState,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.StatusCode, Message: s.StatusMessage},
+		}
+
+		if s.Parent.IsValid() {
+			out.ParentSpanID = s.Parent.String()
+		}
+
+		for _, ev := range s.Events {
+			out.Events = append(out.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+				Name:         ev.Name,
+				Attributes:   otlpAttributes(ev.Attributes),
+			})
+		}
+
+		scope.Spans = append(scope.Spans, out)
+	}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{String("service.name", e.config.ServiceName)})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return fmt.Errorf("%w: failed to encode spans", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: failed to create export request", err)
+	}
+
+	for k, v := range e.config.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.config.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to export spans", err)
+	}
+	defer res.Body.Close()
+
+	// the body of a partial success or an error is only informative, but must be read to reuse the connection
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("failed to export spans: collector responded %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// String returns the name of the span kind.
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// String returns the name of the status code.
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	default:
+		return "unset"
+	}
+}
+
+func attributeMap(attrs []Attribute) map[string]any {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]any, len(attrs))
+	for _, a := range attrs {
+		m[a.Key] = a.Value
+	}
+
+	return m
+}
+
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		case bool:
+			v.BoolValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+
+		out = append(out, otlpAttribute{Key: a.Key, Value: v})
+	}
+
+	return out
+}
@@ -0,0 +1,74 @@
+package traceutil
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SanitizeSQL returns query with its string and numeric literals replaced by ? and its whitespace collapsed,
+// so that it can be recorded without the values it may embed. Placeholders such as $1 and identifiers are kept.
+func SanitizeSQL(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			// a line comment may hold anything, it ends the line
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'':
+			// a quote inside a literal is escaped by doubling it
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case c >= '0' && c <= '9' && !continuesWord(query, i):
+			for i+1 < len(query) && (isDigit(query[i+1]) || query[i+1] == '.' || query[i+1] == 'e' || query[i+1] == 'E') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// continuesWord reports whether the character at i continues an identifier or a placeholder such as $1.
+func continuesWord(query string, i int) bool {
+	if i == 0 {
+		return false
+	}
+
+	prev := rune(query[i-1])
+	return prev == '_' || prev == '$' || prev == '?' || prev == ':' || unicode.IsLetter(prev) || unicode.IsDigit(prev)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,187 @@
+package traceutil
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceparentHeader is the W3C Trace Context header carrying the trace and parent span of a request.
+	TraceparentHeader string = "traceparent"
+
+	// TracestateHeader is the W3C Trace Context header carrying vendor-specific trace state.
+	TracestateHeader string = "tracestate"
+
+	// flagSampled is the trace flag recording that the caller may be recording the trace.
+	flagSampled byte = 0x01
+)
+
+var (
+	// ErrInvalidTraceparent is returned when a traceparent header does not follow the W3C Trace Context format.
+	ErrInvalidTraceparent error = errors.New("invalid traceparent")
+)
+
+type (
+	// TraceID identifies a trace, it is valid when not all zeros.
+	TraceID [16]byte
+
+	// SpanID identifies a span in a trace, it is valid when not all zeros.
+	SpanID [8]byte
+
+	// SpanContext is the part of a span propagated across process boundaries.
+	SpanContext struct {
+		TraceID    TraceID
+		SpanID     SpanID
+		Sampled    bool
+		TraceState string
+		Remote     bool
+	}
+
+	ctxSpan struct{}
+
+	ctxRemote struct{}
+)
+
+var (
+	ctxSpanKey   *ctxSpan   = &ctxSpan{}
+	ctxRemoteKey *ctxRemote = &ctxRemote{}
+)
+
+// String returns the trace id in lowercase hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the trace id is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String returns the span id in lowercase hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the span id is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// IsValid reports whether both ids of the span context are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	var flags byte
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header: version-traceid-parentid-flags in lowercase hex.
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	// version 00 has exactly four fields, later versions may append more
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	if !isLowerHex(traceID, 32) || !isLowerHex(parentID, 16) || !isLowerHex(flags, 2) {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	sc := SpanContext{Remote: true}
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(parentID))
+
+	var f [1]byte
+	hex.Decode(f[:], []byte(flags))
+	sc.Sampled = f[0]&flagSampled != 0
+
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	return sc, nil
+}
+
+// isLowerHex reports whether s is n lowercase hex digits.
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ContextWithSpan returns a copy of ctx carrying span, the parent of the spans started with it.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, ctxSpanKey, span)
+}
+
+// SpanFromContext returns the span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(ctxSpanKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx carrying sc, the parent of the spans started with it
+// when ctx carries no span.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, ctxRemoteKey, sc)
+}
+
+// SpanContextFromContext returns the span context of the span of ctx, or else the remote span context of ctx.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+
+	sc, _ := ctx.Value(ctxRemoteKey).(SpanContext)
+	return sc
+}
+
+// Extract returns a copy of ctx carrying the remote span context of the trace headers of h.
+// Headers that are missing or malformed leave ctx unchanged, so that the request starts a new trace.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	sc.TraceState = h.Get(TracestateHeader)
+
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Inject sets the trace headers of h to the span context of ctx, so that the callee continues its trace.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(TracestateHeader, sc.TraceState)
+	}
+}
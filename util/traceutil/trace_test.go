@@ -0,0 +1,277 @@
+package traceutil_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/anon-org/developing-api-services-with-golang/util/traceutil"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an Exporter keeping the exported spans.
+type recorder struct {
+	mu    sync.Mutex
+	spans []traceutil.SpanData
+}
+
+func (r *recorder) Export(_ context.Context, spans []traceutil.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		traceparent string
+		valid       bool
+		sampled     bool
+	}{
+		{name: "sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: true, sampled: true},
+		{name: "not sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", valid: true},
+		{name: "future version", traceparent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", valid: true, sampled: true},
+		{name: "version ff", traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "version 00 extra field", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "uppercase", traceparent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "zero trace id", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero parent id", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "short", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-01"},
+		{name: "empty"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, err := traceutil.ParseTraceparent(tc.traceparent)
+			if !tc.valid {
+				if !errors.Is(err, traceutil.ErrInvalidTraceparent) {
+					t.Errorf("expected %v, got %v", traceutil.ErrInvalidTraceparent, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || sc.Sampled != tc.sampled || !sc.Remote {
+				t.Errorf("expected the span context of %s, got %+v", tc.traceparent, sc)
+			}
+		})
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	in := http.Header{}
+	in.Set(traceutil.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Set(traceutil.TracestateHeader, "vendor=value")
+
+	tracer := traceutil.NewTracer(traceutil.Config{})
+	defer tracer.Shutdown(context.Background())
+
+	ctx, span := tracer.Start(traceutil.Extract(context.Background(), in), "child", traceutil.SpanKindClient)
+
+	out := http.Header{}
+	traceutil.Inject(ctx, out)
+
+	// the callee continues the trace with the child as its parent
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.SpanContext().SpanID.String() + "-01"
+	if out.Get(traceutil.TraceparentHeader) != expected || out.Get(traceutil.TracestateHeader) != "vendor=value" {
+		t.Errorf("expected %s and vendor=value, got %v", expected, out)
+	}
+
+	// nothing is injected without a trace
+	out = http.Header{}
+	traceutil.Inject(context.Background(), out)
+	if len(out) != 0 {
+		t.Errorf("expected no headers, got %v", out)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	rec := &recorder{}
+	tracer := traceutil.NewTracer(traceutil.Config{Exporter: rec})
+
+	ctx, root := tracer.Start(context.Background(), "root", traceutil.SpanKindServer, traceutil.String("k", "v"))
+	_, child := tracer.Start(ctx, "child", traceutil.SpanKindInternal)
+	child.RecordError(errors.New("boom"))
+	child.End()
+	root.SetAttributes(traceutil.String("k", "replaced"), traceutil.Int("n", 1))
+	root.End()
+	root.End()
+
+	// a remote parent that is not sampled is propagated but not recorded
+	unsampled, err := traceutil.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, skipped := tracer.Start(traceutil.ContextWithRemoteSpanContext(context.Background(), unsampled), "skipped", traceutil.SpanKindServer)
+	if skipped.SpanContext().TraceID != unsampled.TraceID {
+		t.Errorf("expected trace %s, got %s", unsampled.TraceID, skipped.SpanContext().TraceID)
+	}
+	skipped.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(rec.spans))
+	}
+
+	c, r := rec.spans[0], rec.spans[1]
+	if c.Name != "child" || r.Name != "root" {
+		t.Fatalf("expected child then root, got %s then %s", c.Name, r.Name)
+	}
+
+	if c.SpanContext.TraceID != r.SpanContext.TraceID || c.Parent != r.SpanContext.SpanID || r.Parent.IsValid() {
+		t.Errorf("expected child of root in the same trace, got %+v and %+v", c, r)
+	}
+
+	if c.StatusCode != traceutil.StatusError || c.StatusMessage != "boom" || len(c.Events) != 1 || c.Events[0].Name != "exception" {
+		t.Errorf("expected an error status and exception event, got %+v", c)
+	}
+
+	if len(r.Attributes) != 2 || r.Attributes[0].Value != "replaced" || r.Attributes[1].Value != int64(1) {
+		t.Errorf("expected replaced attributes, got %v", r.Attributes)
+	}
+
+	// spans ending after shutdown are dropped
+	_, late := tracer.Start(context.Background(), "late", traceutil.SpanKindInternal)
+	late.End()
+	if err := tracer.Flush(context.Background()); err != nil || len(rec.spans) != 2 {
+		t.Errorf("expected no more spans, got %d %v", len(rec.spans), err)
+	}
+}
+
+func TestTracer_Nil(t *testing.T) {
+	var tracer *traceutil.Tracer
+
+	ctx, span := tracer.Start(context.Background(), "nothing", traceutil.SpanKindInternal)
+	span.SetAttributes(traceutil.Bool("ignored", true))
+	span.RecordError(errors.New("ignored"))
+	span.End()
+
+	if span != nil || traceutil.SpanFromContext(ctx) != nil {
+		t.Errorf("expected no span, got %v", span)
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var b bytes.Buffer
+	tracer := traceutil.NewTracer(traceutil.Config{Exporter: traceutil.NewStdoutExporter(&b)})
+
+	_, span := tracer.Start(context.Background(), "printed", traceutil.SpanKindServer, traceutil.Int("http.response.status_code", 200))
+	span.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var out map[string]any
+	if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatalf("expected a JSON span, got %v: %s", err, b.String())
+	}
+
+	attrs, _ := out["attributes"].(map[string]any)
+	if out["name"] != "printed" || out["kind"] != "server" || out["trace_id"] != span.SpanContext().TraceID.String() || attrs["http.response.status_code"] != float64(200) {
+		t.Errorf("expected the printed span, got %v", out)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		body   []byte
+		header http.Header
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	exporter := traceutil.NewOTLPExporter(traceutil.OTLPConfig{
+		Endpoint:    collector.URL + "/v1/traces",
+		ServiceName: "test-service",
+		Header:      http.Header{"Authorization": {"Bearer token"}},
+	})
+
+	start := time.Unix(1700000000, 5)
+	sc, _ := traceutil.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	err := exporter.Export(context.Background(), []traceutil.SpanData{{
+		Name:        "GET /v1/tasks/",
+		Kind:        traceutil.SpanKindServer,
+		SpanContext: sc,
+		Start:       start,
+		End:         start.Add(time.Millisecond),
+		Attributes:  []traceutil.Attribute{traceutil.Int("http.response.status_code", 500)},
+		StatusCode:  traceutil.StatusError,
+	}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if header.Get("Content-Type") != "application/json" || header.Get("Authorization") != "Bearer token" {
+		t.Errorf("expected a JSON request with the configured header, got %v", header)
+	}
+
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value map[string]any
+				}
+			}
+			ScopeSpans []struct {
+				Spans []map[string]any
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	resource := req.ResourceSpans[0].Resource.Attributes[0]
+	if resource.Key != "service.name" || resource.Value["stringValue"] != "test-service" {
+		t.Errorf("expected the service name, got %v", resource)
+	}
+
+	span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || span["kind"] != float64(2) || span["startTimeUnixNano"] != "1700000000000000005" {
+		t.Errorf("expected the exported span, got %v", span)
+	}
+
+	attr := span["attributes"].([]any)[0].(map[string]any)["value"].(map[string]any)
+	if attr["intValue"] != "500" || span["status"].(map[string]any)["code"] != float64(2) {
+		t.Errorf("expected an int attribute and an error status, got %v", span)
+	}
+
+	// a rejected export is an error
+	collector.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	if err := exporter.Export(context.Background(), nil); err == nil {
+		t.Errorf("expected an error, got none")
+	}
+}
+
+func TestSanitizeSQL(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT id\n\tFROM tasks\n\tWHERE id = $1 AND deleted_at IS NULL": "SELECT id FROM tasks WHERE id = $1 AND deleted_at IS NULL",
+		"SELECT * FROM tasks WHERE name = 'it''s secret' LIMIT 10":        "SELECT * FROM tasks WHERE name = ? LIMIT ?",
+		"UPDATE tasks SET version = version + 1 WHERE id = ?":             "UPDATE tasks SET version = version + ? WHERE id = ?",
+		"SAVEPOINT sp_12": "SAVEPOINT sp_12",
+		"SELECT bm25(tasks_fts), snippet(tasks_fts, 1, '<mark>', '…', 16)": "SELECT bm25(tasks_fts), snippet(tasks_fts, ?, ?, ?, ?)",
+		"DELETE FROM t WHERE x = 1.5e3 -- secret\nAND y = :name":           "DELETE FROM t WHERE x = ? AND y = :name",
+	} {
+		if got := traceutil.SanitizeSQL(query); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+}
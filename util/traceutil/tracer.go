@@ -0,0 +1,419 @@
+package traceutil
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/anon-org/developing-api-services-with-golang/util/logutil"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBatchSize     int           = 512
+	defaultQueueSize     int           = 2048
+	defaultFlushInterval time.Duration = 5 * time.Second
+	defaultExportTimeout time.Duration = 10 * time.Second
+)
+
+// SpanKind is the role of a span in a trace, with the values of the OTLP protocol.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode is the outcome of a span, with the values of the OTLP protocol.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+type (
+	// Attribute is a key-value pair describing a span, its value is a string, int64, float64 or bool.
+	Attribute struct {
+		Key   string
+		Value any
+	}
+
+	// Event is a named point in time of a span.
+	Event struct {
+		Name       string
+		Time       time.Time
+		Attributes []Attribute
+	}
+
+	// SpanData is the immutable record of an ended span, as handed to an Exporter.
+	SpanData struct {
+		Name          string
+		Kind          SpanKind
+		SpanContext   SpanContext
+		Parent        SpanID
+		Start         time.Time
+		End           time.Time
+		Attributes    []Attribute
+		Events        []Event
+		StatusCode    StatusCode
+		StatusMessage string
+	}
+
+	// Span is an operation in a trace. The methods of a nil *Span do nothing, so code can be traced
+	// whether or not a Tracer is configured.
+	Span struct {
+		tracer *Tracer
+		mu     sync.Mutex
+		data   SpanData
+		ended  bool
+	}
+
+	// Exporter sends the ended spans to a backend.
+	Exporter interface {
+		Export(context.Context, []SpanData) error
+	}
+
+	// Config configures NewTracer.
+	Config struct {
+		Exporter Exporter
+
+		// BatchSize is how many spans are exported at once, it defaults to 512.
+		BatchSize int
+
+		// FlushInterval is the longest an ended span waits to be exported, it defaults to 5 seconds.
+		FlushInterval time.Duration
+
+		// Logger records the failed exports, it defaults to logutil.NewStdLogger.
+		Logger *slog.Logger
+
+		// Now is the source of the span timestamps, it defaults to time.Now.
+		Now func() time.Time
+	}
+
+	// Tracer starts spans and exports them in batches from a background goroutine, until Shutdown.
+	// The methods of a nil *Tracer start no spans.
+	Tracer struct {
+		exporter  Exporter
+		batchSize int
+		interval  time.Duration
+		logger    *slog.Logger
+		now       func() time.Time
+
+		queue    chan SpanData
+		flush    chan chan struct{}
+		stop     chan struct{}
+		done     chan struct{}
+		stopOnce sync.Once
+		stopped  atomic.Bool
+		dropped  atomic.Int64
+	}
+)
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// Int64 returns an integer attribute.
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Float64 returns a floating point attribute.
+func Float64(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// NewTracer returns a Tracer exporting to c.Exporter, which must be shut down to export the last spans.
+func NewTracer(c Config) *Tracer {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultFlushInterval
+	}
+
+	if c.Logger == nil {
+		c.Logger = logutil.NewStdLogger()
+	}
+
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+
+	t := &Tracer{
+		exporter:  c.Exporter,
+		batchSize: c.BatchSize,
+		interval:  c.FlushInterval,
+		logger:    c.Logger,
+		now:       c.Now,
+		queue:     make(chan SpanData, defaultQueueSize),
+		flush:     make(chan chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go t.run()
+
+	return t
+}
+
+// Start starts a span named name, the child of the span or remote span context of ctx, and returns a copy of ctx
+// carrying it. A span without a parent starts a new trace, one whose remote parent is not sampled is not recorded.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID, sc.Sampled, sc.TraceState = parent.TraceID, parent.Sampled, parent.TraceState
+	} else if _, err := rand.Read(sc.TraceID[:]); err != nil {
+		return ctx, nil
+	}
+
+	if _, err := rand.Read(sc.SpanID[:]); err != nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Start:       t.now(),
+			Attributes:  attrs,
+		},
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Flush exports the ended spans right away.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil || t.stopped.Load() {
+		return nil
+	}
+
+	reply := make(chan struct{})
+	select {
+	case t.flush <- reply:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the ended spans and stops the tracer, spans ending afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() {
+		t.stopped.Store(true)
+		close(t.stop)
+	})
+
+	select {
+	case <-t.done:
+		if n := t.dropped.Load(); n > 0 {
+			t.logger.Warn("dropped spans", "spans", n)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: failed to export the remaining spans", ctx.Err())
+	}
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	if t.stopped.Load() || !data.SpanContext.Sampled || t.exporter == nil {
+		return
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+		// the exporter cannot keep up, dropping is better than blocking the traced code
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.batchSize)
+	export := func() {
+		if len(batch) == 0 || t.exporter == nil {
+			batch = batch[:0]
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultExportTimeout)
+		defer cancel()
+
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			t.logger.Warn("failed to export spans", "spans", len(batch), "error", err)
+		}
+		batch = make([]SpanData, 0, t.batchSize)
+	}
+
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				if batch = append(batch, data); len(batch) >= t.batchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			if batch = append(batch, data); len(batch) >= t.batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case reply := <-t.flush:
+			drain()
+			close(reply)
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// SpanContext returns the span context of the span, which is invalid for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.data.SpanContext
+}
+
+// SetAttributes adds attrs to the span, replacing the attributes of the same keys. An ended span is left as is.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	for _, a := range attrs {
+		replaced := false
+		for i := range s.data.Attributes {
+			if s.data.Attributes[i].Key == a.Key {
+				s.data.Attributes[i], replaced = a, true
+				break
+			}
+		}
+
+		if !replaced {
+			s.data.Attributes = append(s.data.Attributes, a)
+		}
+	}
+}
+
+// SetStatus sets the outcome of the span, the message is only kept for StatusError.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.data.StatusCode = code
+	if code == StatusError {
+		s.data.StatusMessage = message
+	} else {
+		s.data.StatusMessage = ""
+	}
+}
+
+// RecordError records err as an exception event and sets the status of the span to StatusError.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.data.Events = append(s.data.Events, Event{
+		Name:       "exception",
+		Time:       s.tracer.now(),
+		Attributes: []Attribute{String("exception.type", fmt.Sprintf("%T", err)), String("exception.message", err.Error())},
+	})
+	s.mu.Unlock()
+
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End ends the span and queues it for export, only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.enqueue(data)
+}